@@ -36,16 +36,216 @@ type Account struct {
 	PK      string `json:"pk"`
 }
 
+// ValidatorConfig holds the validator role settings of the node
+type ValidatorConfig struct {
+	Enabled           bool     `json:"enabled"`
+	SignerAddress     string   `json:"signerAddress"`
+	SignerKey         string   `json:"signerKey"` // encrypted priv_validator_key.json
+	Sentries          []string `json:"sentries"`
+	ExecutionSentries []string `json:"executionSentries"`
+}
+
+// ErigonConfig holds the erigon settings the node is started with
+type ErigonConfig struct {
+	Profile             string            `json:"profile"` // archive, full or minimal-pruned, empty means archive
+	HTTPAPI             string            `json:"httpApi"` // comma separated namespaces, empty keeps the erigon default
+	WS                  bool              `json:"ws"`
+	DBSizeLimit         string            `json:"dbSizeLimit"`
+	TorrentDownloadRate string            `json:"torrentDownloadRate"`
+	Metrics             bool              `json:"metrics"`
+	Overrides           map[string]string `json:"overrides"` // advanced flags, without their leading dashes
+}
+
+// SyncSample is the block height of the execution client at a given unix time
+type SyncSample struct {
+	Block uint64 `json:"block"`
+	Time  int64  `json:"time"`
+}
+
+// AlertWebhook is an endpoint alerts are posted to
+type AlertWebhook struct {
+	URL    string `json:"url"`
+	Format string `json:"format"` // json, slack or discord
+}
+
+// AlertRules holds the thresholds of the alert rules, a zero value disables a rule
+type AlertRules struct {
+	HeimdallLagBlocks    int64  `json:"heimdallLagBlocks"`
+	ExecutionLagBlocks   int64  `json:"executionLagBlocks"`
+	LagMinutes           int    `json:"lagMinutes"` // how long a lag has to last before alerting
+	RestartThreshold     int    `json:"restartThreshold"`
+	RestartWindowMinutes int    `json:"restartWindowMinutes"`
+	EthReserve           string `json:"ethReserve"`       // ETH kept for gas
+	RewardsThreshold     string `json:"rewardsThreshold"` // claimable MATIC
+	Unbonding            bool   `json:"unbonding"`        // alert when an unbonding can be withdrawn
+	RepeatMinutes        int    `json:"repeatMinutes"`    // how often a firing alert is sent again
+}
+
+// DefaultAlertRules are the rules of nodes that never configured alerts
+var DefaultAlertRules = AlertRules{
+	HeimdallLagBlocks:    100,
+	ExecutionLagBlocks:   500,
+	LagMinutes:           15,
+	RestartThreshold:     3,
+	RestartWindowMinutes: 15,
+	EthReserve:           "0.01",
+	Unbonding:            true,
+	RepeatMinutes:        240,
+}
+
+// ActiveAlert is an alert that is firing, or resolved but not delivered yet
+type ActiveAlert struct {
+	Message    string `json:"message"`
+	Severity   string `json:"severity"`
+	Since      int64  `json:"since"`
+	NotifiedAt int64  `json:"notifiedAt"` // 0 until a delivery succeeded
+	Resolved   bool   `json:"resolved"`
+}
+
+// RestartSample is the restart count of a container at a given unix time
+type RestartSample struct {
+	Count int   `json:"count"`
+	Time  int64 `json:"time"`
+}
+
+// AlertsState holds the alerts configuration and what is needed to deduplicate them between checks
+type AlertsState struct {
+	Webhooks       []AlertWebhook             `json:"webhooks"`
+	Rules          AlertRules                 `json:"rules"`
+	Active         map[string]ActiveAlert     `json:"active"`
+	Pending        map[string]int64           `json:"pending"` // conditions of rules with a duration, and since when they hold
+	RestartSamples map[string][]RestartSample `json:"restartSamples"`
+}
+
+// WatchdogConfig holds the settings of the watchdog restarting stuck or crashed components
+type WatchdogConfig struct {
+	Enabled        bool `json:"enabled"`
+	StallMinutes   int  `json:"stallMinutes"`   // how long a height may not move before a component is stuck
+	BackoffMinutes int  `json:"backoffMinutes"` // delay before a second attempt, doubled after each attempt
+	MaxAttempts    int  `json:"maxAttempts"`
+}
+
+// DefaultWatchdogConfig is the watchdog configuration of nodes that never configured it
+var DefaultWatchdogConfig = WatchdogConfig{
+	Enabled:        true,
+	StallMinutes:   30,
+	BackoffMinutes: 5,
+	MaxAttempts:    5,
+}
+
+// WatchdogComponent is what the watchdog tracks of a component between checks
+type WatchdogComponent struct {
+	Progress      string `json:"progress"`   // last seen height, or stages of erigon
+	ProgressAt    int64  `json:"progressAt"` // since when the progress did not change
+	Attempts      int    `json:"attempts"`   // restarts since the component was last healthy
+	LastAttemptAt int64  `json:"lastAttemptAt"`
+}
+
+// WatchdogState holds the watchdog configuration and the components it tracks
+type WatchdogState struct {
+	Config     WatchdogConfig               `json:"config"`
+	Components map[string]WatchdogComponent `json:"components"`
+}
+
+// ExposureConfig holds how the node is reached from outside the host
+type ExposureConfig struct {
+	ExternalIP  string `json:"externalIp"`  // advertised to peers, empty means detected
+	APIExposure string `json:"apiExposure"` // public or local, where the API ports are published, empty means public
+	// NATMode is extip, upnp or pmp, empty means extip. With upnp and pmp the P2P ports are mapped on the gateway.
+	NATMode          string   `json:"natMode"`
+	IPEchoServices   []string `json:"ipEchoServices"`   // services detecting the external IP, empty means the default ones
+	LastDetectedIP   string   `json:"lastDetectedIp"`   // used when no echo service answers
+	PortsMappedUntil int64    `json:"portsMappedUntil"` // unix time the gateway port mappings expire
+}
+
+// OfflineConfig holds where an offline node takes what it would download otherwise, empty paths mean online
+type OfflineConfig struct {
+	ImagesPath       string `json:"imagesPath"`       // folder of docker save archives listed in a SHA256SUMS file
+	HeimdallSnapshot string `json:"heimdallSnapshot"` // folder of heimdall snapshot archives listed in a SHA256SUMS file
+	// HelperImage replaces alpine for the maintenance containers, it has to ship the packages they install
+	HelperImage string `json:"helperImage"`
+}
+
+// InstallProgress records what a failed install did, so it can be resumed or rolled back
+type InstallProgress struct {
+	FailedStep   string   `json:"failedStep"`
+	Error        string   `json:"error"`
+	FailedAt     int64    `json:"failedAt"`
+	PulledImages []string `json:"pulledImages"` // images that were not there before install, removed by a rollback
+}
+
+// HistoryEntry is an action taken on the node
+type HistoryEntry struct {
+	Time      int64  `json:"time"`
+	Source    string `json:"source"` // what took the action, such as watchdog
+	Component string `json:"component"`
+	Action    string `json:"action"`
+	Message   string `json:"message"`
+}
+
+// PreservedData records what an uninstall kept for the next install to reuse
+type PreservedData struct {
+	ChainData       bool   `json:"chainData"`       // the heimdall and execution client data were kept
+	Network         string `json:"network"`         // network of the chain data
+	ExecutionClient string `json:"executionClient"` // execution client of the chain data
+	ErigonProfile   string `json:"erigonProfile"`   // erigon refuses chain data of another profile
+	Wallet          bool   `json:"wallet"`          // the wallet and the configuration were kept
+	At              int64  `json:"at"`
+}
+
+// DataPaths holds where the chain data is on the host, empty paths mean the data folder of the plugin
+type DataPaths struct {
+	Heimdall  string `json:"heimdall"`
+	Execution string `json:"execution"`
+}
+
+// DataMigration is a move of chain data waiting for the node to be healthy before removing the old copy
+type DataMigration struct {
+	Component  string `json:"component"` // heimdall or execution
+	OldPath    string `json:"oldPath"`
+	NewPath    string `json:"newPath"`
+	MigratedAt int64  `json:"migratedAt"`
+	// the old copy is removed once the height moved past this one, the height at migration or when first healthy
+	Height       uint64 `json:"height"`
+	HealthySince int64  `json:"healthySince"` // 0 while the node is not healthy on the new copy
+}
+
+// maxHistoryEntries is how many entries the history keeps, older ones are dropped
+const maxHistoryEntries = 200
+
 type AppState struct {
-	State                      AppStateEnum `json:"state"`
-	IsTestnet                  bool         `json:"isTestnet"`
-	HeimdallSnapshotDownloaded bool         `json:"heimdallSnapshotDownloaded"`
-	Wallet                     Account      `json:"wallet"`
-	RPC                        string       `json:"rpc"`
+	State                      AppStateEnum    `json:"state"`
+	Network                    string          `json:"network"`
+	ExecutionClient            string          `json:"executionClient"` // empty on nodes installed before bor was supported, meaning erigon
+	HeimdallSnapshotDownloaded bool            `json:"heimdallSnapshotDownloaded"`
+	ErigonSnapshotSource       string          `json:"erigonSnapshotSource"`
+	ErigonSnapshotDownloaded   bool            `json:"erigonSnapshotDownloaded"`
+	HeimdallPruning            string          `json:"heimdallPruning"`
+	HeimdallReferenceEndpoints []string        `json:"heimdallReferenceEndpoints"`
+	Wallet                     Account         `json:"wallet"`
+	Validator                  ValidatorConfig `json:"validator"`
+	Erigon                     ErigonConfig    `json:"erigon"`
+	ExecutionSyncSamples       []SyncSample    `json:"executionSyncSamples"`
+	Alerts                     AlertsState     `json:"alerts"`
+	Watchdog                   WatchdogState   `json:"watchdog"`
+	History                    []HistoryEntry  `json:"history"`
+	Install                    InstallProgress `json:"install"`
+	Exposure                   ExposureConfig  `json:"exposure"`
+	BorRPCURL                  string          `json:"borRpcUrl"` // RPC of the execution client heimdall uses, empty means the local container
+	Preserved                  PreservedData   `json:"preserved"`
+	DataPaths                  DataPaths       `json:"dataPaths"`
+	Migration                  *DataMigration  `json:"migration"` // nil when no migration is pending
+	Offline                    OfflineConfig   `json:"offline"`
+	RPC                        string          `json:"rpc"`
+}
+
+// NewState returns the state of a node that was never installed.
+func NewState() AppState {
+	return AppState{State: NoState, Network: DefaultNetwork, Wallet: Account{Address: "", PK: ""}, Alerts: AlertsState{Rules: DefaultAlertRules}, Watchdog: WatchdogState{Config: DefaultWatchdogConfig}}
 }
 
 // CurrentState holds the current state of the application.
-var CurrentState AppState = AppState{State: NoState, IsTestnet: false, Wallet: Account{Address: "", PK: ""}}
+var CurrentState AppState = NewState()
 
 func CurrentStateString() string {
 	switch CurrentState.State {
@@ -84,14 +284,26 @@ func CurrentStateString() string {
 
 // UpdateState updates the current state and writes it to disk.
 func UpdateState(newState AppStateEnum) error {
-	CurrentState.State = newState
-	return writeStateToFile(CurrentState)
+	return update(func(state *AppState) {
+		state.State = newState
+	})
 }
 
-// UpdateChain updates the current state and writes it to disk.
-func UpdateChain(isTestnet bool) error {
-	CurrentState.IsTestnet = isTestnet
-	return writeStateToFile(CurrentState)
+// UpdateNetwork updates the current state and writes it to disk.
+func UpdateNetwork(network string) error {
+	if _, exists := Networks[network]; !exists {
+		return fmt.Errorf("unknown network %s", network)
+	}
+	return update(func(state *AppState) {
+		state.Network = network
+	})
+}
+
+// UpdateExecutionClient updates the current state and writes it to disk.
+func UpdateExecutionClient(client string) error {
+	return update(func(state *AppState) {
+		state.ExecutionClient = client
+	})
 }
 
 // ConvertPrivateKeyToBase64 takes a private key as a hex string and converts it to a Base64 string.
@@ -109,56 +321,264 @@ func ConvertPrivateKeyToBase64(privateKeyHex string) (string, error) {
 
 // UpdateAccount updates the current state and writes it to disk.
 func UpdateAccount(privateKey string, publicKey string) error {
-	CurrentState.Wallet.Address = publicKey
 	key, err := ConvertPrivateKeyToBase64(privateKey)
 	if err != nil {
 		return err
 	}
-	CurrentState.Wallet.PK = key
-	return writeStateToFile(CurrentState)
+	return update(func(state *AppState) {
+		state.Wallet.Address = publicKey
+		state.Wallet.PK = key
+	})
 }
 
 // UpdateSnapshotDownloaded updates the current state and writes it to disk.
 func UpdateSnapshotDownloaded(downloaded bool) error {
-	CurrentState.HeimdallSnapshotDownloaded = downloaded
-	return writeStateToFile(CurrentState)
+	return update(func(state *AppState) {
+		state.HeimdallSnapshotDownloaded = downloaded
+	})
+}
+
+// UpdateErigonSnapshotSource updates the current state and writes it to disk.
+func UpdateErigonSnapshotSource(source string) error {
+	return update(func(state *AppState) {
+		state.ErigonSnapshotSource = source
+	})
+}
+
+// UpdateErigonSnapshotDownloaded updates the current state and writes it to disk.
+func UpdateErigonSnapshotDownloaded(downloaded bool) error {
+	return update(func(state *AppState) {
+		state.ErigonSnapshotDownloaded = downloaded
+	})
+}
+
+// UpdateHeimdallPruning updates the current state and writes it to disk.
+func UpdateHeimdallPruning(pruning string) error {
+	return update(func(state *AppState) {
+		state.HeimdallPruning = pruning
+	})
+}
+
+// UpdateHeimdallReferenceEndpoints updates the current state and writes it to disk.
+func UpdateHeimdallReferenceEndpoints(endpoints []string) error {
+	return update(func(state *AppState) {
+		state.HeimdallReferenceEndpoints = endpoints
+	})
+}
+
+// UpdateValidator updates the current state and writes it to disk.
+func UpdateValidator(validator ValidatorConfig) error {
+	return update(func(state *AppState) {
+		state.Validator = validator
+	})
+}
+
+// UpdateErigonConfig updates the current state and writes it to disk.
+func UpdateErigonConfig(config ErigonConfig) error {
+	return update(func(state *AppState) {
+		state.Erigon = config
+	})
+}
+
+// UpdateExecutionSyncSamples updates the current state and writes it to disk.
+func UpdateExecutionSyncSamples(samples []SyncSample) error {
+	return update(func(state *AppState) {
+		state.ExecutionSyncSamples = samples
+	})
+}
+
+// UpdateAlerts updates the current state and writes it to disk.
+func UpdateAlerts(alerts AlertsState) error {
+	return update(func(state *AppState) {
+		state.Alerts = alerts
+	})
+}
+
+// UpdateWatchdog updates the current state and writes it to disk.
+func UpdateWatchdog(watchdog WatchdogState) error {
+	return update(func(state *AppState) {
+		state.Watchdog = watchdog
+	})
+}
+
+// UpdateExposure updates the current state and writes it to disk.
+func UpdateExposure(exposure ExposureConfig) error {
+	return update(func(state *AppState) {
+		state.Exposure = exposure
+	})
+}
+
+// UpdateBorRPCURL updates the current state and writes it to disk.
+func UpdateBorRPCURL(url string) error {
+	return update(func(state *AppState) {
+		state.BorRPCURL = url
+	})
+}
+
+// UpdateInstall updates the current state and writes it to disk.
+func UpdateInstall(install InstallProgress) error {
+	return update(func(state *AppState) {
+		state.Install = install
+	})
+}
+
+// AppendHistory adds an entry to the history and writes it to disk.
+func AppendHistory(entry HistoryEntry) error {
+	return update(func(state *AppState) {
+		state.History = append(state.History, entry)
+		if len(state.History) > maxHistoryEntries {
+			state.History = state.History[len(state.History)-maxHistoryEntries:]
+		}
+	})
+}
+
+// UpdateDataPaths updates the current state and writes it to disk.
+func UpdateDataPaths(paths DataPaths) error {
+	return update(func(state *AppState) {
+		state.DataPaths = paths
+	})
+}
+
+// UpdateMigration updates the current state and writes it to disk.
+func UpdateMigration(migration *DataMigration) error {
+	return update(func(state *AppState) {
+		state.Migration = migration
+	})
+}
+
+// UpdateOffline updates the current state and writes it to disk.
+func UpdateOffline(offline OfflineConfig) error {
+	return update(func(state *AppState) {
+		state.Offline = offline
+	})
+}
+
+// UpdatePreserved updates the current state and writes it to disk.
+func UpdatePreserved(preserved PreservedData) error {
+	return update(func(state *AppState) {
+		state.Preserved = preserved
+	})
+}
+
+// ResetState replaces the whole current state and writes it to disk.
+func ResetState(state AppState) error {
+	return update(func(current *AppState) {
+		*current = state
+	})
 }
 
 // UpdateSnapshotDownloaded updates the current state and writes it to disk.
 func UpdateRPC(rpc string) error {
-	CurrentState.RPC = rpc
-	return writeStateToFile(CurrentState)
+	return update(func(state *AppState) {
+		state.RPC = rpc
+	})
 }
 
 // LoadState loads the current state from the file, if it exists.
 func LoadState() error {
+	state, err := readStateFile()
+	if err != nil || state == nil {
+		return err
+	}
+	CurrentState = *state
+	return nil
+}
+
+// readStateFile reads the state written to disk, nil if there is none yet.
+func readStateFile() (*AppState, error) {
 	path, err := GetStoragePath()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	filePath := filepath.Join(path, "state.json")
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		// State file does not exist, no state to load
-		return nil
+		return nil, nil
 	}
 
 	stateJSON, err := os.ReadFile(filePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var state AppState
 	err = json.Unmarshal(stateJSON, &state)
+	if err != nil {
+		return nil, err
+	}
+
+	if state.Network == "" {
+		// states written before the network registry only knew mainnet and mumbai
+		var legacy struct {
+			IsTestnet bool `json:"isTestnet"`
+		}
+		_ = json.Unmarshal(stateJSON, &legacy)
+		state.Network = DefaultNetwork
+		if legacy.IsTestnet {
+			state.Network = "mumbai"
+		}
+	}
+
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(stateJSON, &fields) == nil {
+		if _, exists := fields["alerts"]; !exists {
+			// states written before alerts existed
+			state.Alerts.Rules = DefaultAlertRules
+		}
+		if _, exists := fields["watchdog"]; !exists {
+			// states written before the watchdog existed
+			state.Watchdog.Config = DefaultWatchdogConfig
+		}
+	}
+
+	return &state, nil
+}
+
+// update applies a change to the state under the state lock. Every task runs in its own process, possibly next to a
+// long one like install or backup, so the change is applied to the state on disk rather than to the copy loaded when
+// the process started, which is refreshed.
+func update(change func(state *AppState)) error {
+	unlock, err := Lock("state")
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	CurrentState = state
-	return nil
+	latest, err := readStateFile()
+	if err != nil {
+		return err
+	}
+	if latest != nil {
+		CurrentState = *latest
+	}
+	change(&CurrentState)
+	return writeStateToFile(CurrentState)
 }
 
-// writeStateToFile writes the current state to a file in JSON format.
+// Lock takes the named lock shared by the plugin processes, <name>.lock in the storage folder, waiting for another
+// process to release it, and returns its release. The state lock only guards the writes of the state.
+func Lock(name string) (func(), error) {
+	path, err := GetStoragePath()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(path, name+".lock"), os.O_CREATE|os.O_RDWR, fs.FileMode(0644))
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error taking the %s lock: %v", name, err)
+	}
+	return func() {
+		unlockFile(file)
+		file.Close()
+	}, nil
+}
+
+// writeStateToFile writes the current state to a file in JSON format. It is replaced at once, so other processes
+// never read a partially written state.
 func writeStateToFile(state AppState) error {
 	stateJSON, err := json.Marshal(state)
 	if err != nil {
@@ -170,7 +590,12 @@ func writeStateToFile(state AppState) error {
 		return err
 	}
 
-	return os.WriteFile(filepath.Join(path, "state.json"), stateJSON, fs.FileMode(0644))
+	temporary := filepath.Join(path, "state.json.tmp")
+	err = os.WriteFile(temporary, stateJSON, fs.FileMode(0644))
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporary, filepath.Join(path, "state.json"))
 }
 
 // GetStoragePath gets the path to the storage directory.
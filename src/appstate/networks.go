@@ -0,0 +1,79 @@
+package appstate
+
+// Network describes a polygon network the node can be installed on
+type Network struct {
+	Name                string   `json:"name"`
+	Testnet             bool     `json:"testnet"`
+	Deprecated          bool     `json:"deprecated"`
+	HeimdallChain       string   `json:"heimdallChain"`       // --chain flag of heimdall init
+	ErigonChain         string   `json:"erigonChain"`         // --chain flag of erigon
+	BorChain            string   `json:"borChain"`            // --chain flag of bor
+	SnapshotNetwork     string   `json:"snapshotNetwork"`     // --network flag of snapdown
+	HeimdallChainID     string   `json:"heimdallChainId"`     // network reported by heimdall
+	L1ChainID           int64    `json:"l1ChainId"`           // chain id of the ethereum network holding the contracts
+	MaticAddress        string   `json:"maticAddress"`        // MATIC token on L1
+	StakeManagerAddress string   `json:"stakeManagerAddress"` // StakeManager proxy on L1
+	StakingAPIURL       string   `json:"stakingApiUrl"`
+	HeimdallAPIURLs     []string `json:"heimdallApiUrls"`
+}
+
+// DefaultNetwork is the network used when none was selected
+const DefaultNetwork = "mainnet"
+
+// DefaultTestnet is the network used when installing with the testnet flag only
+const DefaultTestnet = "amoy"
+
+// Networks is the registry of the supported networks
+var Networks = map[string]Network{
+	"mainnet": {
+		Name:                "mainnet",
+		HeimdallChain:       "mainnet",
+		ErigonChain:         "bor-mainnet",
+		BorChain:            "mainnet",
+		SnapshotNetwork:     "mainnet",
+		HeimdallChainID:     "heimdall-137",
+		L1ChainID:           1,
+		MaticAddress:        "0x7D1AfA7B718fb893dB30A3aBc0Cfc608AaCfeBB0",
+		StakeManagerAddress: "0x5e3Ef299fDDf15eAa0432E6e66473ace8c13D908",
+		StakingAPIURL:       "https://staking-api.polygon.technology",
+		HeimdallAPIURLs:     []string{"https://heimdall-api.polygon.technology"},
+	},
+	"amoy": {
+		Name:                "amoy",
+		Testnet:             true,
+		HeimdallChain:       "amoy",
+		ErigonChain:         "amoy",
+		BorChain:            "amoy",
+		SnapshotNetwork:     "amoy",
+		HeimdallChainID:     "heimdall-80002",
+		L1ChainID:           11155111,
+		MaticAddress:        "0x3fd0A53F4Bf853985a95F4Eb3F9C9FDE1F8e2b53",
+		StakeManagerAddress: "0x4AE8f648B1Ec892B6cc68C89cc088583964d08bE",
+		StakingAPIURL:       "https://staking-api-amoy.polygon.technology",
+		HeimdallAPIURLs:     []string{"https://heimdall-api-amoy.polygon.technology"},
+	},
+	"mumbai": {
+		Name:                "mumbai",
+		Testnet:             true,
+		Deprecated:          true,
+		HeimdallChain:       "mumbai",
+		ErigonChain:         "mumbai",
+		BorChain:            "mumbai",
+		SnapshotNetwork:     "mumbai",
+		HeimdallChainID:     "heimdall-80001",
+		L1ChainID:           5,
+		MaticAddress:        "0x499d11E0b6eAC7c0593d8Fb292DCBbF815Fb29Ae",
+		StakeManagerAddress: "0x00200eA4Ee292E253E6Ca07dBA5EdC07c8Aa37A3",
+		StakingAPIURL:       "https://staking-api-testnet.polygon.technology",
+		HeimdallAPIURLs:     []string{"https://heimdall-api-testnet.polygon.technology"},
+	},
+}
+
+// CurrentNetwork returns the definition of the network the node is installed on.
+func CurrentNetwork() Network {
+	network, exists := Networks[CurrentState.Network]
+	if !exists {
+		return Networks[DefaultNetwork]
+	}
+	return network
+}
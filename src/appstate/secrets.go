@@ -0,0 +1,92 @@
+package appstate
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// SecretKeyFile holds the key encrypting the secrets kept in the state, it never leaves the storage folder
+const SecretKeyFile = "secret.key"
+
+// loadSecretKey reads the key of the encrypted store, creating it on first use.
+func loadSecretKey() ([]byte, error) {
+	path, err := GetStoragePath()
+	if err != nil {
+		return nil, err
+	}
+	filePath := filepath.Join(path, SecretKeyFile)
+
+	key, err := os.ReadFile(filePath)
+	if err == nil {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("invalid secret key length")
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key = make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filePath, key, fs.FileMode(0600)); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// EncryptSecret encrypts a secret with AES-GCM so it can be kept in the state.
+func EncryptSecret(secret []byte) (string, error) {
+	key, err := loadSecretKey()
+	if err != nil {
+		return "", fmt.Errorf("error loading secret key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, secret, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret decrypts a secret encrypted with EncryptSecret.
+func DecryptSecret(encrypted string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding secret: %v", err)
+	}
+	key, err := loadSecretKey()
+	if err != nil {
+		return nil, fmt.Errorf("error loading secret key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid secret")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
@@ -21,6 +21,7 @@ type AppResult struct {
 var version string
 
 func main() {
+	tasks.Version = version
 	if len(os.Args) >= 2 {
 		if os.Args[1] == "--version" {
 			fmt.Print(version)
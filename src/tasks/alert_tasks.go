@@ -0,0 +1,496 @@
+package tasks
+
+import (
+	"KeepixPlugin/appstate"
+	"KeepixPlugin/utils"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const validatorUnbondABI = `[
+	{"constant": true, "inputs": [{"name": "", "type": "address"}], "name": "unbondNonces", "outputs": [{"name": "", "type": "uint256"}], "payable": false, "stateMutability": "view", "type": "function"},
+	{"constant": true, "inputs": [{"name": "", "type": "address"}, {"name": "", "type": "uint256"}], "name": "unbonds_new", "outputs": [{"name": "shares", "type": "uint256"}, {"name": "withdrawEpoch", "type": "uint256"}], "payable": false, "stateMutability": "view", "type": "function"}
+]`
+
+// maxCheckedUnbonds is how many of the latest unbonds are checked for each validator
+const maxCheckedUnbonds = 5
+
+// alertContext holds what the rules share during a check
+type alertContext struct {
+	rules      appstate.AlertRules
+	alerts     *appstate.AlertsState
+	now        int64
+	validators []Validator
+}
+
+// alertRule evaluates a condition, an error keeps the alerts of the rule as they are
+type alertRule struct {
+	name  string
+	check func(ctx *alertContext) (map[string]utils.AlertCondition, error)
+}
+
+var alertRules = []alertRule{
+	{"node_not_alive", checkNodeAlive},
+	{"heimdall_lag", checkHeimdallLag},
+	{"execution_lag", checkExecutionLag},
+	{"container_restart_loop", checkRestartLoops},
+	{"eth_balance_low", checkEthBalance},
+	{"rewards_claimable", checkRewards},
+	{"unbonding_ready", checkUnbondings},
+}
+
+// nodeContainers returns the containers expected to run when the node is started
+func nodeContainers() []string {
+	containers := []string{}
+	if appstate.CurrentState.HeimdallSnapshotDownloaded {
+		containers = append(containers, "heimdall", "heimdall-rest")
+	}
+	if appstate.CurrentState.ErigonSnapshotSource == "" || appstate.CurrentState.ErigonSnapshotDownloaded {
+		containers = append(containers, utils.CurrentExecutionClient().ContainerName())
+	}
+	if appstate.CurrentState.Validator.Enabled && appstate.CurrentState.HeimdallSnapshotDownloaded {
+		containers = append(containers, "rabbitmq")
+	}
+	return containers
+}
+
+// fetchValidators fetches all the validators once per check, an unbonding can be pending on a validator the wallet no
+// longer has a stake on
+func (ctx *alertContext) fetchValidators() ([]Validator, error) {
+	if ctx.validators == nil {
+		validators, err := fetchAllValidators()
+		if err != nil {
+			return nil, err
+		}
+		ctx.validators = validators
+	}
+	return ctx.validators, nil
+}
+
+// heldFor tracks since when a condition holds and returns true once it held for the given minutes
+func (ctx *alertContext) heldFor(key string, holds bool, minutes int) bool {
+	if !holds {
+		delete(ctx.alerts.Pending, key)
+		return false
+	}
+	since, exists := ctx.alerts.Pending[key]
+	if !exists {
+		since = ctx.now
+		ctx.alerts.Pending[key] = since
+	}
+	return ctx.now-since >= int64(minutes)*60
+}
+
+func checkNodeAlive(ctx *alertContext) (map[string]utils.AlertCondition, error) {
+	if appstate.CurrentState.State != appstate.NodeStarted {
+		return nil, nil
+	}
+	var issues []string
+	for _, container := range nodeContainers() {
+		info, err := utils.InspectContainer(container)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Running {
+			issues = append(issues, container+" is not running")
+		}
+	}
+	if appstate.CurrentState.HeimdallSnapshotDownloaded {
+		if _, err := utils.GetHeimdallLocalStatus(); err != nil {
+			issues = append(issues, "heimdall does not answer")
+		}
+	}
+	if len(issues) == 0 {
+		return nil, nil
+	}
+	return map[string]utils.AlertCondition{"node_not_alive": {Severity: "critical", Message: "Node is not alive: " + strings.Join(issues, ", ")}}, nil
+}
+
+func checkHeimdallLag(ctx *alertContext) (map[string]utils.AlertCondition, error) {
+	if ctx.rules.HeimdallLagBlocks <= 0 || appstate.CurrentState.State != appstate.NodeStarted || !appstate.CurrentState.HeimdallSnapshotDownloaded {
+		ctx.heldFor("heimdall_lag", false, 0)
+		return nil, nil
+	}
+	status, err := utils.GetHeimdallNodeStatus()
+	if err != nil {
+		return nil, err
+	}
+	height, _ := strconv.ParseInt(status.Result.SyncInfo.LatestBlockHeight, 10, 64)
+	tip, _ := strconv.ParseInt(status.Result.SyncInfo.CurrentBlockHeight, 10, 64)
+	lag := tip - height
+	if !ctx.heldFor("heimdall_lag", lag > ctx.rules.HeimdallLagBlocks, ctx.rules.LagMinutes) {
+		return nil, nil
+	}
+	return map[string]utils.AlertCondition{"heimdall_lag": {Severity: "warning", Message: fmt.Sprintf("Heimdall is %d blocks behind", lag)}}, nil
+}
+
+func checkExecutionLag(ctx *alertContext) (map[string]utils.AlertCondition, error) {
+	pending := appstate.CurrentState.ErigonSnapshotSource != "" && !appstate.CurrentState.ErigonSnapshotDownloaded
+	if ctx.rules.ExecutionLagBlocks <= 0 || appstate.CurrentState.State != appstate.NodeStarted || pending {
+		ctx.heldFor("execution_lag", false, 0)
+		return nil, nil
+	}
+	client := utils.CurrentExecutionClient()
+	status, err := client.SyncingStatus()
+	if err != nil {
+		return nil, err
+	}
+	var lag int64
+	if status.Stage != "Synced" && status.HighestBlock > status.CurrentBlock {
+		lag = int64(status.HighestBlock - status.CurrentBlock)
+	}
+	if !ctx.heldFor("execution_lag", lag > ctx.rules.ExecutionLagBlocks, ctx.rules.LagMinutes) {
+		return nil, nil
+	}
+	return map[string]utils.AlertCondition{"execution_lag": {Severity: "warning", Message: fmt.Sprintf("%s is %d blocks behind", client.Name(), lag)}}, nil
+}
+
+func checkRestartLoops(ctx *alertContext) (map[string]utils.AlertCondition, error) {
+	if ctx.rules.RestartThreshold <= 0 {
+		ctx.alerts.RestartSamples = map[string][]appstate.RestartSample{}
+		return nil, nil
+	}
+	conditions := make(map[string]utils.AlertCondition)
+	samples := make(map[string][]appstate.RestartSample)
+	for _, container := range []string{"heimdall", "heimdall-rest", utils.CurrentExecutionClient().ContainerName(), "rabbitmq"} {
+		info, err := utils.InspectContainer(container)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Exists {
+			continue
+		}
+		// keep the samples of the window, a lower count means the container was recreated
+		var kept []appstate.RestartSample
+		for _, sample := range ctx.alerts.RestartSamples[container] {
+			if sample.Count <= info.RestartCount && ctx.now-sample.Time <= int64(ctx.rules.RestartWindowMinutes)*60 {
+				kept = append(kept, sample)
+			}
+		}
+		kept = append(kept, appstate.RestartSample{Count: info.RestartCount, Time: ctx.now})
+		samples[container] = kept
+
+		restarts := info.RestartCount - kept[0].Count
+		if restarts >= ctx.rules.RestartThreshold || (info.Restarting && restarts > 0) {
+			conditions["container_restart_loop:"+container] = utils.AlertCondition{
+				Severity: "critical",
+				Message:  fmt.Sprintf("%s restarted %d times in the last %d minutes", container, restarts, ctx.rules.RestartWindowMinutes),
+			}
+		}
+	}
+	ctx.alerts.RestartSamples = samples
+	return conditions, nil
+}
+
+// etherToWei parses an ether amount such as "0.05"
+func etherToWei(amount string) (*big.Int, error) {
+	ether, success := new(big.Float).SetPrec(256).SetString(amount)
+	if !success || ether.Sign() < 0 {
+		return nil, fmt.Errorf("invalid amount %s", amount)
+	}
+	wei, _ := new(big.Float).SetPrec(256).Mul(ether, big.NewFloat(1e18)).Int(nil)
+	return wei, nil
+}
+
+func checkEthBalance(ctx *alertContext) (map[string]utils.AlertCondition, error) {
+	if ctx.rules.EthReserve == "" || appstate.CurrentState.Wallet.Address == "" {
+		return nil, nil
+	}
+	reserve, err := etherToWei(ctx.rules.EthReserve)
+	if err != nil {
+		return nil, err
+	}
+	client, err := utils.NewBlockchainClient(appstate.CurrentState.RPC)
+	if err != nil {
+		return nil, err
+	}
+	balance, err := client.GetETHBalance(appstate.CurrentState.Wallet.Address)
+	if err != nil {
+		return nil, err
+	}
+	if balance.Cmp(reserve) >= 0 {
+		return nil, nil
+	}
+	return map[string]utils.AlertCondition{"eth_balance_low": {
+		Severity: "warning",
+		Message:  fmt.Sprintf("Wallet %s holds %s ETH, below the gas reserve of %s ETH", appstate.CurrentState.Wallet.Address, weiToEther(balance), ctx.rules.EthReserve),
+	}}, nil
+}
+
+func checkRewards(ctx *alertContext) (map[string]utils.AlertCondition, error) {
+	if ctx.rules.RewardsThreshold == "" || appstate.CurrentState.Wallet.Address == "" {
+		return nil, nil
+	}
+	threshold, err := etherToWei(ctx.rules.RewardsThreshold)
+	if err != nil {
+		return nil, err
+	}
+	validators, err := ctx.fetchValidators()
+	if err != nil {
+		return nil, err
+	}
+	total := big.NewInt(0)
+	for _, validator := range validators {
+		reward, err := etherToWei(validator.UserReward)
+		if err == nil {
+			total.Add(total, reward)
+		}
+	}
+	if total.Sign() == 0 || total.Cmp(threshold) < 0 {
+		return nil, nil
+	}
+	return map[string]utils.AlertCondition{"rewards_claimable": {
+		Severity: "info",
+		Message:  fmt.Sprintf("%s MATIC of rewards can be claimed", weiToEther(total)),
+	}}, nil
+}
+
+func checkUnbondings(ctx *alertContext) (map[string]utils.AlertCondition, error) {
+	if !ctx.rules.Unbonding || appstate.CurrentState.Wallet.Address == "" {
+		return nil, nil
+	}
+	validators, err := ctx.fetchValidators()
+	if err != nil {
+		return nil, err
+	}
+	client, err := utils.NewBlockchainClient(appstate.CurrentState.RPC)
+	if err != nil {
+		return nil, err
+	}
+	currentEpoch, err := readStakeManagerUint(client, "currentEpoch")
+	if err != nil {
+		return nil, err
+	}
+	withdrawalDelay, err := readStakeManagerUint(client, "withdrawalDelay")
+	if err != nil {
+		return nil, err
+	}
+
+	user := common.HexToAddress(appstate.CurrentState.Wallet.Address)
+	conditions := make(map[string]utils.AlertCondition)
+	for _, validator := range validators {
+		result, err := client.CallReadOnlyFunction(validator.ContractAddress, validatorUnbondABI, "unbondNonces", user)
+		if err != nil {
+			return nil, err
+		}
+		nonce, ok := result[0].(*big.Int)
+		if !ok {
+			return nil, fmt.Errorf("error converting unbondNonces result")
+		}
+		for i := int64(0); i < maxCheckedUnbonds && nonce.Int64()-i > 0; i++ {
+			unbond, err := client.CallReadOnlyFunction(validator.ContractAddress, validatorUnbondABI, "unbonds_new", user, big.NewInt(nonce.Int64()-i))
+			if err != nil {
+				return nil, err
+			}
+			shares, sharesOk := unbond[0].(*big.Int)
+			withdrawEpoch, epochOk := unbond[1].(*big.Int)
+			if !sharesOk || !epochOk {
+				return nil, fmt.Errorf("error converting unbonds_new result")
+			}
+			if shares.Sign() > 0 && new(big.Int).Add(withdrawEpoch, withdrawalDelay).Cmp(currentEpoch) <= 0 {
+				conditions[fmt.Sprintf("unbonding_ready:%d", validator.Id)] = utils.AlertCondition{
+					Severity: "info",
+					Message:  fmt.Sprintf("Unbonding from validator %s can be withdrawn", validator.Name),
+				}
+				break
+			}
+		}
+	}
+	return conditions, nil
+}
+
+// deliverAlert sends an alert to all the webhooks, it is delivered once a webhook accepted it
+func deliverAlert(alert utils.Alert) bool {
+	delivered := false
+	for _, webhook := range appstate.CurrentState.Alerts.Webhooks {
+		err := utils.SendAlert(webhook.URL, webhook.Format, alert)
+		if err != nil {
+			fmt.Println("Error sending alert to " + webhook.URL + ":" + err.Error())
+			continue
+		}
+		delivered = true
+	}
+	return delivered
+}
+
+type AlertStatus struct {
+	Key      string `json:"key"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Since    int64  `json:"since"`
+	Notified bool   `json:"notified"`
+}
+
+type AlertsCheckResponse struct {
+	Active []AlertStatus `json:"active"`
+	Errors []string      `json:"errors"`
+}
+
+// activeAlerts lists the firing alerts sorted by key
+func activeAlerts() []AlertStatus {
+	statuses := []AlertStatus{}
+	for key, alert := range appstate.CurrentState.Alerts.Active {
+		if alert.Resolved {
+			continue
+		}
+		statuses = append(statuses, AlertStatus{Key: key, Severity: alert.Severity, Message: alert.Message, Since: alert.Since, Notified: alert.NotifiedAt != 0})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Key < statuses[j].Key })
+	return statuses
+}
+
+// alertsCheckTask evaluates the alert rules and notifies the webhooks of new and resolved alerts.
+// It is meant to be run periodically, a firing alert is only sent again every repeatMinutes.
+func alertsCheckTask(args map[string]string) string {
+	alerts := appstate.CurrentState.Alerts
+	if alerts.Active == nil {
+		alerts.Active = make(map[string]appstate.ActiveAlert)
+	}
+	if alerts.Pending == nil {
+		alerts.Pending = make(map[string]int64)
+	}
+	ctx := &alertContext{rules: alerts.Rules, alerts: &alerts, now: time.Now().Unix()}
+
+	response := AlertsCheckResponse{Errors: []string{}}
+	firing := make(map[string]utils.AlertCondition)
+	failedRules := make(map[string]bool)
+	for _, rule := range alertRules {
+		conditions, err := rule.check(ctx)
+		if err != nil {
+			response.Errors = append(response.Errors, rule.name+": "+err.Error())
+			failedRules[rule.name] = true
+			continue
+		}
+		for key, condition := range conditions {
+			firing[key] = condition
+		}
+	}
+
+	network := appstate.CurrentNetwork().Name
+	utils.ReconcileAlerts(alerts.Active, firing, failedRules, alerts.Rules.RepeatMinutes, ctx.now, func(key string, status string, active appstate.ActiveAlert) bool {
+		return deliverAlert(utils.Alert{Key: key, Status: status, Severity: active.Severity, Message: active.Message, Network: network, Since: active.Since, Timestamp: ctx.now})
+	})
+
+	err := appstate.UpdateAlerts(alerts)
+	if err != nil {
+		utils.WriteError("Error saving alerts:" + err.Error())
+		return RESULT_ERROR
+	}
+	response.Active = activeAlerts()
+
+	// Serialize the struct to JSON
+	jsonBytes, err := json.Marshal(response)
+	if err != nil {
+		utils.WriteError("Error serializing to JSON:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	return string(jsonBytes)
+}
+
+type AlertsConfigResponse struct {
+	Webhooks []appstate.AlertWebhook `json:"webhooks"`
+	Rules    appstate.AlertRules     `json:"rules"`
+	Active   []AlertStatus           `json:"active"`
+}
+
+// alertsGetTask returns the alerts configuration and the firing alerts
+func alertsGetTask(args map[string]string) string {
+	response := AlertsConfigResponse{
+		Webhooks: appstate.CurrentState.Alerts.Webhooks,
+		Rules:    appstate.CurrentState.Alerts.Rules,
+		Active:   activeAlerts(),
+	}
+
+	// Serialize the struct to JSON
+	jsonBytes, err := json.Marshal(response)
+	if err != nil {
+		utils.WriteError("Error serializing to JSON:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	return string(jsonBytes)
+}
+
+// parseWebhooks parses a comma separated list of webhooks, each one being an URL optionally prefixed by its format
+// such as "slack|https://hooks.slack.com/...".
+func parseWebhooks(list string) ([]appstate.AlertWebhook, error) {
+	webhooks := []appstate.AlertWebhook{}
+	for _, entry := range splitList(list) {
+		format, url, found := strings.Cut(entry, "|")
+		if !found {
+			format, url = "json", entry
+		}
+		if _, err := utils.AlertPayload(format, utils.Alert{}); err != nil {
+			return nil, err
+		}
+		if !utils.IsValidURL(url) {
+			return nil, fmt.Errorf("invalid webhook URL %s", url)
+		}
+		webhooks = append(webhooks, appstate.AlertWebhook{URL: url, Format: format})
+	}
+	return webhooks, nil
+}
+
+// alertsSetTask updates the webhooks and the thresholds given as arguments, 0 or an empty value disables a rule
+func alertsSetTask(args map[string]string) string {
+	alerts := appstate.CurrentState.Alerts
+	rules := &alerts.Rules
+	for name, value := range args {
+		value = strings.TrimSpace(value)
+		var err error
+		switch name {
+		case "webhooks":
+			alerts.Webhooks, err = parseWebhooks(value)
+		case "heimdallLagBlocks":
+			rules.HeimdallLagBlocks, err = strconv.ParseInt(value, 10, 64)
+		case "executionLagBlocks":
+			rules.ExecutionLagBlocks, err = strconv.ParseInt(value, 10, 64)
+		case "lagMinutes":
+			rules.LagMinutes, err = strconv.Atoi(value)
+		case "restartThreshold":
+			rules.RestartThreshold, err = strconv.Atoi(value)
+		case "restartWindowMinutes":
+			rules.RestartWindowMinutes, err = strconv.Atoi(value)
+		case "repeatMinutes":
+			rules.RepeatMinutes, err = strconv.Atoi(value)
+		case "ethReserve":
+			if value != "" {
+				_, err = etherToWei(value)
+			}
+			rules.EthReserve = value
+		case "rewardsThreshold":
+			if value != "" {
+				_, err = etherToWei(value)
+			}
+			rules.RewardsThreshold = value
+		case "unbonding":
+			rules.Unbonding, err = strconv.ParseBool(value)
+		default:
+			err = fmt.Errorf("unknown setting, available settings: webhooks, heimdallLagBlocks, executionLagBlocks, lagMinutes, restartThreshold, restartWindowMinutes, repeatMinutes, ethReserve, rewardsThreshold, unbonding")
+		}
+		if err != nil {
+			utils.WriteError("Invalid " + name + ":" + err.Error())
+			return RESULT_ERROR
+		}
+	}
+	if rules.HeimdallLagBlocks < 0 || rules.ExecutionLagBlocks < 0 || rules.LagMinutes < 0 || rules.RestartThreshold < 0 || rules.RestartWindowMinutes < 0 || rules.RepeatMinutes < 0 {
+		utils.WriteError("Thresholds cannot be negative")
+		return RESULT_ERROR
+	}
+
+	err := appstate.UpdateAlerts(alerts)
+	if err != nil {
+		utils.WriteError("Error saving alerts:" + err.Error())
+		return RESULT_ERROR
+	}
+	fmt.Println("Successfully updated alerts")
+	return RESULT_SUCCESS
+}
@@ -0,0 +1,309 @@
+package tasks
+
+import (
+	"KeepixPlugin/appstate"
+	"KeepixPlugin/utils"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// backup components
+const (
+	backupHeimdall  = "heimdall"
+	backupExecution = "execution"
+)
+
+// backupComponents returns the components selected by a comma separated argument, all of them when empty
+func backupComponents(value string) (map[string]bool, error) {
+	components := make(map[string]bool)
+	if strings.TrimSpace(value) == "" {
+		components[backupHeimdall] = true
+		components[backupExecution] = true
+		return components, nil
+	}
+	for _, component := range strings.Split(value, ",") {
+		component = strings.TrimSpace(component)
+		if component != backupHeimdall && component != backupExecution {
+			return nil, fmt.Errorf("unknown component %s, expected %s or %s", component, backupHeimdall, backupExecution)
+		}
+		components[component] = true
+	}
+	return components, nil
+}
+
+// heimdallSignState is the last height and round heimdall signed, a validator restoring an older one could double sign
+const heimdallSignState = "priv_validator_state.json"
+
+// backupFolders returns the folders of the plugin data folder holding the chain data of the components. The
+// configs, the node keys and the sign state are left out, a restored node keeps its own.
+func backupFolders(components map[string]bool) []utils.BackupFolder {
+	client := utils.CurrentExecutionClient()
+	folders := []utils.BackupFolder{}
+	if components[backupHeimdall] {
+		folders = append(folders, utils.BackupFolder{Component: backupHeimdall, Folder: "heimdall/data", Excludes: []string{heimdallSignState}})
+	}
+	if components[backupExecution] {
+		folders = append(folders, utils.BackupFolder{Component: backupExecution, Folder: client.DataFolder(), Excludes: client.IdentityFiles()})
+	}
+	return folders
+}
+
+// dataRoots returns the host folders of the chain data, by the first path element of the backup folders
+func dataRoots() map[string]string {
+	client := utils.CurrentExecutionClient()
+	return map[string]string{"heimdall": utils.HeimdallDataPath(), client.DataFolder(): utils.ExecutionDataPath(client)}
+}
+
+// hostFolder returns the host path of a backup folder
+func hostFolder(roots map[string]string, folder string) string {
+	elements := strings.SplitN(folder, "/", 2)
+	return filepath.Join(roots[elements[0]], strings.Join(elements[1:], "/"))
+}
+
+// isInside returns whether a path is the given folder or one of its subfolders
+func isInside(child string, folder string) bool {
+	relative, err := filepath.Rel(folder, child)
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+type BackupResponse struct {
+	Target   string               `json:"target"`
+	Manifest utils.BackupManifest `json:"manifest"`
+}
+
+// backupTask archives the chain data of heimdall and of the execution client to the target directory with zstd,
+// next to a manifest of their sizes and checksums, so a synced node can be restored on another host. A running node
+// is stopped during the backup for the data to be consistent, then started again. The optional components argument
+// selects heimdall, execution or both, the default.
+func backupTask(args map[string]string) string {
+	target := filepath.Clean(strings.TrimSpace(args["target"]))
+	if !filepath.IsAbs(target) {
+		utils.WriteError("Invalid target, expected an absolute path")
+		return RESULT_ERROR
+	}
+	components, err := backupComponents(args["components"])
+	if err != nil {
+		utils.WriteError("Invalid components:" + err.Error())
+		return RESULT_ERROR
+	}
+	if components[backupHeimdall] && !appstate.CurrentState.HeimdallSnapshotDownloaded {
+		utils.WriteError("Heimdall is still bootstrapping from its snapshot, there is nothing to back up yet")
+		return RESULT_ERROR
+	}
+	if components[backupExecution] && appstate.CurrentState.ErigonSnapshotSource != "" && !appstate.CurrentState.ErigonSnapshotDownloaded {
+		utils.WriteError("Erigon is still bootstrapping from its snapshot, there is nothing to back up yet")
+		return RESULT_ERROR
+	}
+	storage, _ := appstate.GetStoragePath()
+	roots := dataRoots()
+	forbidden := []string{storage}
+	for _, root := range roots {
+		forbidden = append(forbidden, root)
+	}
+	for _, folder := range forbidden {
+		if isInside(target, folder) {
+			utils.WriteError("Invalid target, it cannot be inside the plugin or the chain data folders")
+			return RESULT_ERROR
+		}
+	}
+	err = os.MkdirAll(target, os.ModePerm)
+	if err != nil {
+		utils.WriteError("Error creating target:" + err.Error())
+		return RESULT_ERROR
+	}
+	if _, err := os.Stat(filepath.Join(target, utils.BackupManifestFile)); err == nil {
+		utils.WriteError("The target already holds a backup, use an empty directory")
+		return RESULT_ERROR
+	}
+
+	folders := backupFolders(components)
+	var dataSize uint64
+	for _, folder := range folders {
+		size, _ := utils.FolderSize(hostFolder(roots, folder.Folder))
+		dataSize += size
+	}
+	if usage, err := utils.GetDiskUsage(target); err == nil && usage.Free < dataSize {
+		// zstd usually halves chain data, the archives may still fit
+		fmt.Printf("Warning: %s has %d GB free for %d GB of data\n", target, usage.Free/1e9, dataSize/1e9)
+	}
+
+	client := utils.CurrentExecutionClient()
+	manifest := utils.BackupManifest{
+		CreatedAt:       time.Now().Unix(),
+		PluginVersion:   Version,
+		Network:         appstate.CurrentState.Network,
+		ExecutionClient: client.Name(),
+		ErigonProfile:   erigonProfile(appstate.CurrentState.Erigon),
+	}
+	if status, err := utils.GetHeimdallLocalStatus(); err == nil {
+		manifest.HeimdallHeight, _ = strconv.ParseInt(status.Result.SyncInfo.LatestBlockHeight, 10, 64)
+	}
+	if syncing, err := client.SyncingStatus(); err == nil {
+		manifest.ExecutionBlock = syncing.CurrentBlock
+	}
+
+	// a partially started node is stopped too
+	wasRunning := appstate.CurrentState.State > appstate.NodeInstalled
+	if wasRunning {
+		fmt.Println("Stopping the node for a consistent backup...")
+		if stopTask(map[string]string{}) == RESULT_ERROR {
+			return RESULT_ERROR
+		}
+	}
+	fmt.Println("Archiving chain data to " + target + "...")
+	archives, err := utils.RunBackup(roots, target, folders)
+	if err == nil {
+		manifest.Archives = archives
+		err = utils.WriteBackupManifest(target, &manifest)
+	}
+	if wasRunning {
+		// restarted whatever the outcome of the backup
+		fmt.Println("Restarting the node...")
+		if startTask(map[string]string{}) == RESULT_ERROR {
+			utils.WriteError("Error restarting the node after the backup")
+		}
+	}
+	if err != nil {
+		utils.WriteError("Error during backup:" + err.Error())
+		return RESULT_ERROR
+	}
+	appstate.AppendHistory(appstate.HistoryEntry{Time: time.Now().Unix(), Source: "backup", Action: "created", Message: target})
+	fmt.Println("Successfully backed up chain data")
+
+	response := BackupResponse{Target: target, Manifest: manifest}
+
+	// Serialize the struct to JSON
+	jsonBytes, err := json.Marshal(response)
+	if err != nil {
+		utils.WriteError("Error serializing to JSON:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	return string(jsonBytes)
+}
+
+// restoreTask lays the chain data of a backup down, once its manifest is validated, so the next start resumes from
+// it. The backup has to match the network, the execution client and the erigon profile of the node. The node has to
+// be stopped, the current chain data of the restored components is replaced. The optional components argument
+// selects heimdall, execution or both, the default.
+func restoreTask(args map[string]string) string {
+	if appstate.CurrentState.State > appstate.NodeInstalled {
+		utils.WriteError("Stop the node before restoring a backup")
+		return RESULT_ERROR
+	}
+	source := filepath.Clean(strings.TrimSpace(args["source"]))
+	if !filepath.IsAbs(source) {
+		utils.WriteError("Invalid source, expected an absolute path")
+		return RESULT_ERROR
+	}
+	components, err := backupComponents(args["components"])
+	if err != nil {
+		utils.WriteError("Invalid components:" + err.Error())
+		return RESULT_ERROR
+	}
+	manifest, err := utils.ReadBackupManifest(source)
+	if err != nil {
+		utils.WriteError("Invalid backup:" + err.Error())
+		return RESULT_ERROR
+	}
+	if manifest.Network != appstate.CurrentState.Network {
+		utils.WriteError("The backup is of " + manifest.Network + ", the node runs " + appstate.CurrentState.Network)
+		return RESULT_ERROR
+	}
+
+	client := utils.CurrentExecutionClient()
+	archives := []utils.BackupArchive{}
+	keep, chown := map[string][]string{}, []string{}
+	for _, archive := range manifest.Archives {
+		if !components[archive.Component] {
+			continue
+		}
+		switch archive.Component {
+		case backupHeimdall:
+			if archive.Folder != "heimdall/data" {
+				utils.WriteError("Invalid backup: unexpected heimdall folder " + archive.Folder)
+				return RESULT_ERROR
+			}
+			// backups made before the sign state was left out still hold one
+			keep[archive.File] = []string{heimdallSignState}
+		case backupExecution:
+			if manifest.ExecutionClient != client.Name() || archive.Folder != client.DataFolder() {
+				utils.WriteError("The backup is of " + manifest.ExecutionClient + ", the node runs " + client.Name())
+				return RESULT_ERROR
+			}
+			if client.Name() == "erigon" && manifest.ErigonProfile != erigonProfile(appstate.CurrentState.Erigon) {
+				utils.WriteError("The backup is of an erigon " + manifest.ErigonProfile + " node, the node runs the " + erigonProfile(appstate.CurrentState.Erigon) + " profile")
+				return RESULT_ERROR
+			}
+			keep[archive.File] = client.IdentityFiles()
+			// the execution client runs with the host user
+			chown = append(chown, client.DataFolder())
+		default:
+			utils.WriteError("Invalid backup: unknown component " + archive.Component)
+			return RESULT_ERROR
+		}
+		archives = append(archives, archive)
+		delete(components, archive.Component)
+	}
+	if len(components) > 0 && args["components"] != "" {
+		for component := range components {
+			utils.WriteError("The backup holds no " + component + " data")
+		}
+		return RESULT_ERROR
+	}
+	if len(archives) == 0 {
+		utils.WriteError("The backup holds no data to restore")
+		return RESULT_ERROR
+	}
+
+	roots := dataRoots()
+	for _, archive := range archives {
+		usage, err := utils.GetDiskUsage(hostFolder(roots, archive.Folder))
+		if err != nil {
+			continue
+		}
+		// the current data is replaced, apart from the small kept files
+		size, _ := utils.FolderSize(hostFolder(roots, archive.Folder))
+		free := usage.Free + size
+		if free < archive.DataSize {
+			utils.WriteError(fmt.Sprintf("Not enough disk space for %s: it needs %d GB, %d GB are available", archive.Component, archive.DataSize/1e9, free/1e9))
+			return RESULT_ERROR
+		}
+	}
+
+	// the restored data replaces any snapshot being downloaded
+	for _, archive := range archives {
+		if archive.Component == backupHeimdall {
+			_ = utils.RemoveContainerIfExists("heimdall-snapshot-downloader")
+		} else {
+			_ = utils.RemoveContainerIfExists("erigon-snapshot-downloader")
+		}
+	}
+	fmt.Println("Verifying and restoring the backup from " + source + "...")
+	err = utils.RunRestore(roots, source, archives, keep, chown)
+	if err != nil {
+		utils.WriteError("Error during restore:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	for _, archive := range archives {
+		if archive.Component == backupHeimdall {
+			appstate.UpdateSnapshotDownloaded(true)
+		} else {
+			if appstate.CurrentState.ErigonSnapshotSource != "" {
+				appstate.UpdateErigonSnapshotDownloaded(true)
+			}
+			appstate.UpdateExecutionSyncSamples(nil)
+		}
+	}
+	// the next start boots every component again
+	appstate.UpdateState(appstate.NodeInstalled)
+	appstate.AppendHistory(appstate.HistoryEntry{Time: time.Now().Unix(), Source: "restore", Action: "restored", Message: source})
+	fmt.Println("Successfully restored the backup, start the node to resume from it")
+	return RESULT_SUCCESS
+}
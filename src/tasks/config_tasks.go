@@ -0,0 +1,518 @@
+package tasks
+
+import (
+	"KeepixPlugin/appstate"
+	"KeepixPlugin/utils"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// heimdallSetting describes a heimdall setting that can be edited with heimdall-config-set
+type heimdallSetting struct {
+	File     string // config file holding the setting, empty when stored in the plugin state
+	Key      string // "section.key" of the setting in the file
+	IsString bool   // whether the value is written as a TOML string
+	Validate func(value string) error
+}
+
+// heimdallSettings maps the editable setting names to their definition
+var heimdallSettings = map[string]heimdallSetting{
+	"moniker":                {File: "config.toml", Key: "moniker", IsString: true, Validate: validateMoniker},
+	"seeds":                  {File: "config.toml", Key: "p2p.seeds", IsString: true, Validate: utils.ValidateTendermintPeers},
+	"persistent_peers":       {File: "config.toml", Key: "p2p.persistent_peers", IsString: true, Validate: utils.ValidateTendermintPeers},
+	"max_num_inbound_peers":  {File: "config.toml", Key: "p2p.max_num_inbound_peers", Validate: validatePeerCount},
+	"max_num_outbound_peers": {File: "config.toml", Key: "p2p.max_num_outbound_peers", Validate: validatePeerCount},
+	"eth_rpc_url":            {File: "heimdall-config.toml", Key: "eth_rpc_url", IsString: true, Validate: validateEthereumRPC},
+	"pruning":                {Validate: validatePruning}, // heimdall start flag, kept in the plugin state
+}
+
+func validateMoniker(value string) error {
+	if value == "" || len(value) > 70 {
+		return fmt.Errorf("moniker must be between 1 and 70 characters")
+	}
+	return nil
+}
+
+func validatePeerCount(value string) error {
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 0 || count > 1000 {
+		return fmt.Errorf("peer count must be a number between 0 and 1000")
+	}
+	return nil
+}
+
+// validateEthereumRPC checks the RPC serves the L1 chain of the current network
+func validateEthereumRPC(value string) error {
+	if !utils.IsValidURL(value) {
+		return fmt.Errorf("invalid URL")
+	}
+	client, err := utils.NewBlockchainClient(value)
+	if err != nil {
+		return fmt.Errorf("error connecting to the RPC: %v", err)
+	}
+	chainID, err := client.ChainID()
+	if err != nil {
+		return fmt.Errorf("error connecting to the RPC: %v", err)
+	}
+	if chainID.Int64() != appstate.CurrentNetwork().L1ChainID {
+		return fmt.Errorf("the RPC serves chain ID %s, %s expects %d", chainID.String(), appstate.CurrentNetwork().Name, appstate.CurrentNetwork().L1ChainID)
+	}
+	return nil
+}
+
+func validatePruning(value string) error {
+	switch value {
+	case "", "syncable", "nothing", "everything":
+		return nil
+	}
+	return fmt.Errorf("pruning must be one of syncable, nothing, everything")
+}
+
+// heimdallConfigPath returns the path of a heimdall config file
+func heimdallConfigPath(file string) string {
+	return path.Join(utils.HeimdallDataPath(), "config", file)
+}
+
+// readHeimdallSettings reads the current value of all the editable heimdall settings
+func readHeimdallSettings() (map[string]string, error) {
+	files := make(map[string]map[string]string)
+	settings := make(map[string]string)
+	for name, setting := range heimdallSettings {
+		if setting.File == "" {
+			continue
+		}
+		values, loaded := files[setting.File]
+		if !loaded {
+			var err error
+			values, err = utils.ReadTomlFile(heimdallConfigPath(setting.File))
+			if err != nil {
+				return nil, err
+			}
+			files[setting.File] = values
+		}
+		settings[name] = values[setting.Key]
+	}
+	settings["pruning"] = appstate.CurrentState.HeimdallPruning
+	return settings, nil
+}
+
+// writeHeimdallSettings validates and writes heimdall settings to their config files
+func writeHeimdallSettings(settings map[string]string) error {
+	updates := make(map[string]map[string]string)
+	for name, value := range settings {
+		setting, exists := heimdallSettings[name]
+		if !exists {
+			return fmt.Errorf("unknown heimdall setting %s", name)
+		}
+		if err := setting.Validate(value); err != nil {
+			return fmt.Errorf("invalid value for %s: %v", name, err)
+		}
+		if setting.File == "" {
+			continue
+		}
+		if setting.IsString {
+			value = utils.TomlString(value)
+		}
+		if updates[setting.File] == nil {
+			updates[setting.File] = make(map[string]string)
+		}
+		updates[setting.File][setting.Key] = value
+	}
+
+	for file, values := range updates {
+		if err := utils.WriteTomlValues(heimdallConfigPath(file), values); err != nil {
+			return err
+		}
+	}
+	if pruning, exists := settings["pruning"]; exists {
+		if err := appstate.UpdateHeimdallPruning(pruning); err != nil {
+			return err
+		}
+	}
+	if rpc, exists := settings["eth_rpc_url"]; exists {
+		// heimdall and the wallet share the same ethereum RPC
+		if err := appstate.UpdateRPC(rpc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// heimdallConfigGetTask returns the editable heimdall settings
+func heimdallConfigGetTask(args map[string]string) string {
+	settings, err := readHeimdallSettings()
+	if err != nil {
+		utils.WriteError("Error reading heimdall config:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	// Serialize the struct to JSON
+	jsonBytes, err := json.Marshal(settings)
+	if err != nil {
+		utils.WriteError("Error serializing to JSON:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	return string(jsonBytes)
+}
+
+// heimdallConfigSetTask updates the heimdall settings given as arguments and optionally restarts heimdall
+func heimdallConfigSetTask(args map[string]string) string {
+	restart := args["restart"] == "true"
+
+	settings := make(map[string]string)
+	for name, value := range args {
+		if name != "restart" {
+			settings[name] = strings.TrimSpace(value)
+		}
+	}
+	if len(settings) == 0 {
+		names := make([]string, 0, len(heimdallSettings))
+		for name := range heimdallSettings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		utils.WriteError("No setting provided, available settings: " + strings.Join(names, ", "))
+		return RESULT_ERROR
+	}
+
+	err := writeHeimdallSettings(settings)
+	if err != nil {
+		utils.WriteError("Error updating heimdall config:" + err.Error())
+		return RESULT_ERROR
+	}
+	fmt.Println("Successfully updated heimdall config")
+
+	if restart {
+		if !restartHeimdall() {
+			return RESULT_ERROR
+		}
+	} else {
+		fmt.Println("Restart Heimdall to apply the new config")
+	}
+	return RESULT_SUCCESS
+}
+
+// heimdallReferenceSetTask sets the endpoints used to learn the heimdall chain tip, tried in order.
+// Endpoints are tendermint RPC or heimdall REST servers, an empty list restores the public endpoints.
+func heimdallReferenceSetTask(args map[string]string) string {
+	var endpoints []string
+	for _, endpoint := range strings.Split(args["endpoints"], ",") {
+		endpoint = strings.TrimSpace(endpoint)
+		if endpoint == "" {
+			continue
+		}
+		if !utils.IsValidURL(endpoint) {
+			utils.WriteError("Invalid endpoint " + endpoint)
+			return RESULT_ERROR
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	err := appstate.UpdateHeimdallReferenceEndpoints(endpoints)
+	if err != nil {
+		utils.WriteError("Error updating reference endpoints:" + err.Error())
+		return RESULT_ERROR
+	}
+	if len(endpoints) == 0 {
+		fmt.Println("Using the public heimdall endpoints as reference")
+	} else {
+		fmt.Println("Using " + strings.Join(endpoints, ", ") + " as reference")
+	}
+	return RESULT_SUCCESS
+}
+
+// borRPCURL returns the RPC of the execution client heimdall uses, the local container unless reconfigured
+func borRPCURL(client utils.ExecutionClient) string {
+	if appstate.CurrentState.BorRPCURL != "" {
+		return appstate.CurrentState.BorRPCURL
+	}
+	return "http://" + client.ContainerName() + ":8545"
+}
+
+// reconfigureTask updates the ethereum RPC, the bor RPC heimdall uses, the external IP and the API exposure without
+// reinstalling, in the state and in the configs, then restarts only the affected components. All arguments are
+// optional: ethereumRPC, borRPC ("default" for the local execution client), externalIP ("auto" to detect it),
+// apiExposure (public or local), natMode (extip, upnp or pmp), ipEchoServices (comma separated URLs or "default")
+// and restart, true by default.
+func reconfigureTask(args map[string]string) string {
+	exposure := appstate.CurrentState.Exposure
+	previousNATMode := exposure.NATMode
+	borRPC := appstate.CurrentState.BorRPCURL
+	heimdallConfig := make(map[string]string)
+	tendermintConfig := make(map[string]string)
+	restartHeimdallNode, recreateHeimdall, recreateExecution, updateExposure := false, false, false, false
+
+	ethereumRPC, updateRPC := args["ethereumRPC"]
+	if updateRPC {
+		ethereumRPC = strings.TrimSpace(ethereumRPC)
+		if err := validateEthereumRPC(ethereumRPC); err != nil {
+			utils.WriteError("Invalid ethereumRPC:" + err.Error())
+			return RESULT_ERROR
+		}
+		heimdallConfig["eth_rpc_url"] = utils.TomlString(ethereumRPC)
+		restartHeimdallNode = true
+	}
+	if value, exists := args["borRPC"]; exists {
+		borRPC = strings.TrimSpace(value)
+		if borRPC == "default" {
+			borRPC = ""
+		}
+		if borRPC != "" && !utils.IsValidURL(borRPC) {
+			utils.WriteError("Invalid borRPC")
+			return RESULT_ERROR
+		}
+		restartHeimdallNode = true
+	}
+	if value, exists := args["externalIP"]; exists {
+		value = strings.TrimSpace(value)
+		if value == "auto" {
+			value = ""
+		}
+		if value != "" && net.ParseIP(value) == nil {
+			utils.WriteError("Invalid externalIP")
+			return RESULT_ERROR
+		}
+		exposure.ExternalIP = value
+		tendermintConfig["p2p.external_address"] = utils.TomlString("")
+		if value != "" {
+			tendermintConfig["p2p.external_address"] = utils.TomlString(net.JoinHostPort(value, "26656"))
+		}
+		restartHeimdallNode = true
+		recreateExecution = true
+	}
+	if value, exists := args["apiExposure"]; exists {
+		value = strings.TrimSpace(value)
+		if value != "public" && value != "local" {
+			utils.WriteError("Invalid apiExposure, expected public or local")
+			return RESULT_ERROR
+		}
+		if value != exposure.APIExposure && !(value == "public" && exposure.APIExposure == "") {
+			recreateHeimdall = true
+			recreateExecution = true
+		}
+		exposure.APIExposure = value
+	}
+	if value, exists := args["natMode"]; exists {
+		value = strings.TrimSpace(value)
+		if value != utils.NATModeExtIP && !usesGatewayNAT(value) {
+			utils.WriteError("Invalid natMode, expected one of " + strings.Join(utils.NATModes, ", "))
+			return RESULT_ERROR
+		}
+		if value != exposure.NATMode && !(value == utils.NATModeExtIP && exposure.NATMode == "") {
+			// the IP advertised by the execution client may change with the gateway
+			recreateExecution = true
+		}
+		exposure.NATMode = value
+		updateExposure = true
+	}
+	if value, exists := args["ipEchoServices"]; exists {
+		services := []string{}
+		if strings.TrimSpace(value) != "default" {
+			for _, service := range strings.Split(value, ",") {
+				service = strings.TrimSpace(service)
+				if !utils.IsValidURL(service) {
+					utils.WriteError("Invalid IP echo service " + service)
+					return RESULT_ERROR
+				}
+				services = append(services, service)
+			}
+		}
+		exposure.IPEchoServices = services
+		updateExposure = true
+	}
+	if !restartHeimdallNode && !recreateHeimdall && !recreateExecution && !updateExposure {
+		utils.WriteError("No setting provided, available settings: ethereumRPC, borRPC, externalIP, apiExposure, natMode, ipEchoServices")
+		return RESULT_ERROR
+	}
+
+	client := utils.CurrentExecutionClient()
+	appstate.UpdateBorRPCURL(borRPC)
+	heimdallConfig["bor_rpc_url"] = utils.TomlString(borRPCURL(client))
+	err := utils.WriteTomlValues(heimdallConfigPath("heimdall-config.toml"), heimdallConfig)
+	if err != nil {
+		utils.WriteError("Error updating heimdall config:" + err.Error())
+		return RESULT_ERROR
+	}
+	if len(tendermintConfig) > 0 {
+		err = utils.WriteTomlValues(heimdallConfigPath("config.toml"), tendermintConfig)
+		if err != nil {
+			utils.WriteError("Error updating heimdall config:" + err.Error())
+			return RESULT_ERROR
+		}
+	}
+	if updateRPC {
+		// heimdall and the wallet share the same ethereum RPC
+		appstate.UpdateRPC(ethereumRPC)
+	}
+	if exposure.NATMode != previousNATMode {
+		removePortMappings(previousNATMode)
+		exposure.PortsMappedUntil = appstate.CurrentState.Exposure.PortsMappedUntil
+	}
+	err = appstate.UpdateExposure(exposure)
+	if err != nil {
+		utils.WriteError("Error saving state:" + err.Error())
+		return RESULT_ERROR
+	}
+	fmt.Println("Successfully updated the node configuration")
+	if appstate.CurrentState.State == appstate.NodeStarted {
+		refreshPortMappings(false)
+	}
+
+	if args["restart"] == "false" {
+		fmt.Println("Restart the node to apply the new configuration")
+		return RESULT_SUCCESS
+	}
+	if recreateHeimdall {
+		// published ports can only change by recreating the containers
+		if !restartHeimdall() {
+			return RESULT_ERROR
+		}
+	} else if restartHeimdallNode && appstate.CurrentState.State == appstate.NodeStarted && appstate.CurrentState.HeimdallSnapshotDownloaded {
+		fmt.Println("Restarting Heimdall...")
+		err = utils.RestartContainer("heimdall")
+		if err != nil {
+			utils.WriteError("Error restarting heimdall:" + err.Error())
+			return RESULT_ERROR
+		}
+	}
+	if recreateExecution {
+		if !restartExecutionClient() {
+			return RESULT_ERROR
+		}
+	}
+	return RESULT_SUCCESS
+}
+
+type ErigonConfigResponse struct {
+	Config   appstate.ErigonConfig `json:"config"`
+	Args     []string              `json:"args"`
+	Profiles []string              `json:"profiles"`
+}
+
+// erigonProfile returns the profile of an erigon config, resolving the default one
+func erigonProfile(config appstate.ErigonConfig) string {
+	if config.Profile == "" {
+		return utils.DefaultErigonProfile
+	}
+	return config.Profile
+}
+
+// erigonConfigGetTask returns the erigon config and the flags it translates to
+func erigonConfigGetTask(args map[string]string) string {
+	config := appstate.CurrentState.Erigon
+	config.Profile = erigonProfile(config)
+
+	profiles := make([]string, 0, len(utils.ErigonProfiles))
+	for profile := range utils.ErigonProfiles {
+		profiles = append(profiles, profile)
+	}
+	sort.Strings(profiles)
+
+	response := ErigonConfigResponse{
+		Config:   config,
+		Args:     utils.ErigonConfigArgs(config),
+		Profiles: profiles,
+	}
+
+	// Serialize the struct to JSON
+	jsonBytes, err := json.Marshal(response)
+	if err != nil {
+		utils.WriteError("Error serializing to JSON:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	return string(jsonBytes)
+}
+
+// erigonConfigSetTask updates the erigon settings given as arguments and optionally restarts erigon.
+// Changing the profile of existing chaindata is refused by erigon, so it requires a resync.
+func erigonConfigSetTask(args map[string]string) string {
+	if utils.CurrentExecutionClient().Name() != "erigon" {
+		utils.WriteError("The node does not run erigon")
+		return RESULT_ERROR
+	}
+	restart := args["restart"] == "true"
+	resync := args["resync"] == "true"
+
+	current := appstate.CurrentState.Erigon
+	config := current
+	for name, value := range args {
+		value = strings.TrimSpace(value)
+		switch name {
+		case "restart", "resync":
+		case "profile":
+			config.Profile = value
+		case "httpApi":
+			config.HTTPAPI = value
+		case "dbSizeLimit":
+			config.DBSizeLimit = value
+		case "torrentDownloadRate":
+			config.TorrentDownloadRate = value
+		case "ws", "metrics":
+			if value != "true" && value != "false" {
+				utils.WriteError("Invalid value for " + name + ": expected true or false")
+				return RESULT_ERROR
+			}
+			if name == "ws" {
+				config.WS = value == "true"
+			} else {
+				config.Metrics = value == "true"
+			}
+		case "overrides":
+			overrides, err := utils.ParseErigonOverrides(value)
+			if err != nil {
+				utils.WriteError("Invalid overrides:" + err.Error())
+				return RESULT_ERROR
+			}
+			config.Overrides = overrides
+		default:
+			utils.WriteError("Unknown erigon setting " + name + ", available settings: dbSizeLimit, httpApi, metrics, overrides, profile, torrentDownloadRate, ws")
+			return RESULT_ERROR
+		}
+	}
+
+	err := utils.ValidateErigonConfig(config)
+	if err != nil {
+		utils.WriteError("Invalid erigon config:" + err.Error())
+		return RESULT_ERROR
+	}
+	profileChanged := erigonProfile(config) != erigonProfile(current)
+	if profileChanged && !resync {
+		utils.WriteError("Changing the profile requires resyncing erigon, set resync to true to drop the chaindata")
+		return RESULT_ERROR
+	}
+
+	err = appstate.UpdateErigonConfig(config)
+	if err != nil {
+		utils.WriteError("Error updating erigon config:" + err.Error())
+		return RESULT_ERROR
+	}
+	fmt.Println("Successfully updated erigon config")
+
+	if profileChanged {
+		if appstate.CurrentState.State == appstate.NodeStarted {
+			return resyncTask(map[string]string{"erigon": "true"})
+		}
+		if !removeData(true, false, false) {
+			return RESULT_ERROR
+		}
+		appstate.UpdateErigonSnapshotDownloaded(false)
+		fmt.Println("Erigon chaindata removed, it will resync on next start")
+		return RESULT_SUCCESS
+	}
+
+	if restart {
+		if !restartExecutionClient() {
+			return RESULT_ERROR
+		}
+	} else {
+		fmt.Println("Restart Erigon to apply the new config")
+	}
+	return RESULT_SUCCESS
+}
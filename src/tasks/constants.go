@@ -2,3 +2,6 @@ package tasks
 
 const RESULT_ERROR = "false"
 const RESULT_SUCCESS = "true"
+
+// Version is the version of the plugin, set by main from its build flags
+var Version string
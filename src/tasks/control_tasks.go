@@ -4,15 +4,14 @@ import (
 	"KeepixPlugin/appstate"
 	"KeepixPlugin/utils"
 	"fmt"
-	"path"
 )
 
 func startTask(args map[string]string) string {
-	storage, _ := appstate.GetStoragePath()
-	localPathHeimdall := path.Join(storage, "data", "heimdall")
-	localPathErigon := path.Join(storage, "data", "erigon")
+	localPathHeimdall := utils.HeimdallDataPath()
+	localPathErigon := utils.ExecutionDataPath(utils.ErigonClient{})
 
 	fmt.Println("Starting node...")
+	refreshPortMappings(true)
 
 	// check if heimdall was already snapshoted
 	if !appstate.CurrentState.HeimdallSnapshotDownloaded {
@@ -23,74 +22,58 @@ func startTask(args map[string]string) string {
 			appstate.UpdateState(appstate.StartingHeimdall)
 		} else {
 			fmt.Println("Heimdall needs to be snapshoted before starting")
-			fmt.Println("Downloading heimdall snapshot...")
-			network := "mainnet"
-			if appstate.CurrentState.IsTestnet {
-				network = "mumbai"
+			_ = utils.RemoveContainerIfExists("heimdall-snapshot-downloader") // clear any previous failed attempt
+			var err error
+			if source := appstate.CurrentState.Offline.HeimdallSnapshot; source != "" {
+				fmt.Println("Importing heimdall snapshot from " + source + "...")
+				err = utils.RunHeimdallSnapshotImport(localPathHeimdall, source)
+			} else {
+				fmt.Println("Downloading heimdall snapshot...")
+				err = utils.RunSnapshotDownloader(localPathHeimdall, appstate.CurrentNetwork().SnapshotNetwork)
 			}
-			err := utils.RunSnapshotDownloader(localPathHeimdall, network)
 			if err != nil {
 				utils.WriteError("Error downloading heimdall snapshot:" + err.Error())
 				return RESULT_ERROR
 			} else {
 				fmt.Println("Successfully started downloading heimdall snapshot")
-				fmt.Println("You will need to manually restart heimdall after snapshot was downloaded")
-				// download started, we will boot heimdall nodes later
+				fmt.Println("Heimdall will be started automatically once the snapshot is downloaded")
+				// download started, heimdall nodes are booted later by bootHeimdallAfterSnapshot
 				appstate.UpdateState(appstate.StartingErigon)
 			}
 		}
 
 	}
 
-	if appstate.CurrentState.State <= appstate.StartingHeimdall {
-		fmt.Println("Starting Heimdall...")
-		appstate.UpdateState(appstate.StartingHeimdall)
-		_ = utils.StopContainerByName("heimdall") // try and stop heimdall if it's already running
-		_, err := utils.DockerRun("0xpolygon/heimdall:1.0.3", []string{"start", "--home=/heimdall-home"}, "/heimdall-home", localPathHeimdall, []uint{26657, 26656}, true, "polygon", true, "heimdall", false)
-		if err != nil {
-			utils.WriteError("Error during heimdall start:" + err.Error())
+	if appstate.CurrentState.State <= appstate.StartingRestServer {
+		if !startHeimdall(localPathHeimdall) {
 			return RESULT_ERROR
-		} else {
-			fmt.Println("Successfully started Heimdall")
-			appstate.UpdateState(appstate.StartingRestServer)
 		}
 	}
 
-	if appstate.CurrentState.State <= appstate.StartingRestServer {
-		fmt.Println("Starting heimdall rest server...")
-		_ = utils.StopContainerByName("heimdall-rest") // try and stop heimdall-rest if it's already running
-		_, err := utils.DockerRun("0xpolygon/heimdall:1.0.3", []string{"rest-server", "--home=/heimdall-home", "--node=tcp://heimdall:26657"}, "/heimdall-home", localPathHeimdall, []uint{1317}, true, "polygon", true, "heimdall-rest", false)
-		if err != nil {
-			utils.WriteError("Error during heimdall rest server start:" + err.Error())
-			return RESULT_ERROR
+	// check if erigon has to be bootstrapped from a snapshot first
+	erigonSnapshotPending := false
+	if appstate.CurrentState.ErigonSnapshotSource != "" && !appstate.CurrentState.ErigonSnapshotDownloaded {
+		validated, _ := utils.ValidateErigonSnapshot()
+		if validated {
+			appstate.UpdateErigonSnapshotDownloaded(true)
 		} else {
-			fmt.Println("Successfully started rest server")
-			appstate.UpdateState(appstate.StartingErigon)
+			fmt.Println("Downloading erigon snapshot...")
+			_ = utils.RemoveContainerIfExists("erigon-snapshot-downloader") // clear any previous failed attempt
+			err := utils.RunErigonSnapshotDownloader(localPathErigon, appstate.CurrentState.ErigonSnapshotSource)
+			if err != nil {
+				utils.WriteError("Error downloading erigon snapshot:" + err.Error())
+				return RESULT_ERROR
+			}
+			fmt.Println("Successfully started downloading erigon snapshot")
+			fmt.Println("Erigon will be started automatically once the snapshot is extracted")
+			erigonSnapshotPending = true
 		}
 	}
 
-	if appstate.CurrentState.State <= appstate.StartingErigon {
-		fmt.Println("Starting Erigon...")
-		chainArg := "--chain=bor-mainnet"
-		if appstate.CurrentState.IsTestnet {
-			chainArg = "--chain=mumbai"
-			fmt.Println("Erigon will start on mumbai testnet")
-		} else {
-			fmt.Println("Erigon will start on mainnet")
-		}
-		_ = utils.StopContainerByName("erigon") // try and stop erigon if it's already running
-		extip, err := utils.GetExternalIP()
-		if err != nil {
-			utils.WriteError("Error getting external IP:" + err.Error())
+	if appstate.CurrentState.State <= appstate.StartingErigon && !erigonSnapshotPending {
+		if !startExecutionClient() {
 			return RESULT_ERROR
 		}
-		_, err = utils.DockerRun("thorax/erigon:v2.53.4", []string{"--datadir=/erigon-home", "--bor.heimdall=http://heimdall-rest:1317", "--private.api.addr=0.0.0.0:9090", "--http.addr=0.0.0.0", fmt.Sprintf("--nat=extip:%s", extip), "--db.size.limit=7697000000000", chainArg}, "/erigon-home", localPathErigon, []uint{30303, 30304, 8545, 9090}, true, "polygon", true, "erigon", false)
-		if err != nil {
-			utils.WriteError("Error during erigon start:" + err.Error())
-			return RESULT_ERROR
-		} else {
-			fmt.Println("Successfully started Erigon")
-		}
 	}
 	fmt.Println("Successfully started node")
 	appstate.UpdateState(appstate.NodeStarted)
@@ -102,8 +85,11 @@ func stopTask(args map[string]string) string {
 	fmt.Println("Stopping node...")
 	err1 := utils.StopContainerByName("heimdall")
 	err2 := utils.StopContainerByName("heimdall-rest")
-	err3 := utils.StopContainerByName("erigon")
+	err3 := utils.StopContainerByName(utils.CurrentExecutionClient().ContainerName())
 	err4 := utils.StopContainerByName("heimdall-snapshot-downloader")
+	err5 := utils.StopContainerByName("erigon-snapshot-downloader")
+	err6 := utils.StopContainerByName("rabbitmq")
+	removePortMappings(appstate.CurrentState.Exposure.NATMode)
 
 	if err1 != nil {
 		utils.WriteError("Error stopping heimdall:" + err1.Error())
@@ -112,13 +98,20 @@ func stopTask(args map[string]string) string {
 		utils.WriteError("Error stopping heimdall-rest:" + err2.Error())
 	}
 	if err3 != nil {
-		utils.WriteError("Error stopping erigon:" + err3.Error())
+		utils.WriteError("Error stopping " + utils.CurrentExecutionClient().Name() + ":" + err3.Error())
 	}
 	if err4 != nil {
-		utils.WriteError("Error stopping erigon snapshot downloader:" + err4.Error())
+		utils.WriteError("Error stopping heimdall snapshot downloader:" + err4.Error())
+	}
+	if err5 != nil {
+		utils.WriteError("Error stopping erigon snapshot downloader:" + err5.Error())
+	}
+
+	if err6 != nil {
+		utils.WriteError("Error stopping rabbitmq:" + err6.Error())
 	}
 
-	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || err5 != nil || err6 != nil {
 		return RESULT_ERROR
 	}
 	fmt.Println("Successfully stoped node")
@@ -127,14 +120,14 @@ func stopTask(args map[string]string) string {
 }
 
 func resyncTask(args map[string]string) string {
-	resyncErigon := args["erigon"]
+	resyncErigon := args["erigon"] // resyncs the execution client, whichever it is
 	resyncHeimdall := args["heimdall"]
 	if resyncErigon != "true" && resyncHeimdall != "true" {
 		fmt.Println("Nothing to resync, aborting resyncing")
 		return RESULT_SUCCESS
 	}
 	if resyncErigon == "true" {
-		fmt.Println("Resyncing Erigon...")
+		fmt.Println("Resyncing " + utils.CurrentExecutionClient().Name() + "...")
 	}
 	if resyncHeimdall == "true" {
 		fmt.Println("Resyncing Heimdall...")
@@ -150,7 +143,12 @@ func resyncTask(args map[string]string) string {
 		utils.WriteError("Error removing data")
 		return RESULT_ERROR
 	}
-	appstate.UpdateSnapshotDownloaded(false)
+	if resyncHeimdall == "true" {
+		appstate.UpdateSnapshotDownloaded(false)
+	}
+	if resyncErigon == "true" {
+		appstate.UpdateErigonSnapshotDownloaded(false)
+	}
 	res = startTask(map[string]string{})
 	if res != RESULT_SUCCESS {
 		utils.WriteError("Error starting node")
@@ -175,3 +173,224 @@ func restartTask(args map[string]string) string {
 	fmt.Println("Successfully restarted node")
 	return RESULT_SUCCESS
 }
+
+// startHeimdall starts the heimdall node and its rest server, resuming from the current state
+func startHeimdall(localPathHeimdall string) bool {
+	if appstate.CurrentState.State <= appstate.StartingHeimdall {
+		fmt.Println("Starting Heimdall...")
+		appstate.UpdateState(appstate.StartingHeimdall)
+		_ = utils.StopContainerByName("heimdall") // try and stop heimdall if it's already running
+		startArgs := []string{"start", "--home=/heimdall-home"}
+		if appstate.CurrentState.HeimdallPruning != "" {
+			startArgs = append(startArgs, "--pruning="+appstate.CurrentState.HeimdallPruning)
+		}
+		if appstate.CurrentState.Validator.Enabled {
+			// validators run the bridge, which relies on rabbitmq, its queues do not need to be persisted so it gets no
+			// volume, it has no business with the heimdall config and its signer key
+			_ = utils.StopContainerByName("rabbitmq")
+			_ = utils.RemoveContainerIfExists("rabbitmq") // a stopped one is left by StopContainerByName
+			_, err := utils.DockerRun(RABBITMQ_IMAGE, []string{}, "", "", []uint{}, true, "polygon", true, "rabbitmq", false)
+			if err != nil {
+				utils.WriteError("Error during rabbitmq start:" + err.Error())
+				return false
+			}
+			startArgs = append(startArgs, "--bridge", "--all")
+		}
+		_, err := utils.DockerRun("0xpolygon/heimdall:1.0.3", startArgs, "/heimdall-home", localPathHeimdall, utils.HeimdallPorts, true, "polygon", true, "heimdall", false)
+		if err != nil {
+			utils.WriteError("Error during heimdall start:" + err.Error())
+			return false
+		} else {
+			fmt.Println("Successfully started Heimdall")
+			appstate.UpdateState(appstate.StartingRestServer)
+		}
+	}
+
+	if appstate.CurrentState.State <= appstate.StartingRestServer {
+		fmt.Println("Starting heimdall rest server...")
+		_ = utils.StopContainerByName("heimdall-rest") // try and stop heimdall-rest if it's already running
+		_, err := utils.DockerRun("0xpolygon/heimdall:1.0.3", []string{"rest-server", "--home=/heimdall-home", "--node=tcp://heimdall:26657"}, "/heimdall-home", localPathHeimdall, []uint{1317}, true, "polygon", true, "heimdall-rest", false)
+		if err != nil {
+			utils.WriteError("Error during heimdall rest server start:" + err.Error())
+			return false
+		} else {
+			fmt.Println("Successfully started rest server")
+			appstate.UpdateState(appstate.StartingErigon)
+		}
+	}
+	return true
+}
+
+// executionClientOptions returns the options the execution client has to be started with
+func executionClientOptions() (utils.ExecutionClientOptions, error) {
+	options := utils.ExecutionClientOptions{Network: appstate.CurrentNetwork(), Erigon: appstate.CurrentState.Erigon}
+	extip, err := utils.NodeExternalIP()
+	if err != nil {
+		// the client is still started, it only advertises the address peers see it connect from
+		utils.WriteError("Error getting external IP:" + err.Error())
+	}
+	options.ExternalIP = extip
+	if appstate.CurrentState.Validator.Enabled {
+		key, err := loadSignerKey()
+		if err != nil {
+			return options, fmt.Errorf("error loading signer key: %v", err)
+		}
+		options.SignerKey, err = key.ECDSA()
+		if err != nil {
+			return options, fmt.Errorf("error loading signer key: %v", err)
+		}
+		options.StaticPeers = appstate.CurrentState.Validator.ExecutionSentries
+	}
+	return options, nil
+}
+
+// startExecutionClient starts the execution client the node was installed with
+func startExecutionClient() bool {
+	client := utils.CurrentExecutionClient()
+	fmt.Println("Starting " + client.Name() + "...")
+	fmt.Println(client.Name() + " will start on " + appstate.CurrentNetwork().Name)
+	_ = utils.StopContainerByName(client.ContainerName()) // try and stop the client if it's already running
+	options, err := executionClientOptions()
+	if err != nil {
+		utils.WriteError(err.Error())
+		return false
+	}
+	if options.SignerKey != nil {
+		fmt.Println(client.Name() + " will start as validator")
+	}
+	localPath := utils.ExecutionDataPath(client)
+	err = client.Prepare(localPath, options)
+	if err != nil {
+		utils.WriteError("Error preparing " + client.Name() + ":" + err.Error())
+		return false
+	}
+	_, err = utils.DockerRun(client.Image(), client.Args(options), client.ContainerDataPath(), localPath, client.Ports(options), true, "polygon", true, client.ContainerName(), false)
+	if err != nil {
+		utils.WriteError("Error during " + client.Name() + " start:" + err.Error())
+		return false
+	} else {
+		fmt.Println("Successfully started " + client.Name())
+	}
+	return true
+}
+
+// restartExecutionClient restarts the execution client so it picks up a new configuration
+func restartExecutionClient() bool {
+	if appstate.CurrentState.State != appstate.NodeStarted {
+		// the client is not running, the configuration will be used on next start
+		return true
+	}
+	if appstate.CurrentState.ErigonSnapshotSource != "" && !appstate.CurrentState.ErigonSnapshotDownloaded {
+		// the client is booted with the new configuration once its snapshot is extracted
+		return true
+	}
+	return startExecutionClient()
+}
+
+// restartHeimdall restarts the heimdall node and its rest server so they pick up a new configuration
+func restartHeimdall() bool {
+	if appstate.CurrentState.State != appstate.NodeStarted || !appstate.CurrentState.HeimdallSnapshotDownloaded {
+		// heimdall is not running, the configuration will be used on next start
+		return true
+	}
+	fmt.Println("Restarting Heimdall...")
+	return startHeimdallFrom(appstate.StartingHeimdall)
+}
+
+// startHeimdallFrom starts heimdall from the given state on a running node. The node is set back to NodeStarted if
+// it fails, so it can be stopped or restarted instead of being stuck in a starting state.
+func startHeimdallFrom(state appstate.AppStateEnum) bool {
+	appstate.UpdateState(state)
+	started := startHeimdall(utils.HeimdallDataPath())
+	appstate.UpdateState(appstate.NodeStarted)
+	return started
+}
+
+// bootHeimdallAfterSnapshot starts heimdall once the snapshot started by startTask has been downloaded.
+// It is called lazily by the monitoring tasks and returns true if heimdall is ready to be queried.
+func bootHeimdallAfterSnapshot() bool {
+	if appstate.CurrentState.HeimdallSnapshotDownloaded {
+		return true
+	}
+	if appstate.CurrentState.State != appstate.NodeStarted {
+		// node is not running or is still being started
+		return false
+	}
+
+	// the polled tasks run in their own processes, only one of them boots heimdall
+	unlock, err := appstate.Lock("boot")
+	if err != nil {
+		utils.WriteError("Error locking the heimdall boot:" + err.Error())
+		return false
+	}
+	defer unlock()
+	if err := appstate.LoadState(); err == nil && appstate.CurrentState.HeimdallSnapshotDownloaded {
+		// booted by another process meanwhile
+		return true
+	}
+
+	validated, err := utils.HeimdallSnapshotSucceeded()
+	if err != nil {
+		utils.WriteError("Error validating heimdall snapshot:" + err.Error())
+		return false
+	}
+	if !validated {
+		return false
+	}
+
+	fmt.Println("Heimdall snapshot downloaded, starting Heimdall...")
+	if !startHeimdallFrom(appstate.StartingHeimdall) {
+		// the downloader is kept, the next call retries
+		utils.WriteError("Error starting heimdall after its snapshot, it is retried by the next status")
+		return false
+	}
+	appstate.UpdateSnapshotDownloaded(true)
+	if err := utils.RemoveHeimdallSnapshotDownloader(); err != nil {
+		utils.WriteError("Error removing heimdall snapshot downloader:" + err.Error())
+	}
+	return true
+}
+
+// bootErigonAfterSnapshot starts erigon once the snapshot started by startTask has been extracted.
+// It is called lazily by the monitoring tasks and returns true if erigon is ready to be queried.
+func bootErigonAfterSnapshot() bool {
+	if appstate.CurrentState.ErigonSnapshotSource == "" || appstate.CurrentState.ErigonSnapshotDownloaded {
+		return true
+	}
+	if appstate.CurrentState.State != appstate.NodeStarted {
+		// node is not running or is still being started
+		return false
+	}
+
+	unlock, err := appstate.Lock("boot")
+	if err != nil {
+		utils.WriteError("Error locking the erigon boot:" + err.Error())
+		return false
+	}
+	defer unlock()
+	if err := appstate.LoadState(); err == nil && appstate.CurrentState.ErigonSnapshotDownloaded {
+		// booted by another process meanwhile
+		return true
+	}
+
+	validated, err := utils.ErigonSnapshotSucceeded()
+	if err != nil {
+		utils.WriteError("Error validating erigon snapshot:" + err.Error())
+		return false
+	}
+	if !validated {
+		return false
+	}
+
+	fmt.Println("Erigon snapshot extracted, starting Erigon...")
+	if !startExecutionClient() {
+		// the downloader is kept, the next call retries
+		utils.WriteError("Error starting erigon after its snapshot, it is retried by the next status")
+		return false
+	}
+	appstate.UpdateErigonSnapshotDownloaded(true)
+	if err := utils.RemoveErigonSnapshotDownloader(); err != nil {
+		utils.WriteError("Error removing erigon snapshot downloader:" + err.Error())
+	}
+	return true
+}
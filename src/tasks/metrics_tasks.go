@@ -0,0 +1,216 @@
+package tasks
+
+import (
+	"KeepixPlugin/appstate"
+	"KeepixPlugin/utils"
+	"fmt"
+	"math/big"
+	"path"
+	"strconv"
+)
+
+// metricsFileName is the file written for the node exporter textfile collector
+const metricsFileName = "keepix_polygon.prom"
+
+// etherFloat converts a wei amount to a float ether amount, precise enough for metrics
+func etherFloat(wei *big.Int) float64 {
+	ether, _ := strconv.ParseFloat(weiToEther(wei), 64)
+	return ether
+}
+
+func boolMetric(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// collectStateMetrics exports the plugin state and the containers status
+func collectStateMetrics(metrics *utils.MetricsWriter) error {
+	metrics.Gauge("keepix_polygon_state", "Current state of the plugin.", 1, "state", appstate.CurrentStateString())
+	metrics.Gauge("keepix_polygon_state_code", "Current state of the plugin as its numeric code.", float64(appstate.CurrentState.State))
+	metrics.Gauge("keepix_polygon_validator_enabled", "Whether the node runs as a validator.", boolMetric(appstate.CurrentState.Validator.Enabled))
+
+	containers := []string{"heimdall", "heimdall-rest", utils.CurrentExecutionClient().ContainerName(), "heimdall-snapshot-downloader", "erigon-snapshot-downloader"}
+	if appstate.CurrentState.Validator.Enabled {
+		containers = append(containers, "rabbitmq")
+	}
+	for _, container := range containers {
+		running, err := utils.IsContainerRunning(container)
+		if err != nil {
+			return err
+		}
+		metrics.Gauge("keepix_polygon_container_up", "Whether the container is running.", boolMetric(running), "container", container)
+	}
+	return nil
+}
+
+// collectHeimdallMetrics exports the heimdall heights, lag and peers
+func collectHeimdallMetrics(metrics *utils.MetricsWriter) error {
+	if !appstate.CurrentState.HeimdallSnapshotDownloaded {
+		progress, err := utils.SnapshotProgress()
+		if err != nil {
+			return err
+		}
+		metrics.Gauge("keepix_polygon_snapshot_progress", "Progress of the snapshot download in percent.", float64(progress), "component", "heimdall")
+		return nil
+	}
+
+	status, err := utils.GetHeimdallNodeStatus()
+	if err != nil {
+		return err
+	}
+	height, _ := strconv.ParseInt(status.Result.SyncInfo.LatestBlockHeight, 10, 64)
+	tip, _ := strconv.ParseInt(status.Result.SyncInfo.CurrentBlockHeight, 10, 64)
+	lag := tip - height
+	if lag < 0 {
+		lag = 0
+	}
+	metrics.Gauge("keepix_polygon_heimdall_height", "Latest block of the local heimdall node.", float64(height))
+	metrics.Gauge("keepix_polygon_heimdall_chain_tip", "Latest heimdall block of the reference endpoint.", float64(tip), "source", status.Result.SyncInfo.ReferenceSource)
+	metrics.Gauge("keepix_polygon_heimdall_lag", "Blocks between the local heimdall node and the chain tip.", float64(lag))
+	metrics.Gauge("keepix_polygon_heimdall_catching_up", "Whether heimdall is catching up.", boolMetric(status.Result.SyncInfo.CatchingUp))
+
+	netInfo, err := utils.GetHeimdallNetInfo()
+	if err != nil {
+		return err
+	}
+	peers, _ := strconv.Atoi(netInfo.Result.NPeers)
+	metrics.Gauge("keepix_polygon_heimdall_peers", "Peers of the heimdall node.", float64(peers))
+	return nil
+}
+
+// collectExecutionMetrics exports the execution client heights, lag, sync rate and peers
+func collectExecutionMetrics(metrics *utils.MetricsWriter) error {
+	client := utils.CurrentExecutionClient()
+	if appstate.CurrentState.ErigonSnapshotSource != "" && !appstate.CurrentState.ErigonSnapshotDownloaded {
+		progress, _, err := utils.ErigonSnapshotProgress()
+		if err != nil {
+			return err
+		}
+		metrics.Gauge("keepix_polygon_snapshot_progress", "Progress of the snapshot download in percent.", float64(progress), "component", client.Name())
+		return nil
+	}
+
+	status, err := client.SyncingStatus()
+	if err != nil {
+		return err
+	}
+	currentBlock := status.CurrentBlock
+	highestBlock := status.HighestBlock
+	if status.Stage == "Synced" {
+		// synced clients do not report their heights through eth_syncing
+		currentBlock, err = utils.GetExecutionBlockNumber()
+		if err != nil {
+			return err
+		}
+		highestBlock = currentBlock
+	}
+	labels := []string{"client", client.Name()}
+	metrics.Gauge("keepix_polygon_execution_synced", "Whether the execution client is synced.", boolMetric(status.Stage == "Synced"), labels...)
+	metrics.Gauge("keepix_polygon_execution_sync_progress", "Sync progress of the execution client in percent.", float64(status.Progress), labels...)
+	if highestBlock > 0 {
+		lag := float64(0)
+		if highestBlock > currentBlock {
+			lag = float64(highestBlock - currentBlock)
+		}
+		metrics.Gauge("keepix_polygon_execution_height", "Current block of the execution client.", float64(currentBlock), labels...)
+		metrics.Gauge("keepix_polygon_execution_highest_block", "Highest block known by the execution client.", float64(highestBlock), labels...)
+		metrics.Gauge("keepix_polygon_execution_lag", "Blocks between the execution client and the highest known block.", lag, labels...)
+		metrics.Gauge("keepix_polygon_execution_blocks_per_second", "Sync rate of the execution client.", status.BlocksPerSecond, labels...)
+		metrics.Gauge("keepix_polygon_execution_eta_seconds", "Estimated time before the execution client is synced, -1 when unknown.", float64(status.ETASeconds), labels...)
+	}
+	for _, stage := range status.Stages {
+		metrics.Gauge("keepix_polygon_execution_stage_progress", "Progress of an erigon stage in percent.", float64(stage.Progress), "client", client.Name(), "stage", stage.Name)
+	}
+
+	peers, err := utils.GetExecutionPeerCount()
+	if err != nil {
+		return err
+	}
+	metrics.Gauge("keepix_polygon_execution_peers", "Peers of the execution client.", float64(peers), labels...)
+	return nil
+}
+
+// collectWalletMetrics exports the balances of the wallet
+func collectWalletMetrics(metrics *utils.MetricsWriter) error {
+	address := appstate.CurrentState.Wallet.Address
+	if address == "" {
+		return nil
+	}
+	client, err := utils.NewBlockchainClient(appstate.CurrentState.RPC)
+	if err != nil {
+		return err
+	}
+	ethBalance, err := client.GetETHBalance(address)
+	if err != nil {
+		return err
+	}
+	maticBalance, err := client.GetERC20Balance(appstate.CurrentNetwork().MaticAddress, address)
+	if err != nil {
+		return err
+	}
+	metrics.Gauge("keepix_polygon_wallet_balance", "Balance of the wallet on L1.", etherFloat(ethBalance), "address", address, "token", "ETH")
+	metrics.Gauge("keepix_polygon_wallet_balance", "Balance of the wallet on L1.", etherFloat(maticBalance), "address", address, "token", "MATIC")
+	return nil
+}
+
+// collectStakingMetrics exports the stake and pending rewards of the wallet for each validator it delegated to
+func collectStakingMetrics(metrics *utils.MetricsWriter) error {
+	if appstate.CurrentState.Wallet.Address == "" {
+		return nil
+	}
+	delegations, err := fetchDelegations()
+	if err != nil {
+		return err
+	}
+	for _, validator := range delegations {
+		labels := []string{"validator_id", strconv.Itoa(validator.Id), "validator", validator.Name}
+		stake, _ := strconv.ParseFloat(validator.UserStake, 64)
+		reward, _ := strconv.ParseFloat(validator.UserReward, 64)
+		metrics.Gauge("keepix_polygon_delegation_stake", "MATIC delegated by the wallet to the validator.", stake, labels...)
+		metrics.Gauge("keepix_polygon_delegation_pending_rewards", "MATIC rewards of the wallet not claimed yet.", reward, labels...)
+	}
+	return nil
+}
+
+// metricsTask writes the node metrics in the prometheus text format for the node exporter textfile collector
+// and returns them. A collector failing is reported by keepix_polygon_collector_success instead of failing the task.
+func metricsTask(args map[string]string) string {
+	filePath := args["path"]
+	if filePath == "" {
+		storage, _ := appstate.GetStoragePath()
+		filePath = path.Join(storage, "metrics", metricsFileName)
+	}
+
+	metrics := utils.NewMetricsWriter()
+	collectors := []struct {
+		name    string
+		collect func(*utils.MetricsWriter) error
+		enabled bool
+	}{
+		{"state", collectStateMetrics, true},
+		{"heimdall", collectHeimdallMetrics, appstate.CurrentState.State == appstate.NodeStarted},
+		{"execution", collectExecutionMetrics, appstate.CurrentState.State == appstate.NodeStarted},
+		{"wallet", collectWalletMetrics, true},
+		{"staking", collectStakingMetrics, true},
+	}
+	for _, collector := range collectors {
+		if !collector.enabled {
+			continue
+		}
+		err := collector.collect(metrics)
+		if err != nil {
+			fmt.Println("Error collecting " + collector.name + " metrics:" + err.Error())
+		}
+		metrics.Gauge("keepix_polygon_collector_success", "Whether the collector succeeded.", boolMetric(err == nil), "collector", collector.name)
+	}
+
+	content := metrics.String()
+	err := utils.WriteMetricsFile(filePath, content)
+	if err != nil {
+		utils.WriteError("Error writing metrics file:" + err.Error())
+		return RESULT_ERROR
+	}
+	return content
+}
@@ -0,0 +1,213 @@
+package tasks
+
+import (
+	"KeepixPlugin/appstate"
+	"KeepixPlugin/utils"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationHealthyWindow is how long the node has to stay healthy on the new copy before the old one is removed
+const migrationHealthyWindow = 5 * time.Minute
+
+// migrationHealthTimeout is how long migrate-data waits for the node to be healthy on the new copy, the old copy is
+// removed lazily by the status task past it
+const migrationHealthTimeout = 10 * time.Minute
+
+// componentDataPath returns the host folder of the chain data of a component
+func componentDataPath(component string) string {
+	if component == backupHeimdall {
+		return utils.HeimdallDataPath()
+	}
+	return utils.ExecutionDataPath(utils.CurrentExecutionClient())
+}
+
+// updateComponentDataPath persists the host folder of the chain data of a component, the default folder is stored
+// as an empty path
+func updateComponentDataPath(component string, hostPath string) error {
+	storage, _ := appstate.GetStoragePath()
+	paths := appstate.CurrentState.DataPaths
+	if component == backupHeimdall {
+		if hostPath == path.Join(storage, "data", "heimdall") {
+			hostPath = ""
+		}
+		paths.Heimdall = hostPath
+	} else {
+		if hostPath == path.Join(storage, "data", utils.CurrentExecutionClient().DataFolder()) {
+			hostPath = ""
+		}
+		paths.Execution = hostPath
+	}
+	return appstate.UpdateDataPaths(paths)
+}
+
+// componentHeight returns the height of a component, an error when its container does not run or serve requests
+func componentHeight(component string) (uint64, error) {
+	name := utils.CurrentExecutionClient().ContainerName()
+	if component == backupHeimdall {
+		name = "heimdall"
+	}
+	info, err := utils.InspectContainer(name)
+	if err != nil {
+		return 0, err
+	}
+	if !info.Running || info.Restarting {
+		return 0, fmt.Errorf("%s is not running", name)
+	}
+	if component == backupHeimdall {
+		status, err := utils.GetHeimdallLocalStatus()
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseUint(status.Result.SyncInfo.LatestBlockHeight, 10, 64)
+	}
+	syncing, err := utils.CurrentExecutionClient().SyncingStatus()
+	if err != nil {
+		return 0, err
+	}
+	return syncing.CurrentBlock, nil
+}
+
+// finishDataMigration removes the old copy of migrated chain data once the node has been healthy on the new one for
+// migrationHealthyWindow and its height moved on. Without a daemon it is called by migrate-data and lazily by the
+// status task.
+func finishDataMigration() {
+	if appstate.CurrentState.Migration == nil || appstate.CurrentState.State != appstate.NodeStarted {
+		return
+	}
+	migration := *appstate.CurrentState.Migration
+	height, err := componentHeight(migration.Component)
+	settled, changed := utils.MigrationSettled(&migration, err == nil, height, int64(migrationHealthyWindow.Seconds()), time.Now().Unix())
+	if !settled {
+		if changed {
+			appstate.UpdateMigration(&migration)
+		}
+		return
+	}
+	fmt.Printf("The node is healthy on %s up to height %d, removing the old copy %s...\n", migration.NewPath, height, migration.OldPath)
+	err = removeHostPaths(migration.OldPath)
+	if err != nil {
+		utils.WriteError("Error removing the old copy of the data:" + err.Error())
+		return
+	}
+	appstate.UpdateMigration(nil)
+	appstate.AppendHistory(appstate.HistoryEntry{Time: time.Now().Unix(), Source: "migrate-data", Component: migration.Component, Action: "old_copy_removed", Message: migration.OldPath})
+}
+
+// migrateDataTask moves the chain data of a component, execution by default or heimdall, to the target folder,
+// another disk for instance, without resyncing. The node is stopped while the data is copied and verified, then
+// started on the new copy. The old copy is only removed once the node stayed healthy on the new one and moved past
+// its height at migration, until then migrating back to the old folder switches back to it without copying. The
+// optional verify argument set to checksum compares the content of the files instead of their sizes and dates.
+func migrateDataTask(args map[string]string) string {
+	component := strings.TrimSpace(args["component"])
+	if component == "" {
+		component = backupExecution
+	}
+	if component != backupHeimdall && component != backupExecution {
+		utils.WriteError("Invalid component, expected " + backupHeimdall + " or " + backupExecution)
+		return RESULT_ERROR
+	}
+	target := filepath.Clean(strings.TrimSpace(args["target"]))
+	if !filepath.IsAbs(target) {
+		utils.WriteError("Invalid target, expected an absolute path")
+		return RESULT_ERROR
+	}
+	current := componentDataPath(component)
+	wasRunning := appstate.CurrentState.State > appstate.NodeInstalled
+
+	migration := appstate.CurrentState.Migration
+	if migration != nil {
+		if migration.Component != component || target != migration.OldPath {
+			utils.WriteError("The " + migration.Component + " data moved to " + migration.NewPath + " is waiting for the node to be healthy to remove its old copy " +
+				migration.OldPath + ", start the node or migrate back to " + migration.OldPath)
+			return RESULT_ERROR
+		}
+		// the old copy is still there, switch back to it
+		if wasRunning && stopTask(map[string]string{}) == RESULT_ERROR {
+			return RESULT_ERROR
+		}
+		updateComponentDataPath(component, migration.OldPath)
+		appstate.UpdateMigration(nil)
+		appstate.AppendHistory(appstate.HistoryEntry{Time: time.Now().Unix(), Source: "migrate-data", Component: component, Action: "reverted", Message: migration.OldPath})
+		fmt.Println("Switched back to " + migration.OldPath + ", the copy in " + migration.NewPath + " can be removed")
+		if wasRunning {
+			return startTask(map[string]string{})
+		}
+		return RESULT_SUCCESS
+	}
+
+	if isInside(target, current) || isInside(current, target) {
+		utils.WriteError("Invalid target, it cannot contain or be inside " + current)
+		return RESULT_ERROR
+	}
+	if entries, err := os.ReadDir(target); err == nil && len(entries) > 0 {
+		utils.WriteError("Invalid target, " + target + " is not empty")
+		return RESULT_ERROR
+	}
+	if component == backupHeimdall && !appstate.CurrentState.HeimdallSnapshotDownloaded {
+		utils.WriteError("Heimdall is still bootstrapping from its snapshot, wait for it to complete")
+		return RESULT_ERROR
+	}
+	if component == backupExecution && appstate.CurrentState.ErigonSnapshotSource != "" && !appstate.CurrentState.ErigonSnapshotDownloaded {
+		utils.WriteError("Erigon is still bootstrapping from its snapshot, wait for it to complete")
+		return RESULT_ERROR
+	}
+	size, _ := utils.FolderSize(current)
+	// the old copy is only removed once the node moved past this height
+	height, _ := componentHeight(component)
+	if usage, err := utils.GetDiskUsage(existingParent(target)); err == nil && usage.Free < size {
+		utils.WriteError(fmt.Sprintf("Not enough disk space: the data is %d GB, %d GB are available on %s", size/1e9, usage.Free/1e9, target))
+		return RESULT_ERROR
+	}
+
+	if wasRunning {
+		fmt.Println("Stopping the node...")
+		if stopTask(map[string]string{}) == RESULT_ERROR {
+			return RESULT_ERROR
+		}
+	}
+	err := os.MkdirAll(target, os.ModePerm)
+	if err == nil {
+		fmt.Printf("Copying %d GB from %s to %s...\n", size/1e9, current, target)
+		err = utils.CopyHostFolder(current, target, args["verify"] == "checksum")
+	}
+	if err != nil {
+		utils.WriteError("Error copying data:" + err.Error())
+		// the target was empty, only the partial copy is removed
+		if removeErr := removeHostPaths(target); removeErr != nil {
+			utils.WriteError("Error removing the partial copy:" + removeErr.Error())
+		}
+		if wasRunning {
+			startTask(map[string]string{})
+		}
+		return RESULT_ERROR
+	}
+	fmt.Println("Successfully copied and verified the data")
+
+	updateComponentDataPath(component, target)
+	appstate.UpdateMigration(&appstate.DataMigration{Component: component, OldPath: current, NewPath: target, MigratedAt: time.Now().Unix(), Height: height})
+	appstate.AppendHistory(appstate.HistoryEntry{Time: time.Now().Unix(), Source: "migrate-data", Component: component, Action: "migrated", Message: current + " -> " + target})
+
+	if !wasRunning {
+		fmt.Println("The old copy " + current + " is removed once the node is started and healthy")
+		return RESULT_SUCCESS
+	}
+	if startTask(map[string]string{}) == RESULT_ERROR {
+		return RESULT_ERROR
+	}
+	deadline := time.Now().Add(migrationHealthTimeout)
+	for appstate.CurrentState.Migration != nil && time.Now().Before(deadline) {
+		time.Sleep(15 * time.Second)
+		finishDataMigration()
+	}
+	if appstate.CurrentState.Migration != nil {
+		fmt.Println("The node is not healthy for long enough yet, the old copy " + current + " is removed by the status task once it is")
+	}
+	return RESULT_SUCCESS
+}
@@ -5,7 +5,9 @@ import (
 	"KeepixPlugin/utils"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
+	"time"
 )
 
 // returns plugins installation status
@@ -19,20 +21,40 @@ type NodeStatus struct {
 	Alive     bool   `json:"Alive"`
 }
 
-// returns plugins status
-func statusTask(args map[string]string) string {
-	_, err := utils.GetHeimdallNodeStatus()
-	_, err2 := utils.GetErigonSyncingStatus()
+// runLazyMaintenance does what a daemon would do in the background, called by the polled status tasks: it boots the
+// components once their snapshot is ready, renews the gateway port mappings and removes the old copy of migrated data
+func runLazyMaintenance() {
+	bootHeimdallAfterSnapshot()
+	bootErigonAfterSnapshot()
+	if appstate.CurrentState.State == appstate.NodeStarted {
+		refreshPortMappings(false)
+		finishDataMigration()
+	}
+}
 
+// readNodeStatus returns the status of the node without side effects
+func readNodeStatus() NodeStatus {
+	_, err := utils.GetHeimdallNodeStatus()
 	if !appstate.CurrentState.HeimdallSnapshotDownloaded {
 		_, err = utils.SnapshotProgress()
 	}
+	var err2 error
+	if appstate.CurrentState.ErigonSnapshotSource == "" || appstate.CurrentState.ErigonSnapshotDownloaded {
+		_, err2 = utils.CurrentExecutionClient().SyncingStatus()
+	} else {
+		_, _, err2 = utils.ErigonSnapshotProgress()
+	}
 
-	// Create an instance of NodeStatus
-	status := NodeStatus{
+	return NodeStatus{
 		NodeState: appstate.CurrentStateString(),
 		Alive:     err == nil && err2 == nil,
 	}
+}
+
+// returns plugins status
+func statusTask(args map[string]string) string {
+	runLazyMaintenance()
+	status := readNodeStatus()
 
 	// Serialize the struct to JSON
 	jsonBytes, err := json.Marshal(status)
@@ -46,7 +68,7 @@ func statusTask(args map[string]string) string {
 
 type LogsResponse struct {
 	HeimdallLogs string `json:"heimdallLogs"`
-	ErigonLogs   string `json:"erigonLogs"`
+	ErigonLogs   string `json:"erigonLogs"` // logs of the execution client, whichever it is
 }
 
 func logsTask(args map[string]string) string {
@@ -63,7 +85,7 @@ func logsTask(args map[string]string) string {
 		return RESULT_SUCCESS
 	}
 	if erigonLogs == "true" {
-		output, err := utils.FetchContainerLogs("erigon", linesAmount)
+		output, err := utils.FetchContainerLogs(utils.CurrentExecutionClient().ContainerName(), linesAmount)
 		if err != nil {
 			utils.WriteError("Error getting logs:" + err.Error())
 			return RESULT_ERROR
@@ -90,29 +112,58 @@ func logsTask(args map[string]string) string {
 }
 
 type SyncState struct {
-	IsSynced                bool    `json:"IsSynced"`
-	ErigonSyncProgress      float32 `json:"erigonSyncProgress"`
-	HeimdallSyncProgress    float32 `json:"heimdallSyncProgress"`
-	ErigonStepDescription   string  `json:"erigonStepDescription"`
-	HeimdallStepDescription string  `json:"heimdallStepDescription"`
+	IsSynced                bool                  `json:"IsSynced"`
+	ExecutionClient         string                `json:"executionClient"`
+	ErigonSyncProgress      float32               `json:"erigonSyncProgress"` // erigon fields report the execution client, whichever it is
+	HeimdallSyncProgress    float32               `json:"heimdallSyncProgress"`
+	ErigonStepDescription   string                `json:"erigonStepDescription"`
+	HeimdallStepDescription string                `json:"heimdallStepDescription"`
+	ErigonStages            []utils.StageProgress `json:"erigonStages"`
+	ErigonCurrentBlock      uint64                `json:"erigonCurrentBlock"`
+	ErigonHighestBlock      uint64                `json:"erigonHighestBlock"`
+	ErigonBlocksPerSecond   float64               `json:"erigonBlocksPerSecond"`
+	ErigonETASeconds        int64                 `json:"erigonEtaSeconds"` // -1 when unknown
 }
 
 func getChainTask(args map[string]string) string {
-	if !appstate.CurrentState.IsTestnet {
-		return "mainnet"
-	} else {
-		return "testnet"
-	}
+	return appstate.CurrentNetwork().Name
 }
 
-func syncStateTask(args map[string]string) string {
+// networksTask returns the networks the node can be installed on
+func networksTask(args map[string]string) string {
+	networks := make([]appstate.Network, 0, len(appstate.Networks))
+	for _, network := range appstate.Networks {
+		networks = append(networks, network)
+	}
+	sort.Slice(networks, func(i, j int) bool { return networks[i].Name < networks[j].Name })
 
-	erigonState, err := utils.GetErigonSyncingStatus()
+	// Serialize the struct to JSON
+	jsonBytes, err := json.Marshal(networks)
 	if err != nil {
-		utils.WriteError("Error getting erigon node status:" + err.Error())
+		utils.WriteError("Error serializing to JSON:" + err.Error())
 		return RESULT_ERROR
 	}
 
+	return string(jsonBytes)
+}
+
+// readSyncState returns the sync state of heimdall and of the execution client without side effects
+func readSyncState() (*SyncState, error) {
+	var erigonState *utils.SyncingStatus
+	var err error
+	if appstate.CurrentState.ErigonSnapshotSource == "" || appstate.CurrentState.ErigonSnapshotDownloaded {
+		erigonState, err = utils.CurrentExecutionClient().SyncingStatus()
+		if err != nil {
+			return nil, fmt.Errorf("error getting %s node status: %v", utils.CurrentExecutionClient().Name(), err)
+		}
+	} else {
+		progress, description, err := utils.ErigonSnapshotProgress()
+		if err != nil {
+			return nil, fmt.Errorf("error getting erigon snapshot progress: %v", err)
+		}
+		erigonState = &utils.SyncingStatus{Progress: progress, Stage: description, ETASeconds: -1}
+	}
+
 	var heimdallStepDescription string
 	var progress float32
 	var heimdallSynced = false
@@ -120,8 +171,7 @@ func syncStateTask(args map[string]string) string {
 	if appstate.CurrentState.HeimdallSnapshotDownloaded {
 		heimdallState, err := utils.GetHeimdallNodeStatus()
 		if err != nil {
-			utils.WriteError("Error getting heimdall node status:" + err.Error())
-			return RESULT_ERROR
+			return nil, fmt.Errorf("error getting heimdall node status: %v", err)
 		}
 
 		blockHeight, _ := strconv.Atoi(heimdallState.Result.SyncInfo.LatestBlockHeight)
@@ -131,7 +181,12 @@ func syncStateTask(args map[string]string) string {
 			progress = 100
 			heimdallStepDescription = "Synced"
 		} else {
-			progress = float32(blockHeight) / float32(currentBlockHeight) * 100
+			if currentBlockHeight > 0 {
+				progress = float32(blockHeight) / float32(currentBlockHeight) * 100
+			}
+			if progress > 100 {
+				progress = 100
+			}
 			heimdallStepDescription = "Syncing"
 		}
 
@@ -145,21 +200,36 @@ func syncStateTask(args map[string]string) string {
 
 		progress, err = utils.SnapshotProgress()
 		if err != nil {
-			utils.WriteError("Error getting snapshot progress:" + err.Error())
-			return RESULT_ERROR
+			return nil, fmt.Errorf("error getting snapshot progress: %v", err)
 		}
 		if progress == 100 {
-			heimdallStepDescription = "Snapshot downloaded, restart Heimdall"
+			heimdallStepDescription = "Snapshot downloaded, starting Heimdall"
 		}
 	}
 
 	status := &SyncState{
 		IsSynced:                heimdallSynced && erigonState.Stage == "Synced",
+		ExecutionClient:         utils.CurrentExecutionClient().Name(),
 		ErigonSyncProgress:      erigonState.Progress,
 		HeimdallSyncProgress:    progress,
 		ErigonStepDescription:   erigonState.Stage,
+		ErigonStages:            erigonState.Stages,
+		ErigonCurrentBlock:      erigonState.CurrentBlock,
+		ErigonHighestBlock:      erigonState.HighestBlock,
+		ErigonBlocksPerSecond:   erigonState.BlocksPerSecond,
+		ErigonETASeconds:        erigonState.ETASeconds,
 		HeimdallStepDescription: heimdallStepDescription,
 	}
+	return status, nil
+}
+
+func syncStateTask(args map[string]string) string {
+	runLazyMaintenance()
+	status, err := readSyncState()
+	if err != nil {
+		utils.WriteError("Error getting sync state:" + err.Error())
+		return RESULT_ERROR
+	}
 
 	// Serialize the struct to JSON
 	jsonBytes, err := json.Marshal(status)
@@ -170,3 +240,160 @@ func syncStateTask(args map[string]string) string {
 
 	return string(jsonBytes)
 }
+
+type ContainerStatsResponse struct {
+	Name          string                `json:"name"`
+	Running       bool                  `json:"running"`
+	RestartCount  int                   `json:"restartCount"`
+	StartedAt     string                `json:"startedAt"`
+	UptimeSeconds int64                 `json:"uptimeSeconds"`
+	Stats         *utils.ContainerStats `json:"stats"` // null when the container is not running
+}
+
+// statsTask returns the resource usage, restart count and uptime of the node containers
+func statsTask(args map[string]string) string {
+	containers := []string{"heimdall", "heimdall-rest", utils.CurrentExecutionClient().ContainerName()}
+	if appstate.CurrentState.Validator.Enabled {
+		containers = append(containers, "rabbitmq")
+	}
+
+	response := []ContainerStatsResponse{}
+	for _, container := range containers {
+		info, err := utils.InspectContainer(container)
+		if err != nil {
+			utils.WriteError("Error inspecting " + container + ":" + err.Error())
+			return RESULT_ERROR
+		}
+		stats := ContainerStatsResponse{Name: container, Running: info.Running, RestartCount: info.RestartCount}
+		if info.Running {
+			stats.StartedAt = info.StartedAt
+			startedAt, err := time.Parse(time.RFC3339Nano, info.StartedAt)
+			if err == nil {
+				stats.UptimeSeconds = int64(time.Since(startedAt).Seconds())
+			}
+			stats.Stats, err = utils.GetContainerStats(container)
+			if err != nil {
+				utils.WriteError("Error fetching " + container + " stats:" + err.Error())
+				return RESULT_ERROR
+			}
+		}
+		response = append(response, stats)
+	}
+
+	// Serialize the struct to JSON
+	jsonBytes, err := json.Marshal(response)
+	if err != nil {
+		utils.WriteError("Error serializing to JSON:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	return string(jsonBytes)
+}
+
+type DiagnoseResponse struct {
+	Checked  []string                  `json:"checked"` // containers whose logs were read
+	Findings []utils.DiagnosticFinding `json:"findings"`
+}
+
+// diagnoseTask looks for known failures in the latest logs of the node containers, the optional lines argument
+// defaults to the last 1000 lines of each container
+func diagnoseTask(args map[string]string) string {
+	linesAmount := 1000
+	if args["lines"] != "" {
+		var err error
+		linesAmount, err = strconv.Atoi(args["lines"])
+		if err != nil || linesAmount <= 0 {
+			utils.WriteError("Invalid lines amount")
+			return RESULT_ERROR
+		}
+	}
+
+	containers := map[string]string{
+		utils.ComponentHeimdall:     "heimdall",
+		utils.ComponentHeimdallRest: "heimdall-rest",
+		utils.ComponentExecution:    utils.CurrentExecutionClient().ContainerName(),
+	}
+	response := DiagnoseResponse{Checked: []string{}}
+	logs := make(map[string]string)
+	for _, component := range []string{utils.ComponentHeimdall, utils.ComponentHeimdallRest, utils.ComponentExecution} {
+		container := containers[component]
+		info, err := utils.InspectContainer(container)
+		if err != nil {
+			utils.WriteError("Error inspecting " + container + ":" + err.Error())
+			return RESULT_ERROR
+		}
+		if !info.Exists {
+			continue
+		}
+		output, err := utils.FetchContainerLogs(container, linesAmount)
+		if err != nil {
+			utils.WriteError("Error getting logs:" + err.Error())
+			return RESULT_ERROR
+		}
+		logs[component] = output
+		response.Checked = append(response.Checked, container)
+	}
+	response.Findings = utils.Diagnose(logs)
+
+	// Serialize the struct to JSON
+	jsonBytes, err := json.Marshal(response)
+	if err != nil {
+		utils.WriteError("Error serializing to JSON:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	return string(jsonBytes)
+}
+
+type FolderUsage struct {
+	Name string           `json:"name"`
+	Path string           `json:"path"`
+	Size uint64           `json:"size"`
+	Disk *utils.DiskUsage `json:"disk,omitempty"` // only for folders moved out of the plugin folder
+}
+
+type DiskResponse struct {
+	Disk        *utils.DiskUsage `json:"disk"`
+	UsedPercent float64          `json:"usedPercent"`
+	Folders     []FolderUsage    `json:"folders"`
+}
+
+// diskTask returns the usage of the disk holding the node data and the size of the data of each component
+func diskTask(args map[string]string) string {
+	storage, _ := appstate.GetStoragePath()
+	usage, err := utils.GetDiskUsage(storage)
+	if err != nil {
+		utils.WriteError("Error getting disk usage:" + err.Error())
+		return RESULT_ERROR
+	}
+	response := DiskResponse{Disk: usage, Folders: []FolderUsage{}}
+	if usage.Total > 0 {
+		response.UsedPercent = float64(usage.Used) / float64(usage.Total) * 100
+	}
+
+	client := utils.CurrentExecutionClient()
+	folders := []FolderUsage{
+		{Name: "heimdall", Path: utils.HeimdallDataPath()},
+		{Name: client.Name(), Path: utils.ExecutionDataPath(client)},
+	}
+	for _, folder := range folders {
+		folder.Size, err = utils.FolderSize(folder.Path)
+		if err != nil {
+			utils.WriteError("Error getting " + folder.Name + " data size:" + err.Error())
+			return RESULT_ERROR
+		}
+		if !isInside(folder.Path, storage) {
+			folder.Disk, _ = utils.GetDiskUsage(folder.Path)
+		}
+		response.Folders = append(response.Folders, folder)
+	}
+
+	// Serialize the struct to JSON
+	jsonBytes, err := json.Marshal(response)
+	if err != nil {
+		utils.WriteError("Error serializing to JSON:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	return string(jsonBytes)
+}
@@ -0,0 +1,253 @@
+package tasks
+
+import (
+	"KeepixPlugin/appstate"
+	"KeepixPlugin/utils"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type HeimdallPeer struct {
+	ID            string `json:"id"`
+	Moniker       string `json:"moniker"`
+	RemoteIP      string `json:"remoteIp"`
+	IsOutbound    bool   `json:"isOutbound"`
+	Duration      string `json:"duration"`
+	BytesSent     int64  `json:"bytesSent"`
+	BytesReceived int64  `json:"bytesReceived"`
+	SendRate      int64  `json:"sendRate"`
+	ReceiveRate   int64  `json:"receiveRate"`
+}
+
+type HeimdallPeersResponse struct {
+	Listening bool           `json:"listening"`
+	PeerCount int            `json:"peerCount"`
+	Peers     []HeimdallPeer `json:"peers"`
+}
+
+type HeimdallNodeIDResponse struct {
+	ID          string `json:"id"`
+	Moniker     string `json:"moniker"`
+	Network     string `json:"network"`
+	PeerAddress string `json:"peerAddress"`
+}
+
+// heimdallPeersTask returns the P2P peers of heimdall and their traffic
+func heimdallPeersTask(args map[string]string) string {
+	netInfo, err := utils.GetHeimdallNetInfo()
+	if err != nil {
+		utils.WriteError("Error getting heimdall net info:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	peerCount, _ := strconv.Atoi(netInfo.Result.NPeers)
+	response := HeimdallPeersResponse{
+		Listening: netInfo.Result.Listening,
+		PeerCount: peerCount,
+		Peers:     []HeimdallPeer{},
+	}
+	for _, peer := range netInfo.Result.Peers {
+		bytesSent, _ := strconv.ParseInt(peer.ConnectionStatus.SendMonitor.Bytes, 10, 64)
+		bytesReceived, _ := strconv.ParseInt(peer.ConnectionStatus.RecvMonitor.Bytes, 10, 64)
+		sendRate, _ := strconv.ParseInt(peer.ConnectionStatus.SendMonitor.AvgRate, 10, 64)
+		receiveRate, _ := strconv.ParseInt(peer.ConnectionStatus.RecvMonitor.AvgRate, 10, 64)
+		response.Peers = append(response.Peers, HeimdallPeer{
+			ID:            peer.NodeInfo.ID,
+			Moniker:       peer.NodeInfo.Moniker,
+			RemoteIP:      peer.RemoteIP,
+			IsOutbound:    peer.IsOutbound,
+			Duration:      peer.ConnectionStatus.Duration,
+			BytesSent:     bytesSent,
+			BytesReceived: bytesReceived,
+			SendRate:      sendRate,
+			ReceiveRate:   receiveRate,
+		})
+	}
+
+	// Serialize the struct to JSON
+	jsonBytes, err := json.Marshal(response)
+	if err != nil {
+		utils.WriteError("Error serializing to JSON:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	return string(jsonBytes)
+}
+
+// heimdallPeersAddTask adds peers to the persistent peers of heimdall and restarts it to connect to them. Peers are
+// always persistent, dialing them without a restart needs the unsafe RPC of tendermint which stays disabled.
+func heimdallPeersAddTask(args map[string]string) string {
+	peers := strings.TrimSpace(args["peers"])
+	if peers == "" {
+		utils.WriteError("No peers provided")
+		return RESULT_ERROR
+	}
+	if err := utils.ValidateTendermintPeers(peers); err != nil {
+		utils.WriteError("Invalid peers:" + err.Error())
+		return RESULT_ERROR
+	}
+	peerList := strings.Split(peers, ",")
+	for i := range peerList {
+		peerList[i] = strings.TrimSpace(peerList[i])
+	}
+
+	settings, err := readHeimdallSettings()
+	if err != nil {
+		utils.WriteError("Error reading heimdall config:" + err.Error())
+		return RESULT_ERROR
+	}
+	persistentPeers := mergePeers(settings["persistent_peers"], peerList)
+	if err := writeHeimdallSettings(map[string]string{"persistent_peers": persistentPeers}); err != nil {
+		utils.WriteError("Error updating heimdall config:" + err.Error())
+		return RESULT_ERROR
+	}
+	fmt.Println("Successfully added persistent peers to heimdall config")
+
+	// heimdall reads its persistent peers on start
+	if !restartHeimdall() {
+		return RESULT_ERROR
+	}
+	return RESULT_SUCCESS
+}
+
+// mergePeers appends the peers not yet present in a comma separated peer list
+func mergePeers(existing string, peers []string) string {
+	var merged []string
+	known := make(map[string]bool)
+	for _, peer := range append(strings.Split(existing, ","), peers...) {
+		peer = strings.TrimSpace(peer)
+		if peer == "" || known[peer] {
+			continue
+		}
+		known[peer] = true
+		merged = append(merged, peer)
+	}
+	return strings.Join(merged, ",")
+}
+
+// heimdallNodeIDTask returns the heimdall node ID and the address other nodes can use to peer with it
+func heimdallNodeIDTask(args map[string]string) string {
+	status, err := utils.GetHeimdallLocalStatus()
+	if err != nil {
+		utils.WriteError("Error getting heimdall node status:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	response := HeimdallNodeIDResponse{
+		ID:      status.Result.NodeInfo.ID,
+		Moniker: status.Result.NodeInfo.Moniker,
+		Network: status.Result.NodeInfo.Network,
+	}
+	extip, err := utils.NodeExternalIP()
+	if err != nil {
+		utils.WriteError("Error getting external IP:" + err.Error())
+	} else {
+		response.PeerAddress = fmt.Sprintf("%s@%s:26656", response.ID, extip)
+	}
+
+	// Serialize the struct to JSON
+	jsonBytes, err := json.Marshal(response)
+	if err != nil {
+		utils.WriteError("Error serializing to JSON:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	return string(jsonBytes)
+}
+
+// portMappingLifetime is how long the gateway keeps the P2P port mappings, they are renewed at half of it
+const portMappingLifetime = 2 * time.Hour
+
+// usesGatewayNAT returns whether the P2P ports are mapped on the gateway with UPnP or NAT-PMP
+func usesGatewayNAT(mode string) bool {
+	return mode == utils.NATModeUPnP || mode == utils.NATModePMP
+}
+
+// publishedPorts returns the ports published by heimdall and the execution client
+func publishedPorts() []uint {
+	options := utils.ExecutionClientOptions{Network: appstate.CurrentNetwork(), Erigon: appstate.CurrentState.Erigon}
+	ports := append([]uint{}, utils.HeimdallPorts...)
+	return append(ports, utils.CurrentExecutionClient().Ports(options)...)
+}
+
+// refreshPortMappings maps the P2P ports on the gateway in the UPnP and NAT-PMP modes. Without a daemon the mappings
+// are renewed when the node is started and lazily by the status task, unless force they are only renewed once half of
+// their lifetime has passed. A failure is not fatal, the node still reaches peers through outbound connections.
+func refreshPortMappings(force bool) {
+	exposure := appstate.CurrentState.Exposure
+	if !usesGatewayNAT(exposure.NATMode) {
+		return
+	}
+	if !force && time.Until(time.Unix(exposure.PortsMappedUntil, 0)) > portMappingLifetime/2 {
+		return
+	}
+	err := utils.MapP2PPorts(exposure.NATMode, publishedPorts(), portMappingLifetime)
+	if err != nil {
+		utils.WriteError("Error mapping P2P ports:" + err.Error())
+		return
+	}
+	exposure.PortsMappedUntil = time.Now().Add(portMappingLifetime).Unix()
+	appstate.UpdateExposure(exposure)
+}
+
+// removePortMappings removes the P2P port mappings from the gateway, if any
+func removePortMappings(mode string) {
+	if !usesGatewayNAT(mode) || appstate.CurrentState.Exposure.PortsMappedUntil == 0 {
+		return
+	}
+	err := utils.UnmapP2PPorts(mode, publishedPorts())
+	if err != nil {
+		utils.WriteError("Error removing P2P port mappings:" + err.Error())
+	}
+	exposure := appstate.CurrentState.Exposure
+	exposure.PortsMappedUntil = 0
+	appstate.UpdateExposure(exposure)
+}
+
+type ExternalIPResponse struct {
+	IP               string              `json:"ip"`
+	Source           string              `json:"source"` // manual, upnp, pmp, echo or cached
+	NATMode          string              `json:"natMode"`
+	IPEchoServices   []string            `json:"ipEchoServices"`
+	PortsMappedUntil int64               `json:"portsMappedUntil"`
+	PortMappings     []utils.PortMapping `json:"portMappings"`
+}
+
+// externalIPTask returns the IP advertised to peers, how it was found and the NAT settings
+func externalIPTask(args map[string]string) string {
+	ip, source, err := utils.ResolveExternalIP()
+	if err != nil {
+		utils.WriteError("Error getting external IP:" + err.Error())
+		return RESULT_ERROR
+	}
+	exposure := appstate.CurrentState.Exposure
+	response := ExternalIPResponse{
+		IP:               ip,
+		Source:           source,
+		NATMode:          exposure.NATMode,
+		IPEchoServices:   exposure.IPEchoServices,
+		PortsMappedUntil: exposure.PortsMappedUntil,
+		PortMappings:     []utils.PortMapping{},
+	}
+	if response.NATMode == "" {
+		response.NATMode = utils.NATModeExtIP
+	}
+	if len(response.IPEchoServices) == 0 {
+		response.IPEchoServices = utils.DefaultIPEchoServices
+	}
+	if usesGatewayNAT(response.NATMode) {
+		response.PortMappings = utils.P2PPortMappings(publishedPorts())
+	}
+
+	// Serialize the struct to JSON
+	jsonBytes, err := json.Marshal(response)
+	if err != nil {
+		utils.WriteError("Error serializing to JSON:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	return string(jsonBytes)
+}
@@ -0,0 +1,367 @@
+package tasks
+
+import (
+	"KeepixPlugin/appstate"
+	"KeepixPlugin/utils"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stakingGasUnits is the gas of an approval and a delegation, the first transactions of a new staker
+const stakingGasUnits = 600000
+
+const gigabyte = 1000 * 1000 * 1000
+
+// preflightOptions describes the node about to be installed
+type preflightOptions struct {
+	network       appstate.Network
+	client        utils.ExecutionClient
+	erigonProfile string
+	ethereumRPC   string
+	walletAddress string
+	reuseData     bool // the chain data kept by uninstall is reused
+}
+
+// diskRequirementsGB returns rough estimates of the data size of heimdall and of the execution client, in GB
+func diskRequirementsGB(options preflightOptions) (float64, float64) {
+	if options.network.Testnet {
+		if options.client.Name() == "erigon" && (options.erigonProfile == "" || options.erigonProfile == "archive") {
+			return 100, 1500
+		}
+		return 100, 500
+	}
+	if options.client.Name() != "erigon" {
+		return 500, 5000
+	}
+	switch options.erigonProfile {
+	case "full":
+		// the receipts, transactions and traces of every block are kept
+		return 500, 6000
+	case "minimal-pruned":
+		return 500, 3000
+	}
+	return 500, 12000
+}
+
+func checkCPU(options preflightOptions) utils.PreflightCheck {
+	cpus := utils.CheckCPUCount()
+	return utils.PreflightCheck{
+		Name:    "cpu",
+		Status:  utils.ThresholdStatus(float64(cpus), 4, 8),
+		Value:   strconv.Itoa(cpus),
+		Message: "4 CPUs are required, 8 are recommended",
+	}
+}
+
+func checkMemory(options preflightOptions) utils.PreflightCheck {
+	minimum, recommended := 16.0, 32.0
+	if options.network.Testnet {
+		minimum, recommended = 8, 16
+	}
+	check := utils.PreflightCheck{Name: "memory", Message: fmt.Sprintf("%.0f GB are required, %.0f GB are recommended", minimum, recommended)}
+	total, err := utils.TotalMemory()
+	if err != nil {
+		check.Status = utils.PreflightWarn
+		check.Message = "Cannot read the memory of the host: " + err.Error()
+		return check
+	}
+	check.Value = fmt.Sprintf("%.1f GB", float64(total)/gigabyte)
+	// the kernel reserves part of the memory, allow 5% less than advertised
+	check.Status = utils.ThresholdStatus(float64(total)/gigabyte*1.05, minimum, recommended)
+	return check
+}
+
+// existingParent returns the path or its nearest existing parent, data folders do not exist before install
+func existingParent(folder string) string {
+	for {
+		if _, err := os.Stat(folder); err == nil {
+			return folder
+		}
+		parent := filepath.Dir(folder)
+		if parent == folder {
+			return folder
+		}
+		folder = parent
+	}
+}
+
+func checkDisks(options preflightOptions) []utils.PreflightCheck {
+	heimdallGB, executionGB := diskRequirementsGB(options)
+	folders := []struct {
+		name       string
+		path       string
+		requiredGB float64
+	}{
+		{"heimdall", utils.HeimdallDataPath(), heimdallGB},
+		{options.client.Name(), utils.ExecutionDataPath(options.client), executionGB},
+	}
+
+	// folders on the same filesystem share its free space, filesystems are told apart by their device
+	type filesystem struct {
+		usage      *utils.DiskUsage
+		names      []string
+		requiredGB float64
+	}
+	filesystems := []*filesystem{}
+	checks := []utils.PreflightCheck{}
+	for _, folder := range folders {
+		usage, err := utils.GetDiskUsage(existingParent(folder.path))
+		if err != nil {
+			checks = append(checks, utils.PreflightCheck{Name: "disk", Status: utils.PreflightWarn, Value: folder.path, Message: "Cannot read the disk usage: " + err.Error()})
+			continue
+		}
+		var found *filesystem
+		for _, existing := range filesystems {
+			if existing.usage.Device == usage.Device {
+				found = existing
+			}
+		}
+		if found == nil {
+			found = &filesystem{usage: usage}
+			filesystems = append(filesystems, found)
+		}
+		found.names = append(found.names, folder.name+" ("+folder.path+")")
+		requiredGB := folder.requiredGB
+		if options.reuseData {
+			// the kept chain data already takes its part of the estimate
+			size, _ := utils.FolderSize(folder.path)
+			requiredGB = math.Max(requiredGB-float64(size)/gigabyte, 0)
+		}
+		found.requiredGB += requiredGB
+	}
+	for _, fs := range filesystems {
+		freeGB := float64(fs.usage.Free) / gigabyte
+		checks = append(checks, utils.PreflightCheck{
+			Name:    "disk",
+			Status:  utils.ThresholdStatus(freeGB, fs.requiredGB, fs.requiredGB*1.2),
+			Value:   fmt.Sprintf("%.0f GB free", freeGB),
+			Message: fmt.Sprintf("About %.0f GB are needed for %s, 20%% more are recommended to follow the chain growth", fs.requiredGB, strings.Join(fs.names, " and ")),
+		})
+	}
+	return checks
+}
+
+func checkDocker(options preflightOptions) []utils.PreflightCheck {
+	info, err := utils.GetDockerInfo()
+	if err != nil {
+		return []utils.PreflightCheck{{Name: "docker", Status: utils.PreflightFail, Message: "Docker is not reachable: " + err.Error()}}
+	}
+	version := utils.PreflightCheck{Name: "docker", Status: utils.PreflightPass, Value: info.ServerVersion, Message: "Docker 20.10 or newer is recommended"}
+	parts := strings.SplitN(info.ServerVersion, ".", 3)
+	if len(parts) >= 2 {
+		major, _ := strconv.Atoi(parts[0])
+		minor, _ := strconv.Atoi(parts[1])
+		if major < 20 || (major == 20 && minor < 10) {
+			version.Status = utils.PreflightWarn
+		}
+	}
+
+	// Docker Desktop and WSL2 run containers in a VM with its own limits, the cgroup the containers are created in
+	// may limit them further
+	minimumMemory, recommendedMemory := 16.0, 32.0
+	if options.network.Testnet {
+		minimumMemory, recommendedMemory = 8, 16
+	}
+	cpus, memory := float64(info.NCPU), uint64(info.MemTotal)
+	limits := utils.ReadCgroupLimits(utils.CgroupRoot, info.CgroupVersion, utils.DockerCgroupParent(info.CgroupDriver))
+	limited := false
+	if limits.CPUs > 0 && limits.CPUs < cpus {
+		cpus, limited = limits.CPUs, true
+	}
+	if limits.Memory > 0 && limits.Memory < memory {
+		memory, limited = limits.Memory, true
+	}
+	memoryGB := float64(memory) / gigabyte * 1.05
+	status := utils.ThresholdStatus(cpus, 4, 8)
+	if memoryStatus := utils.ThresholdStatus(memoryGB, minimumMemory, recommendedMemory); memoryStatus != utils.PreflightPass && status != utils.PreflightFail {
+		status = memoryStatus
+	}
+	if status == utils.PreflightFail && !limited {
+		// bare limits of the host are already reported by the host checks
+		status = utils.PreflightWarn
+	}
+	value := fmt.Sprintf("%g CPUs, %.1f GB, cgroup v%s", cpus, float64(memory)/gigabyte, info.CgroupVersion)
+	if limited {
+		value += " limited by " + utils.DockerCgroupParent(info.CgroupDriver)
+	}
+	resources := utils.PreflightCheck{
+		Name:    "docker_resources",
+		Status:  status,
+		Value:   value,
+		Message: "CPUs and memory available to the containers",
+	}
+	return []utils.PreflightCheck{version, resources}
+}
+
+func checkPorts(options preflightOptions) utils.PreflightCheck {
+	executionOptions := utils.ExecutionClientOptions{Network: options.network, Erigon: appstate.CurrentState.Erigon}
+	owners := map[string][]uint{
+		"heimdall":                     utils.HeimdallPorts,
+		"heimdall-rest":                {1317},
+		options.client.ContainerName(): options.client.Ports(executionOptions),
+	}
+	busy := []string{}
+	for container, ports := range owners {
+		info, err := utils.InspectContainer(container)
+		if err == nil && info.Running {
+			// used by the node itself
+			continue
+		}
+		for _, port := range ports {
+			if !utils.IsPortFree(port, utils.PublishesUDP(port)) {
+				busy = append(busy, strconv.FormatUint(uint64(port), 10))
+			}
+		}
+	}
+	check := utils.PreflightCheck{Name: "ports", Status: utils.PreflightPass, Message: "The P2P and API ports of the node have to be free"}
+	if len(busy) > 0 {
+		sort.Strings(busy)
+		check.Status = utils.PreflightFail
+		check.Value = "in use: " + strings.Join(busy, ", ")
+	}
+	return check
+}
+
+func checkEthereumRPC(options preflightOptions) utils.PreflightCheck {
+	check := utils.PreflightCheck{Name: "ethereum_rpc", Value: utils.RedactURL(options.ethereumRPC), Message: fmt.Sprintf("The RPC has to serve chain ID %d", options.network.L1ChainID)}
+	if !utils.IsValidURL(options.ethereumRPC) {
+		check.Status = utils.PreflightFail
+		check.Message = "Invalid ethereumRPC"
+		return check
+	}
+	client, err := utils.NewBlockchainClient(options.ethereumRPC)
+	if err != nil {
+		check.Status = utils.PreflightFail
+		check.Message = "Cannot connect to the RPC: " + err.Error()
+		return check
+	}
+	start := time.Now()
+	chainID, err := client.ChainID()
+	if err != nil {
+		check.Status = utils.PreflightFail
+		check.Message = "The RPC does not answer: " + err.Error()
+		return check
+	}
+	check.Value += fmt.Sprintf(", chain ID %s, %d ms", chainID.String(), time.Since(start).Milliseconds())
+	if chainID.Int64() != options.network.L1ChainID {
+		check.Status = utils.PreflightFail
+		return check
+	}
+	check.Status = utils.PreflightPass
+	return check
+}
+
+func checkWalletGas(options preflightOptions) utils.PreflightCheck {
+	check := utils.PreflightCheck{Name: "wallet_gas", Status: utils.PreflightWarn}
+	if options.walletAddress == "" {
+		check.Message = "No wallet, the ETH needed for the staking transactions cannot be checked"
+		return check
+	}
+	check.Value = options.walletAddress
+	client, err := utils.NewBlockchainClient(options.ethereumRPC)
+	if err != nil {
+		check.Message = "Cannot connect to the RPC: " + err.Error()
+		return check
+	}
+	balance, err := client.GetETHBalance(options.walletAddress)
+	if err != nil {
+		check.Message = "Cannot read the wallet balance: " + err.Error()
+		return check
+	}
+	gasPrice, err := client.SuggestGasPrice()
+	if err != nil {
+		check.Message = "Cannot read the gas price: " + err.Error()
+		return check
+	}
+	needed := new(big.Int).Mul(gasPrice, big.NewInt(stakingGasUnits))
+	check.Value = fmt.Sprintf("%s, %s ETH", options.walletAddress, weiToEther(balance))
+	check.Message = fmt.Sprintf("About %s ETH are needed for the staking transactions at the current gas price", weiToEther(needed))
+	if balance.Cmp(needed) >= 0 {
+		check.Status = utils.PreflightPass
+	}
+	return check
+}
+
+// runPreflight runs all the checks, staking is optional so a wallet without gas is only a warning
+func runPreflight(options preflightOptions) utils.PreflightReport {
+	checks := []utils.PreflightCheck{checkCPU(options), checkMemory(options)}
+	checks = append(checks, checkDisks(options)...)
+	checks = append(checks, checkDocker(options)...)
+	checks = append(checks, checkPorts(options), checkEthereumRPC(options), checkWalletGas(options))
+	return utils.NewPreflightReport(checks)
+}
+
+// preflightOptionsFromArgs reads the install arguments, missing ones default to the current state
+func preflightOptionsFromArgs(args map[string]string) (preflightOptions, error) {
+	options := preflightOptions{ethereumRPC: args["ethereumRPC"], walletAddress: appstate.CurrentState.Wallet.Address}
+	if options.ethereumRPC == "" {
+		options.ethereumRPC = appstate.CurrentState.RPC
+	}
+
+	networkName := args["network"]
+	if networkName == "" {
+		networkName = appstate.CurrentState.Network
+		if args["testnet"] == "true" {
+			networkName = appstate.DefaultTestnet
+		} else if args["testnet"] == "false" {
+			networkName = appstate.DefaultNetwork
+		}
+	}
+	network, exists := appstate.Networks[networkName]
+	if !exists {
+		return options, fmt.Errorf("invalid network %s", networkName)
+	}
+	options.network = network
+
+	options.client = utils.CurrentExecutionClient()
+	if args["executionClient"] != "" {
+		client, exists := utils.ExecutionClients[args["executionClient"]]
+		if !exists {
+			return options, fmt.Errorf("invalid executionClient %s, expected one of %s", args["executionClient"], strings.Join(utils.ExecutionClientNames(), ", "))
+		}
+		options.client = client
+	}
+	options.reuseData = appstate.CurrentState.Preserved.ChainData && args["reuseData"] != "false"
+	options.erigonProfile = args["erigonProfile"]
+	if options.erigonProfile == "" {
+		options.erigonProfile = appstate.CurrentState.Erigon.Profile
+	}
+
+	if args["mnemonic"] != "" {
+		_, address, err := utils.DeriveAccountFromMnemonic(args["mnemonic"])
+		if err != nil {
+			return options, fmt.Errorf("invalid mnemonic: %v", err)
+		}
+		options.walletAddress = address
+	}
+	return options, nil
+}
+
+// preflightTask checks the host, docker, the ethereum RPC and the wallet before installing the node, and returns a
+// scored pass/warn/fail report. It takes the optional install arguments: ethereumRPC, network, testnet,
+// executionClient, erigonProfile, mnemonic and reuseData. install refuses to run when the report fails.
+func preflightTask(args map[string]string) string {
+	options, err := preflightOptionsFromArgs(args)
+	if err != nil {
+		utils.WriteError("Error reading arguments:" + err.Error())
+		return RESULT_ERROR
+	}
+	report := runPreflight(options)
+
+	// Serialize the struct to JSON
+	jsonBytes, err := json.Marshal(report)
+	if err != nil {
+		utils.WriteError("Error serializing to JSON:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	return string(jsonBytes)
+}
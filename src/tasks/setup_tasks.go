@@ -8,150 +8,479 @@ import (
 	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 //go:embed conf/heimdall/config.toml
 var configHeimdallToml string
 
-// installTask is an example task for installation purposes
-func installTask(args map[string]string) string {
-	ethereumRPC := args["ethereumRPC"]
-	if !utils.IsValidURL(ethereumRPC) {
-		utils.WriteError("Invalid ethereumRPC")
-		return RESULT_ERROR
+// installContext holds what the install steps need
+type installContext struct {
+	client             utils.ExecutionClient
+	localPathHeimdall  string
+	localPathExecution string
+	reuseChaindata     bool // the chain data kept by an uninstall is not cleared
+}
+
+func newInstallContext() *installContext {
+	client := utils.CurrentExecutionClient()
+	return &installContext{
+		client:             client,
+		localPathHeimdall:  utils.HeimdallDataPath(),
+		localPathExecution: utils.ExecutionDataPath(client),
+		reuseChaindata:     appstate.CurrentState.Preserved.ChainData,
 	}
+}
 
-	isTestnet := args["testnet"] == "true"
-	isAutoStart := args["autostart"] == "true"
-	mnemonic := args["mnemonic"]
+// installStep is a step of install. Steps are run again when an install resumes so they have to be idempotent,
+// compensate undoes a step, even a partially run one, when a failed install is rolled back.
+type installStep struct {
+	name       string
+	state      appstate.AppStateEnum // state of the node while the step runs
+	run        func(ctx *installContext) error
+	compensate func(ctx *installContext) error
+}
 
-	appstate.UpdateChain(isTestnet)
-	appstate.UpdateRPC(ethereumRPC)
-	err := utils.LoadAccountFromMnemonic(mnemonic)
-	if err != nil {
-		utils.WriteError("Error loading account from mnemonic:" + err.Error())
-		return RESULT_ERROR
-	}
+var installSteps = []installStep{
+	{"pull-images", appstate.InstallingNode, pullImagesStep, removePulledImagesStep},
+	{"configure-heimdall", appstate.ConfiguringHeimdall, configureHeimdallStep, removeHeimdallConfigStep},
+	{"configure-execution", appstate.ConfiguringErigon, configureExecutionStep, removeExecutionConfigStep},
+	{"configure-network", appstate.ConfiguringNetwork, configureNetworkStep, removeNetworkStep},
+}
 
-	storage, _ := appstate.GetStoragePath()
-	localPathHeimdall := path.Join(storage, "data", "heimdall")
-	localPathErigon := path.Join(storage, "data", "erigon")
+// installStepIndex returns the index of a step by its name, the first step if there is none
+func installStepIndex(name string) int {
+	for i, step := range installSteps {
+		if step.name == name {
+			return i
+		}
+	}
+	return 0
+}
 
-	if appstate.CurrentState.State <= appstate.InstallingNode {
-		// not installed yet
-		fmt.Println("Installing node")
-		appstate.UpdateState(appstate.InstallingNode)
+// failInstall records the step that failed so a later install resumes from it
+func failInstall(step installStep, err error) {
+	utils.WriteError("Error during install step " + step.name + ":" + err.Error())
+	install := appstate.CurrentState.Install
+	install.FailedStep = step.name
+	install.Error = err.Error()
+	install.FailedAt = time.Now().Unix()
+	appstate.UpdateInstall(install)
+	appstate.UpdateState(appstate.SetupErrorState)
+	appstate.AppendHistory(appstate.HistoryEntry{Time: install.FailedAt, Source: "install", Component: step.name, Action: "failed", Message: err.Error()})
+}
 
-		err := utils.PullImage("0xpolygon/heimdall:1.0.3")
+func pullImagesStep(ctx *installContext) error {
+	for _, image := range []string{"0xpolygon/heimdall:1.0.3", ctx.client.Image()} {
+		info, err := utils.InspectImage(image)
 		if err != nil {
-			utils.WriteError("Error pulling heimdall image:" + err.Error())
-			return RESULT_ERROR
+			return err
+		}
+		if !info.Exists {
+			// only images pulled by install are removed by a rollback
+			install := appstate.CurrentState.Install
+			install.PulledImages = append(install.PulledImages, image)
+			appstate.UpdateInstall(install)
+		}
+		err = utils.EnsureImage(image)
+		if err != nil {
+			return fmt.Errorf("error getting %s image: %v", image, err)
 		}
+	}
+
+	// setting up local config path
+	err := os.MkdirAll(ctx.localPathHeimdall, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("error creating local path: %v", err)
+	}
 
-		err = utils.PullImage("thorax/erigon:v2.53.4")
+	// check heimdall
+	_ = utils.RemoveContainerIfExists("versionchecker")
+	output, err := utils.DockerRun("0xpolygon/heimdall:1.0.3", []string{"heimdallcli", "version"}, "/heimdall-home", ctx.localPathHeimdall, []uint{}, false, "", false, "versionchecker", true)
+	if err != nil {
+		return fmt.Errorf("error running image: %v", err)
+	}
+	version, err := utils.ExtractVersion(output)
+	if err != nil {
+		return fmt.Errorf("error executing heimdallcli: %v", err)
+	}
+	fmt.Print(version)
+	fmt.Println("Successfully installed heimdall")
+	return nil
+}
+
+func removePulledImagesStep(ctx *installContext) error {
+	_ = utils.RemoveContainerIfExists("versionchecker")
+	for _, image := range appstate.CurrentState.Install.PulledImages {
+		err := utils.RemoveImageIfExists(image)
 		if err != nil {
-			utils.WriteError("Error pulling erigon image:" + err.Error())
-			return RESULT_ERROR
+			return fmt.Errorf("error removing %s image: %v", image, err)
 		}
+	}
+	return nil
+}
 
-		// setting up local config path
-		err = os.MkdirAll(localPathHeimdall, os.ModePerm)
+// heimdallIdentityFiles identify the heimdall node and its signer, they are kept when the config is written again
+var heimdallIdentityFiles = []string{"node_key.json", "priv_validator_key.json", "priv_validator_key.json.bak"}
+
+// heimdallIdentityStash holds the identity files in the storage folder while the heimdall config is removed
+const heimdallIdentityStash = "heimdall-identity"
+
+func heimdallIdentityStashPath() (string, error) {
+	storage, err := appstate.GetStoragePath()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(storage, heimdallIdentityStash), nil
+}
+
+// stashHeimdallIdentity copies the identity files out of the heimdall config, a stash left by an interrupted attempt is kept as is
+func stashHeimdallIdentity(configPath string) error {
+	stash, err := heimdallIdentityStashPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(stash); err == nil {
+		return nil
+	}
+	// written aside then renamed so that a partial stash is never taken for the identity
+	pending := stash + ".tmp"
+	_ = os.RemoveAll(pending)
+	if err := os.MkdirAll(pending, fs.FileMode(0700)); err != nil {
+		return err
+	}
+	for _, file := range heimdallIdentityFiles {
+		content, err := os.ReadFile(path.Join(configPath, file))
+		if os.IsNotExist(err) {
+			continue
+		}
 		if err != nil {
-			utils.WriteError("Error creating local path:" + err.Error())
-			return RESULT_ERROR
+			return fmt.Errorf("error reading %s: %v", file, err)
 		}
+		if err := os.WriteFile(path.Join(pending, file), content, fs.FileMode(0600)); err != nil {
+			return fmt.Errorf("error stashing %s: %v", file, err)
+		}
+	}
+	return os.Rename(pending, stash)
+}
 
-		// check heimdall
-		output, err := utils.DockerRun("0xpolygon/heimdall:1.0.3", []string{"heimdallcli", "version"}, "/heimdall-home", localPathHeimdall, []uint{}, false, "", false, "versionchecker", true)
+// restoreHeimdallIdentity copies the stashed identity files back into the heimdall config
+func restoreHeimdallIdentity(configPath string) error {
+	stash, err := heimdallIdentityStashPath()
+	if err != nil {
+		return err
+	}
+	for _, file := range heimdallIdentityFiles {
+		content, err := os.ReadFile(path.Join(stash, file))
+		if os.IsNotExist(err) {
+			continue
+		}
 		if err != nil {
-			utils.WriteError("Error running image:" + err.Error())
-			return RESULT_ERROR
-		} else {
-			version, err := utils.ExtractVersion(output)
-			if err != nil {
-				utils.WriteError("Error executing heimdallcli:" + err.Error())
-				return RESULT_ERROR
-			}
-			fmt.Print(version)
+			return fmt.Errorf("error reading stashed %s: %v", file, err)
+		}
+		if err := os.WriteFile(path.Join(configPath, file), content, fs.FileMode(0600)); err != nil {
+			return fmt.Errorf("error restoring %s: %v", file, err)
 		}
+	}
+	return nil
+}
+
+// dropHeimdallIdentityStash removes the stash once the identity is back in the config
+func dropHeimdallIdentityStash() error {
+	stash, err := heimdallIdentityStashPath()
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(stash)
+}
 
-		fmt.Println("Successfully installed heimdall")
-		appstate.UpdateState(appstate.ConfiguringHeimdall)
+func configureHeimdallStep(ctx *installContext) error {
+	fmt.Println("Configuring heimdall...")
+	// init heimdall
+	var err error
+	configPath := path.Join(ctx.localPathHeimdall, "config")
+	if ctx.reuseChaindata {
+		// only the config is written again, the data folder and the identity of the node are kept
+		if err := stashHeimdallIdentity(configPath); err != nil {
+			return fmt.Errorf("error stashing heimdall identity: %v", err)
+		}
+		err = removeHostPaths(configPath)
+	} else {
+		err = os.RemoveAll(ctx.localPathHeimdall) // clear config if any
+	}
+	if err != nil {
+		return fmt.Errorf("error during heimdall config: %v", err)
+	}
+	err = os.MkdirAll(ctx.localPathHeimdall, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("error during heimdall config: %v", err)
+	}
+	chainArg := "--chain=" + appstate.CurrentNetwork().HeimdallChain
+	fmt.Println("Configuring heimdall for " + appstate.CurrentNetwork().Name)
+	_ = utils.RemoveContainerIfExists("initializer")
+	_, err = utils.DockerRun("0xpolygon/heimdall:1.0.3", []string{"init", "--home=/heimdall-home", chainArg}, "/heimdall-home", ctx.localPathHeimdall, []uint{}, false, "", false, "initializer", true)
+	if err != nil {
+		return fmt.Errorf("error during heimdall init: %v", err)
+	}
+	fmt.Println("Successfully initialized heimdall conf")
+	if ctx.reuseChaindata {
+		if err := restoreHeimdallIdentity(configPath); err != nil {
+			return err
+		}
 	}
 
-	if appstate.CurrentState.State <= appstate.ConfiguringHeimdall {
-		fmt.Println("Configuring heimdall...")
-		// init heimdall
-		err := os.RemoveAll(localPathHeimdall) // clear config if any
+	// configure
+	// write config to file
+	err = os.WriteFile(path.Join(configPath, "config.toml"), []byte(configHeimdallToml), fs.FileMode(0644))
+	if err != nil {
+		return fmt.Errorf("error writing toml file: %v", err)
+	}
+
+	err = utils.ReplaceValuesInFile(path.Join(configPath, "heimdall-config.toml"), map[string]string{"eth_rpc_url": appstate.CurrentState.RPC, "bor_rpc_url": borRPCURL(ctx.client)})
+	if err != nil {
+		return fmt.Errorf("error during heimdall configure: %v", err)
+	}
+	validator := appstate.CurrentState.Validator
+	if validator.Enabled {
+		// the validator kept by uninstall gets its signer key and its sentries back
+		key, err := loadSignerKey()
 		if err != nil {
-			utils.WriteError("Error during heimdall config:" + err.Error())
-			return RESULT_ERROR
+			return fmt.Errorf("error loading signer key: %v", err)
+		}
+		if err := applyValidatorConfig(key, validator.Sentries); err != nil {
+			return fmt.Errorf("error configuring validator: %v", err)
 		}
-		err = os.MkdirAll(localPathHeimdall, os.ModePerm)
+	}
+	if err := dropHeimdallIdentityStash(); err != nil {
+		return fmt.Errorf("error removing heimdall identity stash: %v", err)
+	}
+	fmt.Println("Successfully configured heimdall")
+	return nil
+}
+
+func removeHeimdallConfigStep(ctx *installContext) error {
+	_ = utils.RemoveContainerIfExists("initializer")
+	// remove using docker because of permission issues
+	if ctx.reuseChaindata {
+		// the kept chain data still belongs to this identity, it is stashed for the next install
+		configPath := path.Join(ctx.localPathHeimdall, "config")
+		if err := stashHeimdallIdentity(configPath); err != nil {
+			return fmt.Errorf("error stashing heimdall identity: %v", err)
+		}
+		return removeHostPaths(configPath)
+	}
+	return removeHostPaths(ctx.localPathHeimdall)
+}
+
+func configureExecutionStep(ctx *installContext) error {
+	fmt.Println("Configuring " + ctx.client.Name() + "...")
+	if !ctx.reuseChaindata {
+		err := os.RemoveAll(ctx.localPathExecution) // clear config if any
 		if err != nil {
-			utils.WriteError("Error during heimdall config:" + err.Error())
-			return RESULT_ERROR
+			return fmt.Errorf("error during %s config: %v", ctx.client.Name(), err)
 		}
-		chainArg := "--chain=mainnet"
+	}
+	err := os.MkdirAll(ctx.localPathExecution, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("error during %s config: %v", ctx.client.Name(), err)
+	}
+	fmt.Println("Successfully configured " + ctx.client.Name())
+	return nil
+}
+
+func removeExecutionConfigStep(ctx *installContext) error {
+	if ctx.reuseChaindata {
+		return nil
+	}
+	return removeHostPaths(ctx.localPathExecution)
+}
+
+func configureNetworkStep(ctx *installContext) error {
+	// recreate the network
+	utils.RemoveDockerNetworkIfExists("polygon")
+	// create docker network
+	err := utils.CreateDockerNetwork("polygon")
+	if err != nil {
+		return fmt.Errorf("error creating docker network: %v", err)
+	}
+	return nil
+}
+
+func removeNetworkStep(ctx *installContext) error {
+	return utils.RemoveDockerNetworkIfExists("polygon")
+}
+
+// installTask is an example task for installation purposes. What a previous uninstall kept is reused: the wallet
+// and the configuration when mnemonic and ethereumRPC are empty, and the chain data unless reuseData is false.
+func installTask(args map[string]string) string {
+	preserved := appstate.CurrentState.Preserved
+	ethereumRPC := args["ethereumRPC"]
+	if ethereumRPC == "" && preserved.Wallet {
+		ethereumRPC = appstate.CurrentState.RPC
+	}
+	if !utils.IsValidURL(ethereumRPC) {
+		utils.WriteError("Invalid ethereumRPC")
+		return RESULT_ERROR
+	}
+
+	isTestnet := args["testnet"] == "true"
+	// optional, name of the network in the registry, the testnet flag selects the default testnet otherwise
+	network := args["network"]
+	if network == "" {
+		network = appstate.DefaultNetwork
 		if isTestnet {
-			chainArg = "--chain=mumbai"
-			fmt.Println("Configuring heimdall for mumbai testnet")
-		} else {
-			fmt.Println("Configuring heimdall for mainnet")
+			network = appstate.DefaultTestnet
 		}
-		_, err = utils.DockerRun("0xpolygon/heimdall:1.0.3", []string{"init", "--home=/heimdall-home", chainArg}, "/heimdall-home", localPathHeimdall, []uint{}, false, "", false, "initializer", true)
-		if err != nil {
-			utils.WriteError("Error during heimdall init:" + err.Error())
+	}
+	if _, exists := appstate.Networks[network]; !exists {
+		utils.WriteError("Invalid network " + network)
+		return RESULT_ERROR
+	}
+	// optional, execution client run next to heimdall
+	executionClient := args["executionClient"]
+	if executionClient == "" {
+		executionClient = utils.DefaultExecutionClient
+	}
+	client, exists := utils.ExecutionClients[executionClient]
+	if !exists {
+		utils.WriteError("Invalid executionClient " + executionClient + ", expected one of " + strings.Join(utils.ExecutionClientNames(), ", "))
+		return RESULT_ERROR
+	}
+	// optional, erigon preset, see erigon-config-set for the other settings
+	erigonConfig := appstate.CurrentState.Erigon
+	if args["erigonProfile"] != "" {
+		erigonConfig.Profile = args["erigonProfile"]
+		if err := utils.ValidateErigonConfig(erigonConfig); err != nil {
+			utils.WriteError("Invalid erigonProfile:" + err.Error())
 			return RESULT_ERROR
-		} else {
-			fmt.Println("Successfully initialized heimdall conf")
 		}
+	}
+	isAutoStart := args["autostart"] == "true"
+	mnemonic := args["mnemonic"]
+	keepWallet := mnemonic == "" && preserved.Wallet && appstate.CurrentState.Wallet.Address != ""
+	// optional, snapshot used to bootstrap the erigon chaindata
+	erigonSnapshot := args["erigonSnapshot"]
+	if erigonSnapshot != "" && client.Name() != "erigon" {
+		utils.WriteError("erigonSnapshot can only be used with the erigon execution client")
+		return RESULT_ERROR
+	}
+	if erigonSnapshot != "" && !utils.IsValidURL(erigonSnapshot) {
+		if _, err := os.Stat(path.Join(erigonSnapshot, utils.SnapshotChecksumFile)); err != nil {
+			utils.WriteError("Invalid erigonSnapshot, expected an URL or a directory containing " + utils.SnapshotChecksumFile)
+			return RESULT_ERROR
+		}
+	}
 
-		// configure
-		// write config to file
-		err = os.WriteFile(path.Join(localPathHeimdall, "config", "config.toml"), []byte(configHeimdallToml), fs.FileMode(0644))
-		if err != nil {
-			utils.WriteError("Error writing toml file: " + err.Error())
+	// optional, the chain data kept by uninstall is discarded when false
+	reuseData := args["reuseData"] != "false"
+	if preserved.ChainData && reuseData {
+		if preserved.Network != network || preserved.ExecutionClient != client.Name() || (client.Name() == "erigon" && preserved.ErigonProfile != erigonProfile(erigonConfig)) {
+			utils.WriteError(fmt.Sprintf("The kept chain data belongs to %s on %s", preserved.ExecutionClient, preserved.Network) +
+				", install with the same network, execution client and erigon profile, or with reuseData=false to discard it")
 			return RESULT_ERROR
 		}
+		if erigonSnapshot != "" {
+			utils.WriteError("erigonSnapshot cannot be used when reusing the kept chain data")
+			return RESULT_ERROR
+		}
+	}
 
-		err = utils.ReplaceValuesInFile(path.Join(localPathHeimdall, "config", "heimdall-config.toml"), map[string]string{"eth_rpc_url": ethereumRPC, "bor_rpc_url": "http://erigon:8545"})
+	// optional, offline nodes load the images from the docker save archives of offlineImages and import the heimdall
+	// snapshot from heimdallSnapshot, helperImage, required offline, replaces alpine for the maintenance containers
+	offline := appstate.CurrentState.Offline
+	if args["offlineImages"] != "" {
+		offline.ImagesPath = filepath.Clean(args["offlineImages"])
+	}
+	if args["heimdallSnapshot"] != "" {
+		offline.HeimdallSnapshot = filepath.Clean(args["heimdallSnapshot"])
+	}
+	if args["helperImage"] != "" {
+		offline.HelperImage = args["helperImage"]
+	}
+	err := utils.ValidateOfflineConfig(offline, []string{"0xpolygon/heimdall:1.0.3", client.Image()}, erigonSnapshot, preserved.ChainData && reuseData)
+	if err != nil {
+		utils.WriteError("Invalid offline configuration:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	// optional, installs even if the preflight checks fail
+	skipPreflight := args["skipPreflight"] == "true"
+	if appstate.CurrentState.State < appstate.InstallingNode && appstate.CurrentState.State != appstate.SetupErrorState && !skipPreflight {
+		options, err := preflightOptionsFromArgs(args)
 		if err != nil {
-			utils.WriteError("Error during heimdall configure:" + err.Error())
+			utils.WriteError("Error reading arguments:" + err.Error())
+			return RESULT_ERROR
+		}
+		report := runPreflight(options)
+		if report.Status == utils.PreflightFail {
+			for _, check := range report.Checks {
+				if check.Status == utils.PreflightFail {
+					utils.WriteError("Preflight check " + check.Name + " failed: " + check.Message + " " + check.Value)
+				}
+			}
+			utils.WriteError("Preflight checks failed, see the preflight task or install with skipPreflight=true")
 			return RESULT_ERROR
 		}
-		fmt.Println("Successfully configured heimdall")
-		appstate.UpdateState(appstate.ConfiguringErigon)
+		fmt.Printf("Preflight checks passed with a score of %d\n", report.Score)
 	}
 
-	if appstate.CurrentState.State <= appstate.ConfiguringErigon {
-		fmt.Println("Configuring erigon...")
-		err := os.RemoveAll(localPathErigon) // clear config if any
-		if err != nil {
-			utils.WriteError("Error during erigon config:" + err.Error())
+	if preserved.ChainData && !reuseData {
+		fmt.Println("Discarding the kept chain data...")
+		// the state still describes the node the data belongs to
+		if !removeData(true, true, true) {
 			return RESULT_ERROR
 		}
-		err = os.MkdirAll(localPathErigon, os.ModePerm)
+		preserved.ChainData = false
+		appstate.UpdatePreserved(preserved)
+		appstate.UpdateSnapshotDownloaded(false)
+	}
+
+	appstate.UpdateOffline(offline)
+	appstate.UpdateNetwork(network)
+	appstate.UpdateExecutionClient(executionClient)
+	appstate.UpdateErigonConfig(erigonConfig)
+	if preserved.ChainData {
+		fmt.Println("Reusing the chain data kept by uninstall")
+	} else {
+		appstate.UpdateErigonSnapshotSource(erigonSnapshot)
+		appstate.UpdateErigonSnapshotDownloaded(false)
+	}
+	appstate.UpdateRPC(ethereumRPC)
+	if keepWallet {
+		fmt.Println("Reusing the wallet kept by uninstall")
+	} else {
+		err := utils.LoadAccountFromMnemonic(mnemonic)
 		if err != nil {
-			utils.WriteError("Error during erigon config:" + err.Error())
+			utils.WriteError("Error loading account from mnemonic:" + err.Error())
 			return RESULT_ERROR
 		}
-		fmt.Println("Successfully configured erigon")
-		appstate.UpdateState(appstate.ConfiguringNetwork)
 	}
 
-	if appstate.CurrentState.State <= appstate.ConfiguringNetwork {
-		// recreate the network
-		utils.RemoveDockerNetworkIfExists("polygon")
-		// create docker network
-		err := utils.CreateDockerNetwork("polygon")
+	if appstate.CurrentState.State == appstate.SetupErrorState {
+		// resume from the step that failed
+		step := installStepIndex(appstate.CurrentState.Install.FailedStep)
+		fmt.Println("Resuming install from step " + installSteps[step].name)
+		appstate.UpdateState(installSteps[step].state)
+	}
+	ctx := newInstallContext()
+	for _, step := range installSteps {
+		if appstate.CurrentState.State > step.state {
+			// already done
+			continue
+		}
+		appstate.UpdateState(step.state)
+		err := step.run(ctx)
 		if err != nil {
-			utils.WriteError("Error creating docker network:" + err.Error())
+			failInstall(step, err)
 			return RESULT_ERROR
 		}
-		fmt.Println("Successfully installed node")
-		appstate.UpdateState(appstate.NodeInstalled)
 	}
+	appstate.UpdateInstall(appstate.InstallProgress{})
+	appstate.UpdatePreserved(appstate.PreservedData{})
+	fmt.Println("Successfully installed node")
+	appstate.UpdateState(appstate.NodeInstalled)
 
 	if isAutoStart {
 		return startTask(args)
@@ -160,25 +489,96 @@ func installTask(args map[string]string) string {
 	return RESULT_SUCCESS
 }
 
-// removeData removes chain data from erigon and heimdall, if all is true, it removes all data
-func removeData(erigon bool, heimdall bool, all bool) bool {
-	if !erigon && !heimdall {
+// installRollbackTask undoes the steps of an install that failed or was interrupted, in reverse order,
+// so the node can be installed again from scratch
+func installRollbackTask(args map[string]string) string {
+	reached := -1
+	switch appstate.CurrentState.State {
+	case appstate.NoState, appstate.StartingInstall:
+	case appstate.SetupErrorState:
+		reached = installStepIndex(appstate.CurrentState.Install.FailedStep)
+	default:
+		for i, step := range installSteps {
+			if step.state <= appstate.CurrentState.State {
+				reached = i
+			}
+		}
+	}
+
+	ctx := newInstallContext()
+	for i := reached; i >= 0; i-- {
+		step := installSteps[i]
+		fmt.Println("Rolling back install step " + step.name + "...")
+		err := step.compensate(ctx)
+		if err != nil {
+			utils.WriteError("Error rolling back install step " + step.name + ":" + err.Error())
+			return RESULT_ERROR
+		}
+	}
+	if reached < 0 {
+		// interrupted before any step, images may still have been recorded
+		if err := removePulledImagesStep(ctx); err != nil {
+			utils.WriteError("Error rolling back install:" + err.Error())
+			return RESULT_ERROR
+		}
+	}
+
+	appstate.UpdateInstall(appstate.InstallProgress{})
+	appstate.UpdateState(appstate.NoState)
+	appstate.AppendHistory(appstate.HistoryEntry{Time: time.Now().Unix(), Source: "install", Action: "rolled_back", Message: fmt.Sprintf("%d steps undone", reached+1)})
+	fmt.Println("Successfully rolled back install")
+	return RESULT_SUCCESS
+}
+
+// removeHostPaths removes paths of the host using docker because of permission issues, the data folders may be on
+// other disks so each parent folder is mounted. The last element of a path may be a glob.
+func removeHostPaths(paths ...string) error {
+	parents := []string{}
+	names := make(map[string][]string)
+	for _, hostPath := range paths {
+		parent := filepath.Dir(hostPath)
+		if _, exists := names[parent]; !exists {
+			parents = append(parents, parent)
+		}
+		names[parent] = append(names[parent], "/parent/"+filepath.Base(hostPath))
+	}
+	for _, parent := range parents {
+		if _, err := os.Stat(parent); os.IsNotExist(err) {
+			// nothing to remove, and docker would create the folder
+			continue
+		}
+		err := utils.RemoveHostFolderUsingContainer("/parent", parent, strings.Join(names[parent], " "))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeData removes chain data from the execution client and heimdall, if all is true, it removes all data
+func removeData(execution bool, heimdall bool, all bool) bool {
+	if !execution && !heimdall {
 		return true
 	}
-	storage, _ := appstate.GetStoragePath()
-	// remove data folders using docker because of permission issues
-	folders := ""
+	client := utils.CurrentExecutionClient()
+	paths := []string{}
 	if all {
-		folders += "/plugin/data/heimdall /plugin/data/erigon"
+		paths = append(paths, utils.HeimdallDataPath(), utils.ExecutionDataPath(client))
+		// the old copy of a migration not completed yet
+		if appstate.CurrentState.Migration != nil {
+			paths = append(paths, appstate.CurrentState.Migration.OldPath)
+		}
 	} else {
-		if erigon {
-			folders += "/plugin/data/erigon/bor /plugin/data/erigon/chaindata "
+		if execution {
+			for _, folder := range client.ChaindataFolders() {
+				paths = append(paths, path.Join(utils.ExecutionDataPath(client), folder))
+			}
 		}
 		if heimdall {
-			folders += "/plugin/data/heimdall/data/*.db"
+			paths = append(paths, path.Join(utils.HeimdallDataPath(), "data", "*.db"))
 		}
 	}
-	err := utils.RemoveHostFolderUsingContainer("/plugin", storage, folders)
+	err := removeHostPaths(paths...)
 	if err != nil {
 		utils.WriteError("Error removing data:" + err.Error())
 		return false
@@ -186,9 +586,75 @@ func removeData(erigon bool, heimdall bool, all bool) bool {
 	return true
 }
 
-// uninstallTask is an example task for uninstallation purposes
+// uninstall modes, the keep modes can be combined
+const (
+	uninstallPurge         = "purge"
+	uninstallKeepChaindata = "keep-chaindata"
+	uninstallKeepWallet    = "keep-wallet"
+)
+
+// preservedState returns the state an uninstall leaves for the next install, only what the uninstall keeps is copied
+func preservedState(keepChaindata bool, keepWallet bool) appstate.AppState {
+	current := appstate.CurrentState
+	state := appstate.NewState()
+	state.History = current.History
+	state.Preserved = appstate.PreservedData{ChainData: keepChaindata, Wallet: keepWallet, At: time.Now().Unix()}
+	if keepWallet {
+		state.Network = current.Network
+		state.ExecutionClient = current.ExecutionClient
+		state.Wallet = current.Wallet
+		state.Validator = current.Validator
+		state.RPC = current.RPC
+		state.BorRPCURL = current.BorRPCURL
+		state.Erigon = current.Erigon
+		state.HeimdallPruning = current.HeimdallPruning
+		state.HeimdallReferenceEndpoints = current.HeimdallReferenceEndpoints
+		state.Alerts.Webhooks = current.Alerts.Webhooks
+		state.Alerts.Rules = current.Alerts.Rules
+		state.Watchdog.Config = current.Watchdog.Config
+		state.Exposure = current.Exposure
+		state.Exposure.PortsMappedUntil = 0
+		state.Offline = current.Offline
+	}
+	if keepChaindata {
+		state.Network = current.Network
+		state.ExecutionClient = current.ExecutionClient
+		state.Erigon.Profile = current.Erigon.Profile
+		state.HeimdallSnapshotDownloaded = current.HeimdallSnapshotDownloaded
+		state.ErigonSnapshotSource = current.ErigonSnapshotSource
+		state.ErigonSnapshotDownloaded = current.ErigonSnapshotDownloaded
+		state.DataPaths = current.DataPaths
+		state.Preserved.Network = current.Network
+		state.Preserved.ExecutionClient = utils.CurrentExecutionClient().Name()
+		state.Preserved.ErigonProfile = erigonProfile(current.Erigon)
+	}
+	return state
+}
+
+// uninstallTask removes the node. The optional mode argument selects what a later install can reuse: purge, the
+// default, removes everything, keep-chaindata keeps the synced heimdall and execution client data and keep-wallet
+// keeps the wallet and the configuration. Both keep modes can be combined, such as keep-chaindata,keep-wallet.
 func uninstallTask(args map[string]string) string {
-	if !removeData(true, true, true) {
+	keepChaindata, keepWallet := false, false
+	if args["mode"] != "" {
+		for _, mode := range strings.Split(args["mode"], ",") {
+			switch strings.TrimSpace(mode) {
+			case uninstallPurge:
+			case uninstallKeepChaindata:
+				keepChaindata = true
+			case uninstallKeepWallet:
+				keepWallet = true
+			default:
+				utils.WriteError("Invalid mode " + mode + ", expected " + uninstallPurge + ", " + uninstallKeepChaindata + " or " + uninstallKeepWallet)
+				return RESULT_ERROR
+			}
+		}
+	}
+
+	removePortMappings(appstate.CurrentState.Exposure.NATMode)
+	if keepChaindata {
+		fmt.Println("Keeping chain data")
+	} else if !removeData(true, true, true) {
 		return RESULT_ERROR
 	}
 
@@ -200,7 +666,7 @@ func uninstallTask(args map[string]string) string {
 		return RESULT_ERROR
 	}
 
-	err = utils.RemoveImageIfExists("thorax/erigon:v2.53.4")
+	err = utils.RemoveImageIfExists(utils.CurrentExecutionClient().Image())
 	if err != nil {
 		utils.WriteError("Error removing image:" + err.Error())
 		return RESULT_ERROR
@@ -216,12 +682,38 @@ func uninstallTask(args map[string]string) string {
 
 	fmt.Println("Removing plugin data")
 	storage, _ := appstate.GetStoragePath()
-	// remove rest of plugin data
-	err = os.RemoveAll(storage)
+	if !keepChaindata && !keepWallet {
+		// remove rest of plugin data
+		err = os.RemoveAll(storage)
+		if err != nil {
+			utils.WriteError("Error removing data folder:" + err.Error())
+			return RESULT_ERROR
+		}
+		fmt.Println("Successfully removed plugin data")
+		return RESULT_SUCCESS
+	}
+
+	entries, err := os.ReadDir(storage)
+	if err != nil {
+		utils.WriteError("Error reading data folder:" + err.Error())
+		return RESULT_ERROR
+	}
+	for _, entry := range entries {
+		// the secret key decrypts the wallet and the signer key kept in the state
+		if entry.Name() == "state.json" || strings.HasSuffix(entry.Name(), ".lock") || (keepWallet && entry.Name() == appstate.SecretKeyFile) || (keepChaindata && (entry.Name() == "data" || entry.Name() == heimdallIdentityStash)) {
+			continue
+		}
+		err = os.RemoveAll(path.Join(storage, entry.Name()))
+		if err != nil {
+			utils.WriteError("Error removing data folder:" + err.Error())
+			return RESULT_ERROR
+		}
+	}
+	err = appstate.ResetState(preservedState(keepChaindata, keepWallet))
 	if err != nil {
-		utils.WriteError("Error removing data folder:" + err.Error())
+		utils.WriteError("Error saving state:" + err.Error())
 		return RESULT_ERROR
 	}
-	fmt.Println("Successfully removed plugin data")
+	fmt.Println("Successfully removed plugin data, the next install reuses what was kept")
 	return RESULT_SUCCESS
 }
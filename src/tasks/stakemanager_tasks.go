@@ -0,0 +1,313 @@
+package tasks
+
+import (
+	"KeepixPlugin/appstate"
+	"KeepixPlugin/utils"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const stakeManagerABI = `[
+	{"constant": true, "inputs": [], "name": "minDeposit", "outputs": [{"name": "", "type": "uint256"}], "payable": false, "stateMutability": "view", "type": "function"},
+	{"constant": true, "inputs": [], "name": "minHeimdallFee", "outputs": [{"name": "", "type": "uint256"}], "payable": false, "stateMutability": "view", "type": "function"},
+	{"constant": true, "inputs": [], "name": "validatorThreshold", "outputs": [{"name": "", "type": "uint256"}], "payable": false, "stateMutability": "view", "type": "function"},
+	{"constant": true, "inputs": [], "name": "currentValidatorSetSize", "outputs": [{"name": "", "type": "uint256"}], "payable": false, "stateMutability": "view", "type": "function"},
+	{"constant": true, "inputs": [], "name": "currentEpoch", "outputs": [{"name": "", "type": "uint256"}], "payable": false, "stateMutability": "view", "type": "function"},
+	{"constant": true, "inputs": [], "name": "withdrawalDelay", "outputs": [{"name": "", "type": "uint256"}], "payable": false, "stateMutability": "view", "type": "function"},
+	{"constant": true, "inputs": [], "name": "NFTContract", "outputs": [{"name": "", "type": "address"}], "payable": false, "stateMutability": "view", "type": "function"},
+	{"constant": true, "inputs": [{"name": "user", "type": "address"}], "name": "getValidatorId", "outputs": [{"name": "", "type": "uint256"}], "payable": false, "stateMutability": "view", "type": "function"},
+	{"constant": false, "inputs": [{"name": "user", "type": "address"}, {"name": "amount", "type": "uint256"}, {"name": "heimdallFee", "type": "uint256"}, {"name": "acceptDelegation", "type": "bool"}, {"name": "signerPubkey", "type": "bytes"}], "name": "stakeFor", "outputs": [], "payable": false, "stateMutability": "nonpayable", "type": "function"},
+	{"constant": false, "inputs": [{"name": "user", "type": "address"}, {"name": "heimdallFee", "type": "uint256"}], "name": "topUpForFee", "outputs": [], "payable": false, "stateMutability": "nonpayable", "type": "function"},
+	{"constant": false, "inputs": [{"name": "validatorId", "type": "uint256"}, {"name": "newCommissionRate", "type": "uint256"}], "name": "updateCommissionRate", "outputs": [], "payable": false, "stateMutability": "nonpayable", "type": "function"}
+]`
+
+// stakingNFTABI is the ERC721 of the StakeManager, a validator is owned through its token
+const stakingNFTABI = `[
+	{"constant": true, "inputs": [{"name": "owner", "type": "address"}], "name": "balanceOf", "outputs": [{"name": "", "type": "uint256"}], "payable": false, "stateMutability": "view", "type": "function"}
+]`
+
+type StakeManagerParams struct {
+	StakeManager            string `json:"stakeManager"`
+	MinDeposit              string `json:"minDeposit"`
+	MinHeimdallFee          string `json:"minHeimdallFee"`
+	ValidatorThreshold      string `json:"validatorThreshold"`
+	CurrentValidatorSetSize string `json:"currentValidatorSetSize"`
+	CurrentEpoch            string `json:"currentEpoch"`
+	WithdrawalDelay         string `json:"withdrawalDelay"`
+}
+
+// readStakeManagerUint calls a read only StakeManager function returning a single uint256
+func readStakeManagerUint(client *utils.BlockchainClient, functionName string, params ...interface{}) (*big.Int, error) {
+	result, err := client.CallReadOnlyFunction(appstate.CurrentNetwork().StakeManagerAddress, stakeManagerABI, functionName, params...)
+	if err != nil {
+		return nil, fmt.Errorf("error calling %s: %v", functionName, err)
+	}
+	value, ok := result[0].(*big.Int)
+	if !ok {
+		return nil, fmt.Errorf("error converting %s result", functionName)
+	}
+	return value, nil
+}
+
+// ownsValidator returns whether the address holds a validator token, getValidatorId reverts for the other addresses
+func ownsValidator(client *utils.BlockchainClient, owner common.Address) (bool, error) {
+	result, err := client.CallReadOnlyFunction(appstate.CurrentNetwork().StakeManagerAddress, stakeManagerABI, "NFTContract")
+	if err != nil {
+		return false, fmt.Errorf("error calling NFTContract: %v", err)
+	}
+	nftContract, ok := result[0].(common.Address)
+	if !ok {
+		return false, fmt.Errorf("error converting NFTContract result")
+	}
+	result, err = client.CallReadOnlyFunction(nftContract.Hex(), stakingNFTABI, "balanceOf", owner)
+	if err != nil {
+		return false, fmt.Errorf("error calling balanceOf: %v", err)
+	}
+	balance, ok := result[0].(*big.Int)
+	if !ok {
+		return false, fmt.Errorf("error converting balanceOf result")
+	}
+	return balance.Sign() > 0, nil
+}
+
+// fetchStakeManagerParams reads the StakeManager parameters relevant to validators
+func fetchStakeManagerParams(client *utils.BlockchainClient) (map[string]*big.Int, error) {
+	params := make(map[string]*big.Int)
+	for _, functionName := range []string{"minDeposit", "minHeimdallFee", "validatorThreshold", "currentValidatorSetSize", "currentEpoch", "withdrawalDelay"} {
+		value, err := readStakeManagerUint(client, functionName)
+		if err != nil {
+			return nil, err
+		}
+		params[functionName] = value
+	}
+	return params, nil
+}
+
+// sendAndWait executes a write function with the wallet of the plugin and waits for its receipt
+func sendAndWait(client *utils.BlockchainClient, contractAddress string, abiJSON string, functionName string, gasLimit uint64, params ...interface{}) (*types.Receipt, error) {
+	privateKey, err := utils.ConvertBase64ToPrivateKey(appstate.CurrentState.Wallet.PK)
+	if err != nil {
+		return nil, fmt.Errorf("error converting private key: %v", err)
+	}
+	hash, err := client.ExecuteWriteFunction(privateKey, contractAddress, abiJSON, functionName, gasLimit, params...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing %s: %v", functionName, err)
+	}
+	receipt, err := client.WaitForTransactionReceipt(hash)
+	if err != nil {
+		return nil, fmt.Errorf("error waiting for receipt: %v", err)
+	}
+	if receipt.Status == 0 {
+		return nil, fmt.Errorf("%s transaction failed: %s", functionName, receipt.TxHash.String())
+	}
+	return receipt, nil
+}
+
+// approveStakeManager allows the StakeManager to transfer an amount of MATIC from the wallet
+func approveStakeManager(client *utils.BlockchainClient, amount *big.Int) error {
+	network := appstate.CurrentNetwork()
+	_, err := sendAndWait(client, network.MaticAddress, tokenABI, "approve", 80000, common.HexToAddress(network.StakeManagerAddress), amount)
+	return err
+}
+
+// parseWei parses a base 10 wei amount
+func parseWei(amount string) (*big.Int, bool) {
+	value, success := new(big.Int).SetString(amount, 10)
+	return value, success && value.Sign() >= 0
+}
+
+// stakeManagerParamsTask returns the StakeManager parameters needed to register a validator
+func stakeManagerParamsTask(args map[string]string) string {
+	client, err := utils.NewBlockchainClient(appstate.CurrentState.RPC)
+	if err != nil {
+		utils.WriteError("Error creating blockchain client:" + err.Error())
+		return RESULT_ERROR
+	}
+	params, err := fetchStakeManagerParams(client)
+	if err != nil {
+		utils.WriteError("Error reading StakeManager:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	response := StakeManagerParams{
+		StakeManager:            appstate.CurrentNetwork().StakeManagerAddress,
+		MinDeposit:              weiToEther(params["minDeposit"]),
+		MinHeimdallFee:          weiToEther(params["minHeimdallFee"]),
+		ValidatorThreshold:      params["validatorThreshold"].String(),
+		CurrentValidatorSetSize: params["currentValidatorSetSize"].String(),
+		CurrentEpoch:            params["currentEpoch"].String(),
+		WithdrawalDelay:         params["withdrawalDelay"].String(),
+	}
+
+	// Serialize the struct to JSON
+	jsonBytes, err := json.Marshal(response)
+	if err != nil {
+		utils.WriteError("Error serializing to JSON:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	return string(jsonBytes)
+}
+
+// validatorStakeTask registers the wallet as a validator on L1, using the signer key of the validator mode
+func validatorStakeTask(args map[string]string) string {
+	amount, success := parseWei(args["amount"])
+	if !success {
+		utils.WriteError("Error converting amount to big.Int")
+		return RESULT_ERROR
+	}
+	heimdallFee, success := parseWei(args["heimdallFee"])
+	if !success {
+		utils.WriteError("Error converting heimdallFee to big.Int")
+		return RESULT_ERROR
+	}
+	acceptDelegation := args["acceptDelegation"] == "true"
+
+	key, err := loadSignerKey()
+	if err != nil {
+		utils.WriteError("Error loading signer key, enable the validator mode first:" + err.Error())
+		return RESULT_ERROR
+	}
+	signerPubkey, err := key.PublicKeyBytes()
+	if err != nil {
+		utils.WriteError("Error reading signer public key:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	client, err := utils.NewBlockchainClient(appstate.CurrentState.RPC)
+	if err != nil {
+		utils.WriteError("Error creating blockchain client:" + err.Error())
+		return RESULT_ERROR
+	}
+	params, err := fetchStakeManagerParams(client)
+	if err != nil {
+		utils.WriteError("Error reading StakeManager:" + err.Error())
+		return RESULT_ERROR
+	}
+	if amount.Cmp(params["minDeposit"]) < 0 {
+		utils.WriteError("Amount is below the minimum deposit of " + weiToEther(params["minDeposit"]))
+		return RESULT_ERROR
+	}
+	if heimdallFee.Cmp(params["minHeimdallFee"]) < 0 {
+		utils.WriteError("Heimdall fee is below the minimum fee of " + weiToEther(params["minHeimdallFee"]))
+		return RESULT_ERROR
+	}
+	if params["currentValidatorSetSize"].Cmp(params["validatorThreshold"]) >= 0 {
+		utils.WriteError("The validator set is full")
+		return RESULT_ERROR
+	}
+
+	fmt.Println("Approving MATIC for the StakeManager...")
+	err = approveStakeManager(client, new(big.Int).Add(amount, heimdallFee))
+	if err != nil {
+		utils.WriteError("Error approving MATIC:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	fmt.Println("Staking for signer " + key.SignerAddress() + "...")
+	receipt, err := sendAndWait(client, appstate.CurrentNetwork().StakeManagerAddress, stakeManagerABI, "stakeFor", 600000, common.HexToAddress(appstate.CurrentState.Wallet.Address), amount, heimdallFee, acceptDelegation, signerPubkey)
+	if err != nil {
+		utils.WriteError("Error staking:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	// Serialize the struct to JSON
+	jsonBytes, err := json.Marshal(receipt)
+	if err != nil {
+		utils.WriteError("Error serializing to JSON:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	return string(jsonBytes)
+}
+
+// validatorTopUpTask tops up the heimdall fee of the validator
+func validatorTopUpTask(args map[string]string) string {
+	heimdallFee, success := parseWei(args["heimdallFee"])
+	if !success || heimdallFee.Sign() == 0 {
+		utils.WriteError("Error converting heimdallFee to big.Int")
+		return RESULT_ERROR
+	}
+
+	client, err := utils.NewBlockchainClient(appstate.CurrentState.RPC)
+	if err != nil {
+		utils.WriteError("Error creating blockchain client:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	fmt.Println("Approving MATIC for the StakeManager...")
+	err = approveStakeManager(client, heimdallFee)
+	if err != nil {
+		utils.WriteError("Error approving MATIC:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	receipt, err := sendAndWait(client, appstate.CurrentNetwork().StakeManagerAddress, stakeManagerABI, "topUpForFee", 200000, common.HexToAddress(appstate.CurrentState.Wallet.Address), heimdallFee)
+	if err != nil {
+		utils.WriteError("Error topping up heimdall fee:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	// Serialize the struct to JSON
+	jsonBytes, err := json.Marshal(receipt)
+	if err != nil {
+		utils.WriteError("Error serializing to JSON:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	return string(jsonBytes)
+}
+
+// validatorCommissionTask updates the commission rate of the validator owned by the wallet
+func validatorCommissionTask(args map[string]string) string {
+	commission, success := parseWei(args["commission"])
+	if !success || commission.Cmp(big.NewInt(100)) > 0 {
+		utils.WriteError("Commission must be a percentage between 0 and 100")
+		return RESULT_ERROR
+	}
+
+	client, err := utils.NewBlockchainClient(appstate.CurrentState.RPC)
+	if err != nil {
+		utils.WriteError("Error creating blockchain client:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	owner := common.HexToAddress(appstate.CurrentState.Wallet.Address)
+	owns, err := ownsValidator(client, owner)
+	if err != nil {
+		utils.WriteError("Error checking validator ownership:" + err.Error())
+		return RESULT_ERROR
+	}
+	if !owns {
+		utils.WriteError("The wallet does not own a validator")
+		return RESULT_ERROR
+	}
+	validatorID, err := readStakeManagerUint(client, "getValidatorId", owner)
+	if err != nil {
+		utils.WriteError("Error getting validator id:" + err.Error())
+		return RESULT_ERROR
+	}
+	if validatorID.Sign() == 0 {
+		utils.WriteError("The wallet does not own a validator")
+		return RESULT_ERROR
+	}
+
+	receipt, err := sendAndWait(client, appstate.CurrentNetwork().StakeManagerAddress, stakeManagerABI, "updateCommissionRate", 150000, validatorID, commission)
+	if err != nil {
+		utils.WriteError("Error updating commission:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	// Serialize the struct to JSON
+	jsonBytes, err := json.Marshal(receipt)
+	if err != nil {
+		utils.WriteError("Error serializing to JSON:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	return string(jsonBytes)
+}
@@ -4,6 +4,7 @@ import (
 	"KeepixPlugin/appstate"
 	"KeepixPlugin/utils"
 	"encoding/json"
+	"fmt"
 	"io"
 	"math/big"
 	"net/http"
@@ -187,14 +188,12 @@ func weiToEther(wei *big.Int) string {
 	return ether.Text('f', 18)
 }
 
-// fetchValidators fetches validators data from the provided URL and unmarshals into ValidatorsResponse struct.
-func fetchValidators() (*ValidatorsResponse, error) {
-	var url string
-	if appstate.CurrentState.IsTestnet {
-		url = "https://staking-api-testnet.polygon.technology/api/v2/validators?limit=10&offset=0&sortBy=delegatedStake"
-	} else {
-		url = "https://staking-api.polygon.technology/api/v2/validators?limit=10&offset=0&sortBy=delegatedStake"
-	}
+// validatorsPageSize is the number of validators read per request when paging through all of them
+const validatorsPageSize = 100
+
+// fetchValidatorsPage fetches a page of validators sorted by delegated stake from the staking API.
+func fetchValidatorsPage(limit int, offset int) (*ValidatorsResponse, error) {
+	url := fmt.Sprintf("%s/api/v2/validators?limit=%d&offset=%d&sortBy=delegatedStake", appstate.CurrentNetwork().StakingAPIURL, limit, offset)
 	resp, err := http.Get(url)
 	if err != nil {
 		return nil, err
@@ -211,56 +210,112 @@ func fetchValidators() (*ValidatorsResponse, error) {
 	if err != nil {
 		return nil, err
 	}
+	return &validatorsResponse, nil
+}
 
+// fetchValidators fetches validators data from the provided URL and unmarshals into ValidatorsResponse struct.
+func fetchValidators() (*ValidatorsResponse, error) {
+	validatorsResponse, err := fetchValidatorsPage(10, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := addUserStakes(validatorsResponse.Result); err != nil {
+		return nil, err
+	}
+	return validatorsResponse, nil
+}
+
+// fetchAllValidators pages through all the validators with the stake and rewards of the wallet. Unlike fetchValidators
+// it is not limited to the largest validators, validators without a contract cannot be delegated to and are left out.
+func fetchAllValidators() ([]Validator, error) {
+	validators := []Validator{}
+	for offset := 0; ; offset += validatorsPageSize {
+		page, err := fetchValidatorsPage(validatorsPageSize, offset)
+		if err != nil {
+			return nil, err
+		}
+		for _, validator := range page.Result {
+			if common.IsHexAddress(validator.ContractAddress) && common.HexToAddress(validator.ContractAddress) != (common.Address{}) {
+				validators = append(validators, validator)
+			}
+		}
+		if len(page.Result) < validatorsPageSize || offset+len(page.Result) >= page.Summary.Total {
+			break
+		}
+	}
+	if err := addUserStakes(validators); err != nil {
+		return nil, err
+	}
+	return validators, nil
+}
+
+// fetchDelegations returns the validators the wallet has a stake or rewards on
+func fetchDelegations() ([]Validator, error) {
+	validators, err := fetchAllValidators()
+	if err != nil {
+		return nil, err
+	}
+	none := weiToEther(big.NewInt(0))
+	delegations := []Validator{}
+	for _, validator := range validators {
+		if validator.UserStake != none || validator.UserReward != none {
+			delegations = append(delegations, validator)
+		}
+	}
+	return delegations, nil
+}
+
+// addUserStakes adds the min stake of the validators and the stake and rewards of the wallet
+func addUserStakes(validators []Validator) error {
 	// add information about min stake and user stake
 	client, err := utils.NewBlockchainClient(appstate.CurrentState.RPC)
 	if err != nil {
 		utils.WriteError("Error creating blockchain client:" + err.Error())
-		return nil, err
+		return err
 	}
 
 	addr := common.HexToAddress(appstate.CurrentState.Wallet.Address)
 
-	for index, validator := range validatorsResponse.Result {
+	for index, validator := range validators {
 		minAmountResult, err := client.CallReadOnlyFunction(validator.ContractAddress, validatorABI, "minAmount")
 		if err != nil {
 			utils.WriteError("Error calling minAmount:" + err.Error())
-			return nil, err
+			return err
 		}
 		userStakeResult, err := client.CallReadOnlyFunction(validator.ContractAddress, validatorABI, "getTotalStake", addr)
 		if err != nil {
 			utils.WriteError("Error calling getTotalStake:" + err.Error())
-			return nil, err
+			return err
 		}
 		userRewardResult, err := client.CallReadOnlyFunction(validator.ContractAddress, validatorABI, "getLiquidRewards", addr)
 		if err != nil {
 			utils.WriteError("Error calling getLiquidRewards:" + err.Error())
-			return nil, err
+			return err
 		}
 		minAmount, ok := minAmountResult[0].(*big.Int)
 		if !ok {
 			utils.WriteError("Error converting result to bytes")
-			return nil, err
+			return err
 		}
 
 		userStake, ok := userStakeResult[0].(*big.Int)
 		if !ok {
 			utils.WriteError("Error converting result to bytes")
-			return nil, err
+			return err
 		}
 
 		userReward, ok := userRewardResult[0].(*big.Int)
 		if !ok {
 			utils.WriteError("Error converting result to bytes")
-			return nil, err
+			return err
 		}
 
-		validatorsResponse.Result[index].MinStake = weiToEther(minAmount)
-		validatorsResponse.Result[index].UserStake = weiToEther(userStake)
-		validatorsResponse.Result[index].UserReward = weiToEther(userReward)
+		validators[index].MinStake = weiToEther(minAmount)
+		validators[index].UserStake = weiToEther(userStake)
+		validators[index].UserReward = weiToEther(userReward)
 	}
 
-	return &validatorsResponse, nil
+	return nil
 }
 
 // Struct to match the innermost objects in the "result" array.
@@ -378,10 +433,7 @@ func stakeTask(args map[string]string) string {
 	zero := big.NewInt(0)
 
 	// execute approval function
-	maticAddress := MATIC_ADDR
-	if appstate.CurrentState.IsTestnet {
-		maticAddress = TESTNET_MATIC_ADDR
-	}
+	maticAddress := appstate.CurrentNetwork().MaticAddress
 
 	privateKey, err := utils.ConvertBase64ToPrivateKey(appstate.CurrentState.Wallet.PK)
 	if err != nil {
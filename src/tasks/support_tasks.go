@@ -0,0 +1,226 @@
+package tasks
+
+import (
+	"KeepixPlugin/appstate"
+	"KeepixPlugin/utils"
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// bundleFile is a file added to a support bundle
+type bundleFile struct {
+	name    string
+	content []byte
+}
+
+// knownSecrets returns the secret values of the state, they are replaced wherever they appear in the bundle
+func knownSecrets() []string {
+	state := appstate.CurrentState
+	secrets := []string{state.RPC, state.BorRPCURL, state.Wallet.PK, state.Validator.SignerKey}
+	secrets = append(secrets, state.HeimdallReferenceEndpoints...)
+	for _, webhook := range state.Alerts.Webhooks {
+		secrets = append(secrets, webhook.URL)
+	}
+	return secrets
+}
+
+// redact scrubs the known secret values and the secrets matching the patterns of a bundled text
+func redact(text string) string {
+	return utils.RedactSecrets(utils.RedactValues(text, knownSecrets()))
+}
+
+// redactedState returns the state without its secrets
+func redactedState() ([]byte, error) {
+	state := appstate.CurrentState
+	if state.Wallet.PK != "" {
+		state.Wallet.PK = utils.Redacted
+	}
+	if state.Validator.SignerKey != "" {
+		state.Validator.SignerKey = utils.Redacted
+	}
+	state.RPC = utils.RedactURL(state.RPC)
+	state.BorRPCURL = utils.RedactURL(state.BorRPCURL)
+	endpoints := make([]string, len(state.HeimdallReferenceEndpoints))
+	for i, endpoint := range state.HeimdallReferenceEndpoints {
+		endpoints[i] = utils.RedactURL(endpoint)
+	}
+	state.HeimdallReferenceEndpoints = endpoints
+	webhooks := make([]appstate.AlertWebhook, len(state.Alerts.Webhooks))
+	for i, webhook := range state.Alerts.Webhooks {
+		webhooks[i] = appstate.AlertWebhook{URL: utils.RedactURL(webhook.URL), Format: webhook.Format}
+	}
+	state.Alerts.Webhooks = webhooks
+
+	stateJSON, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return []byte(redact(string(stateJSON))), nil
+}
+
+// taskOutput runs a task for the bundle, its failure is recorded instead of failing the bundle
+func taskOutput(task func(map[string]string) string) []byte {
+	result := task(map[string]string{})
+	if result == RESULT_ERROR {
+		return []byte("task failed, see the stdErr of the support-bundle task\n")
+	}
+	return []byte(redact(result))
+}
+
+// jsonOutput serializes a value read for the bundle, a read error is recorded instead of failing the bundle
+func jsonOutput(value interface{}, err error) []byte {
+	if err != nil {
+		return []byte("Error:" + err.Error() + "\n")
+	}
+	jsonBytes, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return []byte("Error serializing to JSON:" + err.Error() + "\n")
+	}
+	return []byte(redact(string(jsonBytes)))
+}
+
+// writeTarGz writes the files to a gzipped tar archive
+func writeTarGz(filePath string, files []bundleFile) error {
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fs.FileMode(0600))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+	now := time.Now()
+	for _, bundled := range files {
+		header := &tar.Header{Name: bundled.name, Mode: 0644, Size: int64(len(bundled.content)), ModTime: now}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tarWriter.Write(bundled.content); err != nil {
+			return err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+type SupportBundleResponse struct {
+	Path  string   `json:"path"`
+	Size  int64    `json:"size"`
+	Files []string `json:"files"`
+}
+
+// supportBundleTask writes a tar.gz of the logs, the redacted state and configs and the node status to the storage
+// directory, so users can share everything needed to troubleshoot their node at once. Secrets such as the wallet key,
+// RPC credentials and node keys are scrubbed. The optional lines argument defaults to the last 5000 lines of logs.
+func supportBundleTask(args map[string]string) string {
+	linesAmount := 5000
+	if args["lines"] != "" {
+		var err error
+		linesAmount, err = strconv.Atoi(args["lines"])
+		if err != nil || linesAmount <= 0 {
+			utils.WriteError("Invalid lines amount")
+			return RESULT_ERROR
+		}
+	}
+
+	files := []bundleFile{}
+	version := fmt.Sprintf("plugin: %s\nos: %s\narch: %s\ngo: %s\nnetwork: %s\nexecutionClient: %s\nstate: %s\n",
+		Version, runtime.GOOS, runtime.GOARCH, runtime.Version(), appstate.CurrentState.Network, utils.CurrentExecutionClient().Name(), appstate.CurrentStateString())
+	files = append(files, bundleFile{"version.txt", []byte(version)})
+
+	state, err := redactedState()
+	if err != nil {
+		utils.WriteError("Error serializing state:" + err.Error())
+		return RESULT_ERROR
+	}
+	files = append(files, bundleFile{"state.json", state})
+
+	// node_key.json and priv_validator_key.json are left out, only the settings are shared
+	for _, config := range []string{"config.toml", "heimdall-config.toml", "app.toml"} {
+		content, err := os.ReadFile(heimdallConfigPath(config))
+		if err != nil {
+			continue
+		}
+		files = append(files, bundleFile{"heimdall/" + config, []byte(redact(string(content)))})
+	}
+
+	client := utils.CurrentExecutionClient()
+	containers := []string{"heimdall", "heimdall-rest", client.ContainerName(), "rabbitmq", "heimdall-snapshot-downloader", "erigon-snapshot-downloader"}
+	images := []string{}
+	for _, container := range containers {
+		info, err := utils.InspectContainer(container)
+		if err != nil || !info.Exists {
+			continue
+		}
+		images = append(images, info.Image)
+		logs, err := utils.FetchContainerLogs(container, linesAmount)
+		if err != nil {
+			logs = "Error getting logs:" + err.Error()
+		}
+		files = append(files, bundleFile{"logs/" + container + ".log", []byte(redact(logs))})
+	}
+	if len(images) == 0 {
+		images = []string{"0xpolygon/heimdall:1.0.3", client.Image()}
+	}
+	imageInfos := []*utils.ImageInfo{}
+	for _, image := range images {
+		info, err := utils.InspectImage(image)
+		if err != nil {
+			info = &utils.ImageInfo{Image: image}
+		}
+		imageInfos = append(imageInfos, info)
+	}
+	imagesJSON, _ := json.MarshalIndent(imageInfos, "", "  ")
+	files = append(files, bundleFile{"docker/images.json", imagesJSON})
+
+	dockerInfo, err := utils.GetDockerInfo()
+	if err != nil {
+		files = append(files, bundleFile{"docker/info.json", []byte("Error getting docker info:" + err.Error())})
+	} else {
+		dockerInfoJSON, _ := json.MarshalIndent(dockerInfo, "", "  ")
+		files = append(files, bundleFile{"docker/info.json", []byte(redact(string(dockerInfoJSON)))})
+	}
+
+	files = append(files,
+		// read without the side effects of the status tasks, such as booting components
+		bundleFile{"tasks/status.json", jsonOutput(readNodeStatus(), nil)},
+		bundleFile{"tasks/sync-state.json", jsonOutput(readSyncState())},
+		bundleFile{"tasks/disk.json", taskOutput(diskTask)},
+		bundleFile{"tasks/diagnose.json", taskOutput(diagnoseTask)},
+	)
+
+	storage, _ := appstate.GetStoragePath()
+	bundlePath := path.Join(storage, "support-bundle-"+time.Now().UTC().Format("20060102-150405")+".tar.gz")
+	err = writeTarGz(bundlePath, files)
+	if err != nil {
+		utils.WriteError("Error writing support bundle:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	response := SupportBundleResponse{Path: bundlePath, Files: []string{}}
+	if info, err := os.Stat(bundlePath); err == nil {
+		response.Size = info.Size()
+	}
+	for _, bundled := range files {
+		response.Files = append(response.Files, bundled.name)
+	}
+
+	// Serialize the struct to JSON
+	jsonBytes, err := json.Marshal(response)
+	if err != nil {
+		utils.WriteError("Error serializing to JSON:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	return string(jsonBytes)
+}
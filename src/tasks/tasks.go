@@ -5,67 +5,172 @@ type TaskFunc func(map[string]string) string
 
 // TaskMap maps task names to their corresponding functions
 var TaskMap = map[string]TaskFunc{
-	"install":      installTask,
-	"uninstall":    uninstallTask,
-	"installed":    installedTask,
-	"status":       statusTask,
-	"start":        startTask,
-	"stop":         stopTask,
-	"sync-state":   syncStateTask,
-	"resync":       resyncTask,
-	"restart":      restartTask,
-	"logs":         logsTask,
-	"chain":        getChainTask,
-	"wallet-fetch": walletFetchTask,
-	"wallet-load":  walletLoadTask,
-	"wallet-purge": walletPurgeTask,
-	"pools-fetch":  poolsFetchTask,
-	"unstake":      unstakeTask,
-	"stake":        stakeTask,
-	"rewards":      rewardTask,
+	"install":                installTask,
+	"uninstall":              uninstallTask,
+	"installed":              installedTask,
+	"status":                 statusTask,
+	"start":                  startTask,
+	"stop":                   stopTask,
+	"sync-state":             syncStateTask,
+	"resync":                 resyncTask,
+	"restart":                restartTask,
+	"logs":                   logsTask,
+	"chain":                  getChainTask,
+	"networks":               networksTask,
+	"wallet-fetch":           walletFetchTask,
+	"wallet-load":            walletLoadTask,
+	"wallet-purge":           walletPurgeTask,
+	"pools-fetch":            poolsFetchTask,
+	"unstake":                unstakeTask,
+	"stake":                  stakeTask,
+	"rewards":                rewardTask,
+	"heimdall-config-get":    heimdallConfigGetTask,
+	"heimdall-config-set":    heimdallConfigSetTask,
+	"heimdall-peers":         heimdallPeersTask,
+	"heimdall-peers-add":     heimdallPeersAddTask,
+	"heimdall-node-id":       heimdallNodeIDTask,
+	"heimdall-reference-set": heimdallReferenceSetTask,
+	"validator-enable":       validatorEnableTask,
+	"validator-disable":      validatorDisableTask,
+	"validator-status":       validatorStatusTask,
+	"stake-manager-params":   stakeManagerParamsTask,
+	"validator-stake":        validatorStakeTask,
+	"validator-topup":        validatorTopUpTask,
+	"validator-commission":   validatorCommissionTask,
+	"erigon-config-get":      erigonConfigGetTask,
+	"erigon-config-set":      erigonConfigSetTask,
+	"metrics":                metricsTask,
+	"alerts-check":           alertsCheckTask,
+	"alerts-get":             alertsGetTask,
+	"alerts-set":             alertsSetTask,
+	"watchdog":               watchdogTask,
+	"watchdog-get":           watchdogGetTask,
+	"watchdog-set":           watchdogSetTask,
+	"history":                historyTask,
+	"stats":                  statsTask,
+	"diagnose":               diagnoseTask,
+	"disk":                   diskTask,
+	"support-bundle":         supportBundleTask,
+	"preflight":              preflightTask,
+	"install-rollback":       installRollbackTask,
+	"reconfigure":            reconfigureTask,
+	"external-ip":            externalIPTask,
+	"backup":                 backupTask,
+	"restore":                restoreTask,
+	"migrate-data":           migrateDataTask,
 }
 
 // TaskRequirements maps task names to their required system conditions
 var TaskRequirements = map[string][]string{
-	"install":      {"docker", "uninstalled", "linux", "cpu4"},
-	"uninstall":    {"docker", "stopped"},
-	"installed":    {"docker"},
-	"status":       {},
-	"start":        {"docker", "stopped"},
-	"stop":         {"docker", "running"},
-	"sync-state":   {"docker", "running"},
-	"resync":       {"docker", "installed"},
-	"restart":      {"docker", "running"},
-	"logs":         {"docker", "running"},
-	"chain":        {"docker", "installed"},
-	"wallet-fetch": {"installed"},
-	"wallet-load":  {"installed"},
-	"wallet-purge": {"installed"},
-	"pools-fetch":  {"installed"},
-	"unstake":      {"installed"},
-	"stake":        {"installed"},
-	"rewards":      {"installed"},
+	"install":                {"docker", "uninstalled", "linux", "cpu4"},
+	"uninstall":              {"docker", "stopped"},
+	"installed":              {"docker"},
+	"status":                 {},
+	"start":                  {"docker", "stopped"},
+	"stop":                   {"docker", "running"},
+	"sync-state":             {"docker", "running"},
+	"resync":                 {"docker", "installed"},
+	"restart":                {"docker", "running"},
+	"logs":                   {"docker", "running"},
+	"chain":                  {"docker", "installed"},
+	"networks":               {},
+	"wallet-fetch":           {"installed"},
+	"wallet-load":            {"installed"},
+	"wallet-purge":           {"installed"},
+	"pools-fetch":            {"installed"},
+	"unstake":                {"installed"},
+	"stake":                  {"installed"},
+	"rewards":                {"installed"},
+	"heimdall-config-get":    {"installed"},
+	"heimdall-config-set":    {"docker", "installed"},
+	"heimdall-peers":         {"docker", "running"},
+	"heimdall-peers-add":     {"docker", "installed"},
+	"heimdall-node-id":       {"docker", "running"},
+	"heimdall-reference-set": {"installed"},
+	"validator-enable":       {"docker", "installed"},
+	"validator-disable":      {"docker", "installed"},
+	"validator-status":       {"docker", "running"},
+	"stake-manager-params":   {"installed"},
+	"validator-stake":        {"installed"},
+	"validator-topup":        {"installed"},
+	"validator-commission":   {"installed"},
+	"erigon-config-get":      {"installed"},
+	"erigon-config-set":      {"docker", "installed"},
+	"metrics":                {},
+	"alerts-check":           {},
+	"alerts-get":             {},
+	"alerts-set":             {},
+	"watchdog":               {},
+	"watchdog-get":           {},
+	"watchdog-set":           {},
+	"history":                {},
+	"stats":                  {"docker", "installed"},
+	"diagnose":               {"docker", "installed"},
+	"disk":                   {"installed"},
+	"support-bundle":         {"docker"},
+	"preflight":              {},
+	"install-rollback":       {"docker", "uninstalled"},
+	"reconfigure":            {"installed"},
+	"external-ip":            {"installed"},
+	"backup":                 {"docker", "installed"},
+	"restore":                {"docker", "installed"},
+	"migrate-data":           {"docker", "installed"},
 }
 
 var TarkArgs = map[string][]string{
-	"install":      {"ethereumRPC", "testnet", "autostart", "mnemonic"},
-	"uninstall":    {},
-	"installed":    {},
-	"status":       {},
-	"start":        {},
-	"stop":         {},
-	"sync-state":   {},
-	"resync":       {"erigon", "heimdall"},
-	"restart":      {},
-	"logs":         {"erigon", "heimdall", "lines"},
-	"chain":        {},
-	"wallet-fetch": {},
-	"wallet-load":  {"privateKey", "mnemonic"},
-	"wallet-purge": {},
-	"pools-fetch":  {},
-	"unstake":      {"amount", "address"},
-	"stake":        {"amount", "address"},
-	"rewards":      {"address"},
+	"install":                {"ethereumRPC", "testnet", "autostart", "mnemonic"},
+	"uninstall":              {},
+	"installed":              {},
+	"status":                 {},
+	"start":                  {},
+	"stop":                   {},
+	"sync-state":             {},
+	"resync":                 {"erigon", "heimdall"},
+	"restart":                {},
+	"logs":                   {"erigon", "heimdall", "lines"},
+	"chain":                  {},
+	"networks":               {},
+	"wallet-fetch":           {},
+	"wallet-load":            {"privateKey", "mnemonic"},
+	"wallet-purge":           {},
+	"pools-fetch":            {},
+	"unstake":                {"amount", "address"},
+	"stake":                  {"amount", "address"},
+	"rewards":                {"address"},
+	"heimdall-config-get":    {},
+	"heimdall-config-set":    {"restart"},
+	"heimdall-peers":         {},
+	"heimdall-peers-add":     {"peers"},
+	"heimdall-node-id":       {},
+	"heimdall-reference-set": {"endpoints"},
+	"validator-enable":       {"signerKey", "sentries", "executionSentries"},
+	"validator-disable":      {},
+	"validator-status":       {},
+	"stake-manager-params":   {},
+	"validator-stake":        {"amount", "heimdallFee"},
+	"validator-topup":        {"heimdallFee"},
+	"validator-commission":   {"commission"},
+	"erigon-config-get":      {},
+	"erigon-config-set":      {"restart"},
+	"metrics":                {},
+	"alerts-check":           {},
+	"alerts-get":             {},
+	"alerts-set":             {},
+	"watchdog":               {},
+	"watchdog-get":           {},
+	"watchdog-set":           {},
+	"history":                {},
+	"stats":                  {},
+	"diagnose":               {},
+	"disk":                   {},
+	"support-bundle":         {},
+	"preflight":              {},
+	"install-rollback":       {},
+	"reconfigure":            {},
+	"external-ip":            {},
+	"backup":                 {"target"},
+	"restore":                {"source"},
+	"migrate-data":           {"target"},
 }
 
 // validateRequirements checks if all requirements for a task are met
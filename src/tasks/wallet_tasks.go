@@ -13,9 +13,6 @@ type WalletResponse struct {
 	ETHBalance   string `json:"ethBalance"`
 }
 
-const MATIC_ADDR = "0x7D1AfA7B718fb893dB30A3aBc0Cfc608AaCfeBB0"
-const TESTNET_MATIC_ADDR = "0x499d11E0b6eAC7c0593d8Fb292DCBbF815Fb29Ae"
-
 // walletFetchTask fetches the stored wallet data
 func walletFetchTask(args map[string]string) string {
 	client, err := utils.NewBlockchainClient(appstate.CurrentState.RPC)
@@ -23,10 +20,7 @@ func walletFetchTask(args map[string]string) string {
 		utils.WriteError("Error creating blockchain client:" + err.Error())
 		return RESULT_ERROR
 	}
-	maticAddress := MATIC_ADDR
-	if appstate.CurrentState.IsTestnet {
-		maticAddress = TESTNET_MATIC_ADDR
-	}
+	maticAddress := appstate.CurrentNetwork().MaticAddress
 	maticBalance, err := client.GetERC20Balance(maticAddress, appstate.CurrentState.Wallet.Address)
 	if err != nil {
 		utils.WriteError("Error fetching MATIC balance:" + err.Error())
@@ -0,0 +1,317 @@
+package tasks
+
+import (
+	"KeepixPlugin/appstate"
+	"KeepixPlugin/utils"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// watchdogComponent is a component the watchdog can detect as stuck or crashed and restart on its own
+type watchdogComponent struct {
+	name      string
+	container func() string
+	// enabled returns false while the component is not supposed to run, such as during its snapshot download
+	enabled func() bool
+	// progress returns a value that changes as long as the component is syncing or following the chain
+	progress func() (string, error)
+	restart  func() error
+}
+
+var watchdogComponents = []watchdogComponent{
+	{
+		name:      "heimdall",
+		container: func() string { return "heimdall" },
+		enabled:   func() bool { return appstate.CurrentState.HeimdallSnapshotDownloaded },
+		progress:  heimdallProgress,
+		restart:   restartHeimdallNode,
+	},
+	{
+		name:      "heimdall-rest",
+		container: func() string { return "heimdall-rest" },
+		enabled:   func() bool { return appstate.CurrentState.HeimdallSnapshotDownloaded },
+		progress:  heimdallRestProgress,
+		restart:   restartHeimdallRestServer,
+	},
+	{
+		name:      "execution",
+		container: func() string { return utils.CurrentExecutionClient().ContainerName() },
+		enabled: func() bool {
+			return appstate.CurrentState.ErigonSnapshotSource == "" || appstate.CurrentState.ErigonSnapshotDownloaded
+		},
+		progress: executionProgress,
+		restart:  restartExecutionContainer,
+	},
+}
+
+func heimdallProgress() (string, error) {
+	status, err := utils.GetHeimdallLocalStatus()
+	if err != nil {
+		return "", fmt.Errorf("heimdall does not answer: %v", err)
+	}
+	return status.Result.SyncInfo.LatestBlockHeight, nil
+}
+
+// heimdallRestProgress only checks the rest server answers, it follows heimdall and has no height of its own
+func heimdallRestProgress() (string, error) {
+	if _, err := utils.GetHeimdallLocalStatus(); err != nil {
+		// the rest server cannot answer without heimdall, which is handled on its own
+		return "", nil
+	}
+	if err := utils.CheckHeimdallRestServer(); err != nil {
+		return "", fmt.Errorf("rest server is unhealthy: %v", err)
+	}
+	return "", nil
+}
+
+func executionProgress() (string, error) {
+	client := utils.CurrentExecutionClient()
+	status, err := client.SyncingStatus()
+	if err != nil {
+		return "", fmt.Errorf("%s does not answer: %v", client.Name(), err)
+	}
+	if status.Stage == "Synced" {
+		block, err := utils.GetExecutionBlockNumber()
+		if err != nil {
+			return "", fmt.Errorf("%s does not answer: %v", client.Name(), err)
+		}
+		return strconv.FormatUint(block, 10), nil
+	}
+	// erigon stages may run for hours on the same block, any stage moving is progress
+	parts := []string{status.Stage, strconv.FormatUint(status.CurrentBlock, 10), fmt.Sprintf("%.2f", status.Progress)}
+	for _, stage := range status.Stages {
+		parts = append(parts, fmt.Sprintf("%s=%d", stage.Name, stage.Block))
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// restartHeimdallNode restarts the heimdall container only, it is recreated with the whole heimdall stack if it is gone
+func restartHeimdallNode() error {
+	info, err := utils.InspectContainer("heimdall")
+	if err != nil {
+		return err
+	}
+	if info.Exists {
+		return utils.RestartContainer("heimdall")
+	}
+	if !restartHeimdall() {
+		return fmt.Errorf("error recreating heimdall")
+	}
+	return nil
+}
+
+func restartHeimdallRestServer() error {
+	info, err := utils.InspectContainer("heimdall-rest")
+	if err != nil {
+		return err
+	}
+	if info.Exists {
+		return utils.RestartContainer("heimdall-rest")
+	}
+	if !startHeimdallFrom(appstate.StartingRestServer) {
+		return fmt.Errorf("error recreating heimdall rest server")
+	}
+	return nil
+}
+
+func restartExecutionContainer() error {
+	client := utils.CurrentExecutionClient()
+	info, err := utils.InspectContainer(client.ContainerName())
+	if err != nil {
+		return err
+	}
+	if info.Exists {
+		return utils.RestartContainer(client.ContainerName())
+	}
+	if !startExecutionClient() {
+		return fmt.Errorf("error recreating %s", client.Name())
+	}
+	return nil
+}
+
+// recordWatchdogAction adds a watchdog action to the state history
+func recordWatchdogAction(component string, action string, message string) {
+	fmt.Println("Watchdog " + action + " " + component + ": " + message)
+	err := appstate.AppendHistory(appstate.HistoryEntry{Time: time.Now().Unix(), Source: "watchdog", Component: component, Action: action, Message: message})
+	if err != nil {
+		fmt.Println("Error saving history:" + err.Error())
+	}
+}
+
+type WatchdogComponentStatus struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"` // skipped, healthy, restarted, backoff or gave_up
+	Problem  string `json:"problem"`
+	Attempts int    `json:"attempts"`
+}
+
+// checkWatchdogComponent finds what is wrong with a component, an empty problem means it is healthy
+func checkWatchdogComponent(component watchdogComponent, tracked *appstate.WatchdogComponent, config appstate.WatchdogConfig, now int64) (string, error) {
+	info, err := utils.InspectContainer(component.container())
+	if err != nil {
+		return "", err
+	}
+	if !info.Exists {
+		return "container is missing", nil
+	}
+	if info.Restarting {
+		return fmt.Sprintf("container is crash looping, %d restarts", info.RestartCount), nil
+	}
+	if !info.Running {
+		return "container is not running", nil
+	}
+
+	progress, err := component.progress()
+	if err != nil {
+		return err.Error(), nil
+	}
+	return utils.WatchdogProgress(tracked, progress, config.StallMinutes, now), nil
+}
+
+// watchdogTask detects stalled heights, crash loops and an unhealthy rest server, and restarts the affected component.
+// It is meant to be run periodically: attempts are spaced by a doubling backoff and capped, then it gives up until
+// the component is healthy again. Every action is recorded in the state history.
+func watchdogTask(args map[string]string) string {
+	watchdog := appstate.CurrentState.Watchdog
+	if watchdog.Components == nil {
+		watchdog.Components = make(map[string]appstate.WatchdogComponent)
+	}
+	config := watchdog.Config
+	now := time.Now().Unix()
+
+	statuses := []WatchdogComponentStatus{}
+	for _, component := range watchdogComponents {
+		status := WatchdogComponentStatus{Name: component.name, Status: "skipped"}
+		if !config.Enabled || appstate.CurrentState.State != appstate.NodeStarted || !component.enabled() {
+			// nothing to watch, start afresh once it runs
+			delete(watchdog.Components, component.name)
+			statuses = append(statuses, status)
+			continue
+		}
+
+		tracked := watchdog.Components[component.name]
+		problem, err := checkWatchdogComponent(component, &tracked, config, now)
+		if err != nil {
+			utils.WriteError("Error checking " + component.name + ":" + err.Error())
+			return RESULT_ERROR
+		}
+		status.Problem = problem
+
+		var action, message string
+		status.Status, action, message = utils.WatchdogStep(&tracked, problem, config, now)
+		if action != "" {
+			recordWatchdogAction(component.name, action, message)
+		}
+		if status.Status == utils.WatchdogRestarted {
+			err := component.restart()
+			if err != nil {
+				recordWatchdogAction(component.name, "restart_failed", fmt.Sprintf("attempt %d, %s: %v", tracked.Attempts, problem, err))
+			} else {
+				recordWatchdogAction(component.name, "restarted", fmt.Sprintf("attempt %d, %s", tracked.Attempts, problem))
+			}
+		}
+		status.Attempts = tracked.Attempts
+		if status.Attempts > config.MaxAttempts {
+			status.Attempts = config.MaxAttempts
+		}
+		watchdog.Components[component.name] = tracked
+		statuses = append(statuses, status)
+	}
+
+	err := appstate.UpdateWatchdog(watchdog)
+	if err != nil {
+		utils.WriteError("Error saving watchdog state:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	// Serialize the struct to JSON
+	jsonBytes, err := json.Marshal(statuses)
+	if err != nil {
+		utils.WriteError("Error serializing to JSON:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	return string(jsonBytes)
+}
+
+// watchdogGetTask returns the watchdog configuration
+func watchdogGetTask(args map[string]string) string {
+	// Serialize the struct to JSON
+	jsonBytes, err := json.Marshal(appstate.CurrentState.Watchdog.Config)
+	if err != nil {
+		utils.WriteError("Error serializing to JSON:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	return string(jsonBytes)
+}
+
+// watchdogSetTask updates the watchdog settings given as arguments
+func watchdogSetTask(args map[string]string) string {
+	watchdog := appstate.CurrentState.Watchdog
+	config := &watchdog.Config
+	for name, value := range args {
+		value = strings.TrimSpace(value)
+		var err error
+		switch name {
+		case "enabled":
+			config.Enabled, err = strconv.ParseBool(value)
+		case "stallMinutes":
+			config.StallMinutes, err = strconv.Atoi(value)
+		case "backoffMinutes":
+			config.BackoffMinutes, err = strconv.Atoi(value)
+		case "maxAttempts":
+			config.MaxAttempts, err = strconv.Atoi(value)
+		default:
+			err = fmt.Errorf("unknown setting, available settings: enabled, stallMinutes, backoffMinutes, maxAttempts")
+		}
+		if err != nil {
+			utils.WriteError("Invalid " + name + ":" + err.Error())
+			return RESULT_ERROR
+		}
+	}
+	if config.StallMinutes <= 0 || config.BackoffMinutes < 0 || config.MaxAttempts < 0 {
+		utils.WriteError("stallMinutes must be positive, backoffMinutes and maxAttempts cannot be negative")
+		return RESULT_ERROR
+	}
+
+	err := appstate.UpdateWatchdog(watchdog)
+	if err != nil {
+		utils.WriteError("Error saving watchdog state:" + err.Error())
+		return RESULT_ERROR
+	}
+	fmt.Println("Successfully updated watchdog")
+	return RESULT_SUCCESS
+}
+
+// historyTask returns the latest actions taken on the node, the optional limit argument defaults to 50 entries
+func historyTask(args map[string]string) string {
+	limit := 50
+	if args["limit"] != "" {
+		var err error
+		limit, err = strconv.Atoi(args["limit"])
+		if err != nil || limit <= 0 {
+			utils.WriteError("Invalid limit")
+			return RESULT_ERROR
+		}
+	}
+	history := appstate.CurrentState.History
+	if len(history) > limit {
+		history = history[len(history)-limit:]
+	}
+	if history == nil {
+		history = []appstate.HistoryEntry{}
+	}
+
+	// Serialize the struct to JSON
+	jsonBytes, err := json.Marshal(history)
+	if err != nil {
+		utils.WriteError("Error serializing to JSON:" + err.Error())
+		return RESULT_ERROR
+	}
+
+	return string(jsonBytes)
+}
@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"KeepixPlugin/appstate"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Alert is a notification sent to the webhooks
+type Alert struct {
+	Key       string `json:"key"`
+	Status    string `json:"status"` // firing or resolved
+	Severity  string `json:"severity"`
+	Message   string `json:"message"`
+	Network   string `json:"network"`
+	Since     int64  `json:"since"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// AlertWebhookFormats are the payload formats supported by the webhooks
+var AlertWebhookFormats = []string{"json", "slack", "discord"}
+
+// alertText is the human readable form of an alert used by chat webhooks
+func alertText(alert Alert) string {
+	icon := "🔴"
+	if alert.Status == "resolved" {
+		icon = "✅"
+	} else if alert.Severity == "info" {
+		icon = "ℹ️"
+	} else if alert.Severity == "warning" {
+		icon = "⚠️"
+	}
+	return fmt.Sprintf("%s [%s] polygon %s node: %s", icon, strings.ToUpper(alert.Status), alert.Network, alert.Message)
+}
+
+// AlertPayload builds the body posted to a webhook of the given format.
+func AlertPayload(format string, alert Alert) ([]byte, error) {
+	switch format {
+	case "", "json":
+		return json.Marshal(alert)
+	case "slack":
+		return json.Marshal(map[string]string{"text": alertText(alert)})
+	case "discord":
+		return json.Marshal(map[string]string{"content": alertText(alert)})
+	}
+	return nil, fmt.Errorf("unknown webhook format %s", format)
+}
+
+// SendAlert posts an alert to a webhook.
+func SendAlert(url string, format string, alert Alert) error {
+	payload, err := AlertPayload(format, alert)
+	if err != nil {
+		return err
+	}
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	resp, err := httpClient.Post(url, "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// AlertCondition is a condition found by a rule, alerts are keyed by rule name or "rule:subject"
+type AlertCondition struct {
+	Severity string
+	Message  string
+}
+
+// ReconcileAlerts updates the active alerts with the conditions firing now, deliver sends an alert with the firing or
+// resolved status and returns whether it was delivered. A firing alert is sent once, then again every repeatMinutes
+// when it is not 0. An alert no longer firing is sent as resolved and dropped once delivered, or dropped at once if it
+// was never delivered. The alerts of the rules that could not be evaluated are kept as they are.
+func ReconcileAlerts(active map[string]appstate.ActiveAlert, firing map[string]AlertCondition, failedRules map[string]bool, repeatMinutes int, now int64, deliver func(key string, status string, alert appstate.ActiveAlert) bool) {
+	keys := make([]string, 0, len(active)+len(firing))
+	for key := range active {
+		keys = append(keys, key)
+	}
+	for key := range firing {
+		if _, exists := active[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		alert, exists := active[key]
+		condition, isFiring := firing[key]
+		ruleName, _, _ := strings.Cut(key, ":")
+
+		if isFiring {
+			if !exists {
+				alert = appstate.ActiveAlert{Since: now}
+			}
+			alert.Resolved = false
+			alert.Message = condition.Message
+			alert.Severity = condition.Severity
+			repeat := repeatMinutes > 0 && now-alert.NotifiedAt >= int64(repeatMinutes)*60
+			if alert.NotifiedAt == 0 || repeat {
+				if deliver(key, "firing", alert) {
+					alert.NotifiedAt = now
+				}
+			}
+			active[key] = alert
+			continue
+		}
+
+		if failedRules[ruleName] {
+			// the rule could not be evaluated, keep the alert as it is
+			continue
+		}
+		if alert.NotifiedAt == 0 {
+			// nobody heard of it
+			delete(active, key)
+			continue
+		}
+		alert.Resolved = true
+		if deliver(key, "resolved", alert) {
+			delete(active, key)
+		} else {
+			active[key] = alert
+		}
+	}
+}
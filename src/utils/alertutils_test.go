@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"KeepixPlugin/appstate"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSendAlert(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, string(body))
+	}))
+	defer server.Close()
+
+	alert := Alert{Key: "heimdall_lag", Status: "firing", Severity: "warning", Message: "Heimdall is 200 blocks behind", Network: "mainnet"}
+	for _, format := range AlertWebhookFormats {
+		if err := SendAlert(server.URL, format, alert); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+	}
+
+	var jsonAlert Alert
+	if err := json.Unmarshal([]byte(received[0]), &jsonAlert); err != nil || jsonAlert != alert {
+		t.Errorf("unexpected json payload %s", received[0])
+	}
+	var slack map[string]string
+	if err := json.Unmarshal([]byte(received[1]), &slack); err != nil || !strings.Contains(slack["text"], "[FIRING]") {
+		t.Errorf("unexpected slack payload %s", received[1])
+	}
+	var discord map[string]string
+	if err := json.Unmarshal([]byte(received[2]), &discord); err != nil || !strings.Contains(discord["content"], alert.Message) {
+		t.Errorf("unexpected discord payload %s", received[2])
+	}
+
+	if _, err := AlertPayload("teams", alert); err == nil {
+		t.Error("expected an unknown format to be refused")
+	}
+}
+
+func TestSendAlertFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	if err := SendAlert(server.URL, "json", Alert{}); err == nil {
+		t.Error("expected a failed delivery")
+	}
+}
+
+func TestReconcileAlerts(t *testing.T) {
+	const now = int64(100000)
+	firing := map[string]AlertCondition{"heimdall_lag": {Severity: "warning", Message: "behind"}}
+	tests := []struct {
+		name        string
+		active      map[string]appstate.ActiveAlert
+		firing      map[string]AlertCondition
+		failedRules map[string]bool
+		repeat      int
+		delivered   bool
+		sent        []string
+		expected    map[string]appstate.ActiveAlert
+	}{
+		{
+			name:      "new alert is sent",
+			active:    map[string]appstate.ActiveAlert{},
+			firing:    firing,
+			delivered: true,
+			sent:      []string{"heimdall_lag firing"},
+			expected:  map[string]appstate.ActiveAlert{"heimdall_lag": {Severity: "warning", Message: "behind", Since: now, NotifiedAt: now}},
+		},
+		{
+			name:     "undelivered alert is sent again",
+			active:   map[string]appstate.ActiveAlert{"heimdall_lag": {Since: now - 60}},
+			firing:   firing,
+			sent:     []string{"heimdall_lag firing"},
+			expected: map[string]appstate.ActiveAlert{"heimdall_lag": {Severity: "warning", Message: "behind", Since: now - 60}},
+		},
+		{
+			name:      "notified alert is not repeated before repeatMinutes",
+			active:    map[string]appstate.ActiveAlert{"heimdall_lag": {Since: now - 600, NotifiedAt: now - 600}},
+			firing:    firing,
+			repeat:    30,
+			delivered: true,
+			expected:  map[string]appstate.ActiveAlert{"heimdall_lag": {Severity: "warning", Message: "behind", Since: now - 600, NotifiedAt: now - 600}},
+		},
+		{
+			name:      "notified alert is repeated after repeatMinutes",
+			active:    map[string]appstate.ActiveAlert{"heimdall_lag": {Since: now - 1800, NotifiedAt: now - 1800}},
+			firing:    firing,
+			repeat:    30,
+			delivered: true,
+			sent:      []string{"heimdall_lag firing"},
+			expected:  map[string]appstate.ActiveAlert{"heimdall_lag": {Severity: "warning", Message: "behind", Since: now - 1800, NotifiedAt: now}},
+		},
+		{
+			name:      "notified alert is never repeated without repeatMinutes",
+			active:    map[string]appstate.ActiveAlert{"heimdall_lag": {Since: now - 86400, NotifiedAt: now - 86400}},
+			firing:    firing,
+			delivered: true,
+			expected:  map[string]appstate.ActiveAlert{"heimdall_lag": {Severity: "warning", Message: "behind", Since: now - 86400, NotifiedAt: now - 86400}},
+		},
+		{
+			name:      "resolved alert is sent and dropped",
+			active:    map[string]appstate.ActiveAlert{"heimdall_lag": {Since: now - 600, NotifiedAt: now - 600}},
+			firing:    map[string]AlertCondition{},
+			delivered: true,
+			sent:      []string{"heimdall_lag resolved"},
+			expected:  map[string]appstate.ActiveAlert{},
+		},
+		{
+			name:     "undelivered resolution is kept",
+			active:   map[string]appstate.ActiveAlert{"heimdall_lag": {Since: now - 600, NotifiedAt: now - 600}},
+			firing:   map[string]AlertCondition{},
+			sent:     []string{"heimdall_lag resolved"},
+			expected: map[string]appstate.ActiveAlert{"heimdall_lag": {Since: now - 600, NotifiedAt: now - 600, Resolved: true}},
+		},
+		{
+			name:      "resolved alert firing again is active again",
+			active:    map[string]appstate.ActiveAlert{"heimdall_lag": {Since: now - 600, NotifiedAt: now - 600, Resolved: true}},
+			firing:    firing,
+			repeat:    30,
+			delivered: true,
+			expected:  map[string]appstate.ActiveAlert{"heimdall_lag": {Severity: "warning", Message: "behind", Since: now - 600, NotifiedAt: now - 600}},
+		},
+		{
+			name:      "alert nobody heard of is dropped silently",
+			active:    map[string]appstate.ActiveAlert{"heimdall_lag": {Since: now - 600}},
+			firing:    map[string]AlertCondition{},
+			delivered: true,
+			expected:  map[string]appstate.ActiveAlert{},
+		},
+		{
+			name:        "alert of a failed rule is kept",
+			active:      map[string]appstate.ActiveAlert{"unbonding_ready:7": {Since: now - 600, NotifiedAt: now - 600}},
+			firing:      map[string]AlertCondition{},
+			failedRules: map[string]bool{"unbonding_ready": true},
+			delivered:   true,
+			expected:    map[string]appstate.ActiveAlert{"unbonding_ready:7": {Since: now - 600, NotifiedAt: now - 600}},
+		},
+	}
+	for _, test := range tests {
+		sent := []string{}
+		ReconcileAlerts(test.active, test.firing, test.failedRules, test.repeat, now, func(key string, status string, alert appstate.ActiveAlert) bool {
+			sent = append(sent, key+" "+status)
+			return test.delivered
+		})
+		if len(test.sent) == 0 {
+			test.sent = []string{}
+		}
+		if !reflect.DeepEqual(sent, test.sent) {
+			t.Errorf("%s: sent %v, expected %v", test.name, sent, test.sent)
+		}
+		if !reflect.DeepEqual(test.active, test.expected) {
+			t.Errorf("%s: alerts %+v, expected %+v", test.name, test.active, test.expected)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+// BackupManifestFile is the manifest written next to the archives of a backup
+const BackupManifestFile = "manifest.json"
+
+// backupManifestVersion is the version of the backup layout, restore refuses newer ones
+const backupManifestVersion = 1
+
+// BackupFolder is a folder of the plugin data folder archived by a backup
+type BackupFolder struct {
+	Component string   // heimdall or execution
+	Folder    string   // relative to the plugin data folder
+	Excludes  []string // names of the files left out of the archive
+}
+
+// BackupArchive is an archive of a backup
+type BackupArchive struct {
+	Component string `json:"component"`
+	File      string `json:"file"`
+	Folder    string `json:"folder"`   // relative to the plugin data folder, where the archive is extracted
+	Size      int64  `json:"size"`     // size of the archive
+	DataSize  uint64 `json:"dataSize"` // size of the folder once extracted
+	SHA256    string `json:"sha256"`
+}
+
+// BackupManifest describes a backup, restore refuses backups of another network or execution client
+type BackupManifest struct {
+	Version         int             `json:"version"`
+	CreatedAt       int64           `json:"createdAt"`
+	PluginVersion   string          `json:"pluginVersion"`
+	Network         string          `json:"network"`
+	ExecutionClient string          `json:"executionClient"`
+	ErigonProfile   string          `json:"erigonProfile"`
+	HeimdallHeight  int64           `json:"heimdallHeight"` // 0 when heimdall was not reachable
+	ExecutionBlock  uint64          `json:"executionBlock"` // 0 when the execution client was not reachable
+	Archives        []BackupArchive `json:"archives"`
+}
+
+// backupArchiveFile returns the name of the archive of a folder, after its first path element
+func backupArchiveFile(folder string) string {
+	return strings.SplitN(folder, "/", 2)[0] + ".tar.zst"
+}
+
+// shellQuote quotes a value for the helper container shell
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// backupScript archives the folders of /data into /backup with zstd and writes their checksums to SHA256SUMS
+func backupScript(folders []BackupFolder) string {
+	var script strings.Builder
+	script.WriteString("set -e -o pipefail\napk add $APK_FLAGS zstd tar coreutils\ncd /data\n")
+	files := make([]string, 0, len(folders))
+	for i, folder := range folders {
+		file := backupArchiveFile(folder.Folder)
+		files = append(files, shellQuote(file))
+		excludes := ""
+		for _, exclude := range folder.Excludes {
+			excludes += " --exclude=" + shellQuote(exclude)
+		}
+		fmt.Fprintf(&script, "echo \"Archiving [%d/%d] %s\"\n", i+1, len(folders), folder.Folder)
+		fmt.Fprintf(&script, "echo \"Size %s $(du -sb %s | cut -f1)\"\n", file, shellQuote(folder.Folder))
+		fmt.Fprintf(&script, "tar -cf -%s %s | zstd -T0 -q -f -o /backup/%s\n", excludes, shellQuote(folder.Folder), shellQuote(file))
+	}
+	fmt.Fprintf(&script, "cd /backup\nsha256sum %s > %s\n", strings.Join(files, " "), SnapshotChecksumFile)
+	fmt.Fprintf(&script, "chown \"$OWNER\" %s %s\n", strings.Join(files, " "), SnapshotChecksumFile)
+	script.WriteString("echo \"Command succeeded\"\n")
+	return script.String()
+}
+
+// parseSHA256Sums reads a sha256sum formatted file into checksums by file name
+func parseSHA256Sums(content string) map[string]string {
+	sums := make(map[string]string)
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			sums[strings.TrimPrefix(fields[1], "*")] = fields[0]
+		}
+	}
+	return sums
+}
+
+// dataMounts mounts the host folders of the chain data under /data, by the first path element of their folders
+func dataMounts(roots map[string]string, readOnly bool) []mount.Mount {
+	mounts := []mount.Mount{}
+	for name, hostPath := range roots {
+		mounts = append(mounts, mount.Mount{Type: mount.TypeBind, Source: hostPath, Target: "/data/" + name, ReadOnly: readOnly})
+	}
+	return mounts
+}
+
+// RunBackup archives the folders into the target directory with a helper container, so files owned by the containers
+// can be read, and returns the archives written. The roots are the host folders the folders are relative to, by the
+// first element of their path.
+func RunBackup(roots map[string]string, target string, folders []BackupFolder) ([]BackupArchive, error) {
+	mounts := append(dataMounts(roots, true), mount.Mount{Type: mount.TypeBind, Source: target, Target: "/backup"})
+	env := []string{fmt.Sprintf("OWNER=%d:%d", os.Getuid(), os.Getgid())}
+	logs, err := RunHelperContainer("backup", backupScript(folders), mounts, env, printProgress)
+	if err != nil {
+		return nil, err
+	}
+
+	dataSizes := make(map[string]uint64)
+	for _, match := range regexp.MustCompile(`Size (\S+) (\d+)`).FindAllStringSubmatch(logs, -1) {
+		dataSizes[match[1]], _ = strconv.ParseUint(match[2], 10, 64)
+	}
+	content, err := os.ReadFile(filepath.Join(target, SnapshotChecksumFile))
+	if err != nil {
+		return nil, fmt.Errorf("error reading checksums: %v", err)
+	}
+	sums := parseSHA256Sums(string(content))
+
+	archives := make([]BackupArchive, 0, len(folders))
+	for _, folder := range folders {
+		file := backupArchiveFile(folder.Folder)
+		info, err := os.Stat(filepath.Join(target, file))
+		if err != nil {
+			return nil, fmt.Errorf("error reading archive: %v", err)
+		}
+		archives = append(archives, BackupArchive{
+			Component: folder.Component,
+			File:      file,
+			Folder:    folder.Folder,
+			Size:      info.Size(),
+			DataSize:  dataSizes[file],
+			SHA256:    sums[file],
+		})
+	}
+	return archives, nil
+}
+
+// WriteBackupManifest writes the manifest of a backup to its directory, with the current layout version.
+func WriteBackupManifest(dir string, manifest *BackupManifest) error {
+	manifest.Version = backupManifestVersion
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, BackupManifestFile), content, 0644)
+}
+
+// ReadBackupManifest reads the manifest of a backup and checks the archives it lists are complete: present, of the
+// recorded size and with the checksums of SHA256SUMS. The checksums themselves are verified by RunRestore.
+func ReadBackupManifest(dir string) (*BackupManifest, error) {
+	content, err := os.ReadFile(filepath.Join(dir, BackupManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest: %v", err)
+	}
+	var manifest BackupManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	if manifest.Version > backupManifestVersion {
+		return nil, fmt.Errorf("backup version %d is not supported, update the plugin", manifest.Version)
+	}
+	if len(manifest.Archives) == 0 {
+		return nil, fmt.Errorf("the manifest lists no archive")
+	}
+
+	content, err = os.ReadFile(filepath.Join(dir, SnapshotChecksumFile))
+	if err != nil {
+		return nil, fmt.Errorf("error reading checksums: %v", err)
+	}
+	sums := parseSHA256Sums(string(content))
+	for _, archive := range manifest.Archives {
+		if archive.File != filepath.Base(archive.File) || archive.Folder == "" || strings.Contains(archive.Folder, "..") {
+			return nil, fmt.Errorf("invalid archive %s", archive.File)
+		}
+		info, err := os.Stat(filepath.Join(dir, archive.File))
+		if err != nil {
+			return nil, fmt.Errorf("missing archive %s", archive.File)
+		}
+		if info.Size() != archive.Size {
+			return nil, fmt.Errorf("archive %s is %d bytes, the manifest expects %d", archive.File, info.Size(), archive.Size)
+		}
+		if sums[archive.File] == "" || sums[archive.File] != archive.SHA256 {
+			return nil, fmt.Errorf("the checksum of archive %s does not match %s", archive.File, SnapshotChecksumFile)
+		}
+	}
+	return &manifest, nil
+}
+
+// restoreScript verifies the archives, then replaces the entries of their folders with their content. Every top
+// level entry of a folder found in its archive is removed first, so no stale file of the current data is left among
+// the restored ones. The kept entries of an archive, by archive file, are neither removed nor extracted.
+func restoreScript(archives []BackupArchive, keep map[string][]string, chown []string) string {
+	var script strings.Builder
+	script.WriteString("set -e -o pipefail\napk add $APK_FLAGS zstd tar coreutils\ncd /backup\n")
+	// everything is verified before the current data is touched
+	for _, archive := range archives {
+		fmt.Fprintf(&script, "echo %s | sha256sum -c -\n", shellQuote(archive.SHA256+"  "+archive.File))
+	}
+	for i, archive := range archives {
+		kept := []string{}
+		excludes := ""
+		for _, name := range keep[archive.File] {
+			kept = append(kept, shellQuote(name))
+			excludes += " --exclude=" + shellQuote(archive.Folder+"/"+name)
+		}
+		if len(kept) == 0 {
+			// a pattern no entry matches
+			kept = append(kept, "''")
+		}
+		fmt.Fprintf(&script, "echo \"Restoring [%d/%d] %s\"\n", i+1, len(archives), archive.File)
+		fmt.Fprintf(&script, "zstd -dc %s | tar -tf - | sed -n %s | sort -u > /tmp/entries\n", shellQuote(archive.File), shellQuote("s|^"+archive.Folder+"/\\([^/][^/]*\\).*|\\1|p"))
+		fmt.Fprintf(&script, "while read -r entry; do case \"$entry\" in %s) ;; *) rm -rf %s\"/$entry\" ;; esac; done < /tmp/entries\n", strings.Join(kept, "|"), shellQuote("/data/"+archive.Folder))
+		fmt.Fprintf(&script, "zstd -dc %s | tar -xf - -C /data%s\n", shellQuote(archive.File), excludes)
+	}
+	for _, folder := range chown {
+		fmt.Fprintf(&script, "chown -R \"$OWNER\" %s\n", shellQuote("/data/"+folder))
+	}
+	script.WriteString("echo \"Command succeeded\"\n")
+	return script.String()
+}
+
+// RunRestore verifies the archives of a backup and extracts them into the roots with a helper container, replacing
+// the entries of their folders but the kept ones, by archive file. The chown folders are given back to the host user.
+// Folders are relative to the roots, as for RunBackup.
+func RunRestore(roots map[string]string, source string, archives []BackupArchive, keep map[string][]string, chown []string) error {
+	mounts := append(dataMounts(roots, false), mount.Mount{Type: mount.TypeBind, Source: source, Target: "/backup", ReadOnly: true})
+	env := []string{fmt.Sprintf("OWNER=%d:%d", os.Getuid(), os.Getgid())}
+	_, err := RunHelperContainer("restore", restoreScript(archives, keep, chown), mounts, env, printProgress)
+	return err
+}
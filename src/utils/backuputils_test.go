@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestBackup(t *testing.T, archive BackupArchive, sums string) string {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "heimdall.tar.zst"), []byte("archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, SnapshotChecksumFile), []byte(sums), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteBackupManifest(dir, &BackupManifest{Network: "mainnet", Archives: []BackupArchive{archive}}); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestReadBackupManifest(t *testing.T) {
+	archive := BackupArchive{Component: "heimdall", File: "heimdall.tar.zst", Folder: "heimdall/data", Size: 7, SHA256: "abcd"}
+	dir := writeTestBackup(t, archive, "abcd  heimdall.tar.zst\n")
+	manifest, err := ReadBackupManifest(dir)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if manifest.Version != backupManifestVersion || manifest.Network != "mainnet" || len(manifest.Archives) != 1 {
+		t.Errorf("unexpected manifest %+v", manifest)
+	}
+
+	truncated := archive
+	truncated.Size = 8
+	if _, err := ReadBackupManifest(writeTestBackup(t, truncated, "abcd  heimdall.tar.zst\n")); err == nil {
+		t.Error("expected an error for an archive of another size")
+	}
+	if _, err := ReadBackupManifest(writeTestBackup(t, archive, "ef01  heimdall.tar.zst\n")); err == nil {
+		t.Error("expected an error for a checksum mismatch")
+	}
+	escaping := archive
+	escaping.Folder = "../../etc"
+	if _, err := ReadBackupManifest(writeTestBackup(t, escaping, "abcd  heimdall.tar.zst\n")); err == nil {
+		t.Error("expected an error for a folder outside of the data folder")
+	}
+}
+
+func TestBackupScript(t *testing.T) {
+	script := backupScript([]BackupFolder{
+		{Component: "heimdall", Folder: "heimdall/data"},
+		{Component: "execution", Folder: "erigon", Excludes: []string{"nodekey"}},
+	})
+	for _, expected := range []string{
+		"tar -cf - 'heimdall/data' | zstd -T0 -q -f -o /backup/'heimdall.tar.zst'",
+		"tar -cf - --exclude='nodekey' 'erigon' | zstd -T0 -q -f -o /backup/'erigon.tar.zst'",
+		"sha256sum 'heimdall.tar.zst' 'erigon.tar.zst' > SHA256SUMS",
+	} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("script does not contain %q:\n%s", expected, script)
+		}
+	}
+}
+
+func TestRestoreScript(t *testing.T) {
+	script := restoreScript([]BackupArchive{
+		{Component: "heimdall", File: "heimdall.tar.zst", Folder: "heimdall/data", SHA256: "abcd"},
+		{Component: "execution", File: "erigon.tar.zst", Folder: "erigon", SHA256: "ef01"},
+	}, map[string][]string{"heimdall.tar.zst": {"priv_validator_state.json"}, "erigon.tar.zst": {"nodekey", "nodes"}}, []string{"erigon"})
+	for _, expected := range []string{
+		"echo 'abcd  heimdall.tar.zst' | sha256sum -c -",
+		`sed -n 's|^heimdall/data/\([^/][^/]*\).*|\1|p'`,
+		`case "$entry" in 'priv_validator_state.json') ;; *) rm -rf '/data/heimdall/data'"/$entry" ;; esac`,
+		"tar -xf - -C /data --exclude='heimdall/data/priv_validator_state.json'",
+		`case "$entry" in 'nodekey'|'nodes') ;; *) rm -rf '/data/erigon'"/$entry" ;; esac`,
+		"tar -xf - -C /data --exclude='erigon/nodekey' --exclude='erigon/nodes'",
+		`chown -R "$OWNER" '/data/erigon'`,
+	} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("script does not contain %q:\n%s", expected, script)
+		}
+	}
+	// the archives are verified before any data is removed
+	if strings.LastIndex(script, "sha256sum -c") > strings.Index(script, "rm -rf") {
+		t.Errorf("data removed before the archives are verified:\n%s", script)
+	}
+}
@@ -24,13 +24,23 @@ import (
 
 // LoadAccountFromMnemonic loads an account from a mnemonic.
 func LoadAccountFromMnemonic(mnemonic string) error {
+	privateKeyHex, address, err := DeriveAccountFromMnemonic(mnemonic)
+	if err != nil {
+		return err
+	}
+	appstate.UpdateAccount(privateKeyHex, address)
+	return nil
+}
+
+// DeriveAccountFromMnemonic returns the private key and the address of the first account of a mnemonic.
+func DeriveAccountFromMnemonic(mnemonic string) (string, string, error) {
 	// Generate a binary seed from the mnemonic.
 	seed := bip39.NewSeed(mnemonic, "") // Second parameter is the optional passphrase.
 
 	// Generate a new master key from the seed.
 	masterKey, err := bip32.NewMasterKey(seed)
 	if err != nil {
-		return err
+		return "", "", err
 	}
 
 	// Derive the keys step by step according to the standard Ethereum derivation path m/44'/60'/0'/0/0
@@ -45,37 +55,37 @@ func LoadAccountFromMnemonic(mnemonic string) error {
 	// Deriving the purpose key
 	purposeKey, err := masterKey.NewChildKey(purpose)
 	if err != nil {
-		return err
+		return "", "", err
 	}
 
 	// Deriving the coin type key
 	coinTypeKey, err := purposeKey.NewChildKey(coinType)
 	if err != nil {
-		return err
+		return "", "", err
 	}
 
 	// Deriving the account key
 	accountKey, err := coinTypeKey.NewChildKey(account)
 	if err != nil {
-		return err
+		return "", "", err
 	}
 
 	// Deriving the change key
 	changeKey, err := accountKey.NewChildKey(change)
 	if err != nil {
-		return err
+		return "", "", err
 	}
 
 	// Deriving the address index key
 	childKey, err := changeKey.NewChildKey(addressIndex)
 	if err != nil {
-		return err
+		return "", "", err
 	}
 
 	// Get the ECDSA private key from the derived key
 	privateKeyECDSA, err := crypto.ToECDSA(childKey.Key)
 	if err != nil {
-		return err
+		return "", "", err
 	}
 	// Format the ECDSA private key as a hexadecimal string
 	privateKeyHex := hex.EncodeToString(privateKeyECDSA.D.Bytes())
@@ -90,8 +100,7 @@ func LoadAccountFromMnemonic(mnemonic string) error {
 
 	// The Ethereum address is the last 20 bytes of the hashed public key
 	address := crypto.PubkeyToAddress(*publicKeyECDSA.(*ecdsa.PublicKey))
-	appstate.UpdateAccount(privateKeyHex, address.String())
-	return nil
+	return privateKeyHex, address.String(), nil
 }
 
 // LoadAccountFromPrivateKey loads an account from a private key.
@@ -297,6 +306,16 @@ func (bc *BlockchainClient) ExecuteWriteFunction(privateKeyString, contractAddre
 	return signedTx.Hash(), nil
 }
 
+// ChainID returns the chain ID of the network the RPC is connected to.
+func (bc *BlockchainClient) ChainID() (*big.Int, error) {
+	return bc.client.ChainID(context.Background())
+}
+
+// SuggestGasPrice returns the gas price suggested by the RPC.
+func (bc *BlockchainClient) SuggestGasPrice() (*big.Int, error) {
+	return bc.client.SuggestGasPrice(context.Background())
+}
+
 // WaitForTransactionReceipt waits for the transaction with the given hash to be mined.
 func (bc *BlockchainClient) WaitForTransactionReceipt(txHash common.Hash) (*types.Receipt, error) {
 	for {
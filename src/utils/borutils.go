@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// BorClient runs bor as execution client
+type BorClient struct{}
+
+func (BorClient) Name() string               { return "bor" }
+func (BorClient) Image() string              { return "0xpolygon/bor:1.2.7" }
+func (BorClient) ContainerName() string      { return "bor" }
+func (BorClient) DataFolder() string         { return "bor" }
+func (BorClient) ContainerDataPath() string  { return "/bor-home" }
+func (BorClient) ChaindataFolders() []string { return []string{"bor/chaindata"} }
+func (BorClient) IdentityFiles() []string {
+	return []string{"nodekey", "nodes", "keystore", "password.txt", "config.toml"}
+}
+
+func (BorClient) Ports(options ExecutionClientOptions) []uint {
+	return []uint{30303, 8545}
+}
+
+// Args returns the bor flags for the given options.
+func (BorClient) Args(options ExecutionClientOptions) []string {
+	args := []string{"server", "--chain=" + options.Network.BorChain, "--datadir=/bor-home", "--bor.heimdall=http://heimdall-rest:1317", "--http", "--http.addr=0.0.0.0", "--http.vhosts=*", "--http.api=eth,net,web3,txpool,bor", "--port=30303"}
+	if options.ExternalIP != "" {
+		args = append(args, "--nat=extip:"+options.ExternalIP)
+	}
+	if options.SignerKey != nil {
+		// bor seals blocks with an unlocked keystore account
+		address := crypto.PubkeyToAddress(options.SignerKey.PublicKey).Hex()
+		args = append(args, "--mine", "--miner.etherbase="+address, "--unlock="+address, "--keystore=/bor-home/keystore", "--password=/bor-home/password.txt", "--allow-insecure-unlock")
+		if len(options.StaticPeers) > 0 {
+			// only peer with our sentries, bor only reads static nodes from its config file
+			args = append(args, "--config=/bor-home/config.toml", "--nodiscover")
+		}
+	}
+	return args
+}
+
+// Prepare writes the keystore bor seals blocks with and the static nodes of the sentries, validators only.
+func (BorClient) Prepare(hostDataPath string, options ExecutionClientOptions) error {
+	keystorePath := path.Join(hostDataPath, "keystore")
+	passwordPath := path.Join(hostDataPath, "password.txt")
+	configPath := path.Join(hostDataPath, "config.toml")
+
+	// the keystore is rebuilt on each start from the signer key of the encrypted store
+	if err := os.RemoveAll(keystorePath); err != nil {
+		return err
+	}
+	for _, file := range []string{passwordPath, configPath} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if options.SignerKey == nil {
+		return nil
+	}
+
+	passwordBytes := make([]byte, 32)
+	if _, err := rand.Read(passwordBytes); err != nil {
+		return err
+	}
+	password := hex.EncodeToString(passwordBytes)
+	if err := os.WriteFile(passwordPath, []byte(password), fs.FileMode(0600)); err != nil {
+		return err
+	}
+	ks := keystore.NewKeyStore(keystorePath, keystore.LightScryptN, keystore.LightScryptP)
+	if _, err := ks.ImportECDSA(options.SignerKey, password); err != nil {
+		return fmt.Errorf("error importing signer key: %v", err)
+	}
+
+	if len(options.StaticPeers) > 0 {
+		config := "[p2p]\n  [p2p.discovery]\n    static-nodes = [" + quoteTomlList(options.StaticPeers) + "]\n"
+		if err := os.WriteFile(configPath, []byte(config), fs.FileMode(0644)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quoteTomlList formats a list of strings as the content of a toml array.
+func quoteTomlList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = TomlString(value)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// SyncingStatus returns the sync status reported by the standard eth_syncing of bor.
+func (BorClient) SyncingStatus() (*SyncingStatus, error) {
+	return GetBorSyncingStatus()
+}
+
+// GetBorSyncingStatus performs a request and returns the node status or an error.
+func GetBorSyncingStatus() (*SyncingStatus, error) {
+	jsonBody, err := json.Marshal(RequestBody{Jsonrpc: "2.0", Method: "eth_syncing", Params: []interface{}{}, ID: 1})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request body: %v", err)
+	}
+
+	resp, err := http.Post("http://localhost:8545/", "application/json", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.Reader(resp.Body))
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %v", err)
+	}
+
+	var response ResponseBody
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		// bor returns false once synced
+		var syncing ResponseBodyNotSyncing
+		if err := json.Unmarshal(respBody, &syncing); err != nil || syncing.Result {
+			return nil, fmt.Errorf("error unmarshalling response body: %s", string(respBody))
+		}
+		return &SyncingStatus{Progress: 100.0, Stage: "Synced"}, nil
+	}
+
+	status := SyncingStatus{
+		HighestBlock: parseHexUint64(response.Result.HighestBlock),
+		CurrentBlock: parseHexUint64(response.Result.CurrentBlock),
+		ETASeconds:   -1,
+	}
+	if status.HighestBlock == 0 {
+		status.Stage = "Waiting for Heimdall sync"
+		return &status, nil
+	}
+	status.Progress = float32(float64(status.CurrentBlock) / float64(status.HighestBlock) * 100)
+	status.Stage = fmt.Sprintf("Syncing block %d/%d", status.CurrentBlock, status.HighestBlock)
+	applySyncRate(&status, status.CurrentBlock)
+	return &status, nil
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CgroupRoot is where the cgroup hierarchies are mounted on Linux hosts
+const CgroupRoot = "/sys/fs/cgroup"
+
+// cgroup v1 reports no memory limit as the largest page aligned int64, anything above is unlimited
+const unlimitedMemory = 1 << 62
+
+// CgroupLimits are the CPU and memory limits of a cgroup, 0 when unlimited
+type CgroupLimits struct {
+	CPUs   float64
+	Memory uint64
+}
+
+// DockerCgroupParent returns the cgroup the containers are created in for a docker cgroup driver
+func DockerCgroupParent(driver string) string {
+	if driver == "systemd" {
+		return "/system.slice"
+	}
+	return "/docker"
+}
+
+// parseCPUMax parses the cpu.max file of cgroup v2, "<quota> <period>" or "max <period>" when unlimited
+func parseCPUMax(content string) float64 {
+	fields := strings.Fields(content)
+	if len(fields) != 2 {
+		return 0
+	}
+	return parseCPUQuota(fields[0], fields[1])
+}
+
+// parseCPUQuota divides a quota by its period, the quota is max in cgroup v2 and -1 in cgroup v1 when unlimited
+func parseCPUQuota(quota string, period string) float64 {
+	quotaValue, err := strconv.ParseFloat(strings.TrimSpace(quota), 64)
+	if err != nil || quotaValue <= 0 {
+		return 0
+	}
+	periodValue, err := strconv.ParseFloat(strings.TrimSpace(period), 64)
+	if err != nil || periodValue <= 0 {
+		return 0
+	}
+	return quotaValue / periodValue
+}
+
+// parseMemoryLimit parses memory.max of cgroup v2 or memory.limit_in_bytes of cgroup v1
+func parseMemoryLimit(content string) uint64 {
+	limit, err := strconv.ParseUint(strings.TrimSpace(content), 10, 64)
+	if err != nil || limit >= unlimitedMemory {
+		return 0
+	}
+	return limit
+}
+
+// readCgroupFile returns the content of a cgroup file, empty if it does not exist
+func readCgroupFile(file string) string {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	return string(content)
+}
+
+// ReadCgroupLimits returns the tightest limits of a cgroup and of its parents, in the hierarchies of the cgroup
+// version mounted in root. Missing files, on hosts without cgroups for instance, mean no limit.
+func ReadCgroupLimits(root string, version string, cgroup string) CgroupLimits {
+	limits := CgroupLimits{}
+	for current := path.Clean("/" + cgroup); ; current = path.Dir(current) {
+		var cpus float64
+		var memory uint64
+		if version == "1" {
+			cpus = parseCPUQuota(readCgroupFile(filepath.Join(root, "cpu", current, "cpu.cfs_quota_us")), readCgroupFile(filepath.Join(root, "cpu", current, "cpu.cfs_period_us")))
+			memory = parseMemoryLimit(readCgroupFile(filepath.Join(root, "memory", current, "memory.limit_in_bytes")))
+		} else {
+			cpus = parseCPUMax(readCgroupFile(filepath.Join(root, current, "cpu.max")))
+			memory = parseMemoryLimit(readCgroupFile(filepath.Join(root, current, "memory.max")))
+		}
+		if cpus > 0 && (limits.CPUs == 0 || cpus < limits.CPUs) {
+			limits.CPUs = cpus
+		}
+		if memory > 0 && (limits.Memory == 0 || memory < limits.Memory) {
+			limits.Memory = memory
+		}
+		if current == "/" {
+			return limits
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeCgroupFile(t *testing.T, file string, content string) {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadCgroupLimitsV2(t *testing.T) {
+	root := t.TempDir()
+	writeCgroupFile(t, filepath.Join(root, "system.slice", "cpu.max"), "max 100000\n")
+	writeCgroupFile(t, filepath.Join(root, "system.slice", "memory.max"), "8000000000\n")
+	writeCgroupFile(t, filepath.Join(root, "system.slice", "docker.service", "cpu.max"), "200000 100000\n")
+	writeCgroupFile(t, filepath.Join(root, "system.slice", "docker.service", "memory.max"), "max\n")
+
+	limits := ReadCgroupLimits(root, "2", "/system.slice/docker.service")
+	if limits.CPUs != 2 || limits.Memory != 8000000000 {
+		t.Errorf("unexpected limits %+v", limits)
+	}
+	if limits := ReadCgroupLimits(root, "2", DockerCgroupParent("cgroupfs")); limits.CPUs != 0 || limits.Memory != 0 {
+		t.Errorf("expected no limits, got %+v", limits)
+	}
+}
+
+func TestReadCgroupLimitsV1(t *testing.T) {
+	root := t.TempDir()
+	writeCgroupFile(t, filepath.Join(root, "cpu", "docker", "cpu.cfs_quota_us"), "-1\n")
+	writeCgroupFile(t, filepath.Join(root, "cpu", "docker", "cpu.cfs_period_us"), "100000\n")
+	writeCgroupFile(t, filepath.Join(root, "memory", "docker", "memory.limit_in_bytes"), "9223372036854771712\n")
+	if limits := ReadCgroupLimits(root, "1", DockerCgroupParent("cgroupfs")); limits.CPUs != 0 || limits.Memory != 0 {
+		t.Errorf("expected no limits, got %+v", limits)
+	}
+
+	writeCgroupFile(t, filepath.Join(root, "cpu", "docker", "cpu.cfs_quota_us"), "150000\n")
+	writeCgroupFile(t, filepath.Join(root, "memory", "docker", "memory.limit_in_bytes"), "4294967296\n")
+	limits := ReadCgroupLimits(root, "1", "/docker")
+	if limits.CPUs != 1.5 || limits.Memory != 4294967296 {
+		t.Errorf("unexpected limits %+v", limits)
+	}
+}
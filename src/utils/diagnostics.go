@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Components whose logs are diagnosed, the execution component is erigon or bor
+const (
+	ComponentHeimdall     = "heimdall"
+	ComponentHeimdallRest = "heimdall-rest"
+	ComponentExecution    = "execution"
+)
+
+// maxEvidenceLines is how many of the latest matching lines are reported for a finding
+const maxEvidenceLines = 5
+
+// maxEvidenceLength truncates long log lines such as stack traces
+const maxEvidenceLength = 300
+
+// DiagnosticRule is the signature of a known failure in the logs
+type DiagnosticRule struct {
+	ID          string
+	Title       string
+	Severity    string // critical, warning or info
+	Components  []string
+	Patterns    []*regexp.Regexp
+	MinMatches  int // signatures also seen on healthy nodes need several matches
+	Remediation string
+}
+
+// DiagnosticFinding is a known failure found in the logs of a component
+type DiagnosticFinding struct {
+	Rule        string   `json:"rule"`
+	Title       string   `json:"title"`
+	Severity    string   `json:"severity"`
+	Component   string   `json:"component"`
+	Occurrences int      `json:"occurrences"`
+	Evidence    []string `json:"evidence"`
+	Remediation string   `json:"remediation"`
+}
+
+var severityOrder = map[string]int{"critical": 0, "warning": 1, "info": 2}
+
+// DiagnosticRules are the known failures looked for by Diagnose
+var DiagnosticRules = []DiagnosticRule{
+	{
+		ID:         "l1_rpc_unreachable",
+		Title:      "Ethereum L1 RPC is unreachable or refusing requests",
+		Severity:   "critical",
+		Components: []string{ComponentHeimdall},
+		Patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)(mainchain|eth_rpc_url|rootchain).*(error|refused|timeout|no such host|unauthorized|forbidden|429|too many requests)`),
+			regexp.MustCompile(`(?i)error while fetching mainchain`),
+		},
+		MinMatches:  1,
+		Remediation: "Check that the Ethereum RPC set with the rpc task answers from this host and is not rate limited, then restart heimdall.",
+	},
+	{
+		ID:         "bor_rpc_unreachable",
+		Title:      "Heimdall cannot reach the execution client through bor_rpc_url",
+		Severity:   "critical",
+		Components: []string{ComponentHeimdall},
+		Patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)(bor_rpc_url|borchain|maticchain|bor chain).*(error|refused|timeout|no such host)`),
+			regexp.MustCompile(`(?i)dial tcp: lookup (erigon|bor)\b.*no such host`),
+			regexp.MustCompile(`(?i)dial tcp [0-9.]+:8545: connect: connection refused`),
+		},
+		MinMatches:  1,
+		Remediation: "Check that the execution client container is running and that bor_rpc_url in heimdall-config.toml points to it on port 8545, then restart heimdall.",
+	},
+	{
+		ID:         "heimdall_rest_unreachable",
+		Title:      "The execution client cannot reach the heimdall rest server",
+		Severity:   "critical",
+		Components: []string{ComponentExecution},
+		Patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)(heimdall|span|checkpoint|milestone).*(connection refused|no such host|context deadline exceeded)`),
+		},
+		MinMatches:  2,
+		Remediation: "Check that the heimdall-rest container is running and answers on port 1317, it needs heimdall to be synced to serve spans.",
+	},
+	{
+		ID:         "database_lock",
+		Title:      "The database is locked by another process",
+		Severity:   "critical",
+		Components: []string{ComponentHeimdall, ComponentExecution},
+		Patterns: []*regexp.Regexp{
+			// EAGAIN of a lock file only, sockets and DNS lookups report it too
+			regexp.MustCompile(`(?i)(\bLOCK\b|\.lock\b|mdbx|lmdb|leveldb|flock)[^\n]*resource temporarily unavailable`),
+			regexp.MustCompile(`(?i)(database|datadir|db) (is )?(locked|already in use)`),
+			regexp.MustCompile(`(?i)(failed to|could not) (acquire|obtain|get) [^\n]*\b(file )?lock\b`),
+		},
+		MinMatches:  1,
+		Remediation: "Another container uses the same data folder. Stop the node, check that no leftover heimdall, erigon or bor container is running, then start it again.",
+	},
+	{
+		ID:         "disk_full",
+		Title:      "The disk is full",
+		Severity:   "critical",
+		Components: []string{ComponentHeimdall, ComponentHeimdallRest, ComponentExecution},
+		Patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)no space left on device`),
+			regexp.MustCompile(`MDBX_MAP_FULL|ENOSPC`),
+		},
+		MinMatches:  1,
+		Remediation: "Free disk space or move the data to a bigger disk. A pruned erigon profile needs much less space than an archive node.",
+	},
+	{
+		ID:         "snapshot_torrent_stall",
+		Title:      "The snapshot torrent download is stalled",
+		Severity:   "warning",
+		Components: []string{ComponentExecution},
+		Patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)snapshots.*download.*(peers=0\b|download=0(\.0+)?B/s|rate=0(\.0+)?B/s)`),
+		},
+		MinMatches:  3,
+		Remediation: "Check that the host can reach torrent peers on port 42069 and that the torrent download rate is not set too low.",
+	},
+	{
+		ID:         "no_peers",
+		Title:      "The node has no peers",
+		Severity:   "warning",
+		Components: []string{ComponentHeimdall, ComponentExecution},
+		Patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)peercount=0\b`),
+			regexp.MustCompile(`(?i)GoodPeers(\s+\w+=0)+\s*$`),
+			regexp.MustCompile(`(?i)"?num_?peers"?\s*[=:]\s*"?0\b`),
+			regexp.MustCompile(`(?i)\bno peers\b`),
+		},
+		MinMatches:  3,
+		Remediation: "Open the P2P ports (26656 for heimdall, 30303 TCP and UDP for the execution client) and check the external IP detected for the node.",
+	},
+}
+
+// Diagnose matches the logs of each component against the known failures, findings are sorted by severity.
+func Diagnose(logs map[string]string) []DiagnosticFinding {
+	findings := []DiagnosticFinding{}
+	for _, rule := range DiagnosticRules {
+		for _, component := range rule.Components {
+			content, exists := logs[component]
+			if !exists {
+				continue
+			}
+			var matches []string
+			for _, line := range strings.Split(content, "\n") {
+				line = strings.TrimSpace(line)
+				for _, pattern := range rule.Patterns {
+					if pattern.MatchString(line) {
+						matches = append(matches, line)
+						break
+					}
+				}
+			}
+			minMatches := rule.MinMatches
+			if minMatches < 1 {
+				minMatches = 1
+			}
+			if len(matches) < minMatches {
+				continue
+			}
+
+			evidence := matches
+			if len(evidence) > maxEvidenceLines {
+				evidence = evidence[len(evidence)-maxEvidenceLines:]
+			}
+			for i, line := range evidence {
+				if len(line) > maxEvidenceLength {
+					evidence[i] = line[:maxEvidenceLength] + "..."
+				}
+			}
+			findings = append(findings, DiagnosticFinding{
+				Rule:        rule.ID,
+				Title:       rule.Title,
+				Severity:    rule.Severity,
+				Component:   component,
+				Occurrences: len(matches),
+				Evidence:    evidence,
+				Remediation: rule.Remediation,
+			})
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		return severityOrder[findings[i].Severity] < severityOrder[findings[j].Severity]
+	})
+	return findings
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiagnose(t *testing.T) {
+	logs := map[string]string{
+		ComponentHeimdall: strings.Join([]string{
+			`I[2024-01-10|10:00:00.000] Executed block module=state height=100 validTxs=0 invalidTxs=0`,
+			`E[2024-01-10|10:00:01.000] Error while fetching mainchain header module=checkpoint error="429 Too Many Requests"`,
+			`E[2024-01-10|10:00:02.000] Error while fetching block module=bor error="dial tcp: lookup erigon on 127.0.0.11:53: no such host"`,
+		}, "\n"),
+		ComponentExecution: strings.Join([]string{
+			`[INFO] [01-10|10:00:00.000] [p2p] GoodPeers eth68=0 eth67=0`,
+			`[INFO] [01-10|10:00:30.000] [p2p] GoodPeers eth68=0 eth67=0`,
+			`[EROR] [01-10|10:00:45.000] mdbx write failed err="no space left on device"`,
+		}, "\n"),
+	}
+
+	findings := Diagnose(logs)
+	found := make(map[string]DiagnosticFinding)
+	for _, finding := range findings {
+		found[finding.Rule+"/"+finding.Component] = finding
+	}
+	for _, expected := range []string{"l1_rpc_unreachable/heimdall", "bor_rpc_unreachable/heimdall", "disk_full/execution"} {
+		if _, exists := found[expected]; !exists {
+			t.Errorf("expected finding %s, got %+v", expected, findings)
+		}
+	}
+	if _, exists := found["no_peers/execution"]; exists {
+		t.Error("no_peers needs 3 matches")
+	}
+	if findings[len(findings)-1].Severity != "critical" {
+		t.Errorf("unexpected severities %+v", findings)
+	}
+	if evidence := found["disk_full/execution"].Evidence; len(evidence) != 1 || !strings.Contains(evidence[0], "no space left") {
+		t.Errorf("unexpected evidence %v", evidence)
+	}
+
+	logs[ComponentExecution] += "\n" + `[INFO] [01-10|10:01:00.000] [p2p] GoodPeers eth68=0 eth67=0`
+	findings = Diagnose(logs)
+	if findings[len(findings)-1].Rule != "no_peers" || findings[len(findings)-1].Occurrences != 3 {
+		t.Errorf("expected no_peers last, got %+v", findings)
+	}
+}
+
+func TestDiagnoseDatabaseLock(t *testing.T) {
+	healthy := []string{
+		`[WARN] [01-10|10:00:00.000] [rpc] read failed err="read tcp 172.18.0.3:8545: resource temporarily unavailable"`,
+		`E[2024-01-10|10:00:01.000] Error while fetching block module=bor error="lookup erigon: resource temporarily unavailable"`,
+		`E[2024-01-10|10:00:02.000] Failed to get block module=bor height=100`,
+	}
+	locked := []string{
+		`Fatal: Failed to open database: open /erigon/chaindata/LOCK: resource temporarily unavailable`,
+		`panic: leveldb: open /heimdall-home/data/application.db/LOCK: resource temporarily unavailable`,
+		`[EROR] mdbx_env_open: resource temporarily unavailable`,
+	}
+	for _, line := range healthy {
+		for _, finding := range Diagnose(map[string]string{ComponentExecution: line}) {
+			if finding.Rule == "database_lock" {
+				t.Errorf("unexpected database_lock finding for %s", line)
+			}
+		}
+	}
+	for _, line := range locked {
+		found := false
+		for _, finding := range Diagnose(map[string]string{ComponentExecution: line}) {
+			found = found || finding.Rule == "database_lock"
+		}
+		if !found {
+			t.Errorf("expected a database_lock finding for %s", line)
+		}
+	}
+}
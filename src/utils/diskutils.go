@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// DiskUsage is the usage of the filesystem holding a path
+type DiskUsage struct {
+	Path  string `json:"path"`
+	Total uint64 `json:"total"`
+	Free  uint64 `json:"free"` // available to the plugin, reserved blocks excluded
+	Used  uint64 `json:"used"`
+	// identifies the filesystem, paths with the same device share its space
+	Device string `json:"-"`
+}
+
+// GetDiskUsage returns the usage of the filesystem holding the given path.
+func GetDiskUsage(path string) (*DiskUsage, error) {
+	total, free, err := diskSpace(path)
+	if err != nil {
+		return nil, err
+	}
+	device, err := diskDevice(path)
+	if err != nil {
+		return nil, err
+	}
+	usage := &DiskUsage{Path: path, Total: total, Free: free, Device: device}
+	if total > free {
+		usage.Used = total - free
+	}
+	return usage, nil
+}
+
+// FolderSize returns the size of the files of a folder, 0 if it does not exist.
+// Files written by containers may not be readable, they are skipped.
+func FolderSize(path string) (uint64, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return 0, nil
+	}
+	var size uint64
+	err := filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if entry != nil && entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err == nil {
+				size += uint64(info.Size())
+			}
+		}
+		return nil
+	})
+	return size, err
+}
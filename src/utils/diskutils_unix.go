@@ -0,0 +1,26 @@
+//go:build !windows
+
+package utils
+
+import (
+	"strconv"
+	"syscall"
+)
+
+// diskSpace returns the total and available bytes of the filesystem holding path.
+func diskSpace(path string) (uint64, uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	return uint64(stat.Blocks) * uint64(stat.Bsize), uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+// diskDevice returns the ID of the device holding path.
+func diskDevice(path string) (string, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(uint64(stat.Dev), 10), nil
+}
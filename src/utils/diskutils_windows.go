@@ -0,0 +1,42 @@
+//go:build windows
+
+package utils
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32           = syscall.NewLazyDLL("kernel32.dll")
+	getDiskFreeSpaceEx = kernel32.NewProc("GetDiskFreeSpaceExW")
+	getVolumePathNameW = kernel32.NewProc("GetVolumePathNameW")
+)
+
+// diskSpace returns the total and available bytes of the volume holding path.
+func diskSpace(path string) (uint64, uint64, error) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	var free, total, totalFree uint64
+	result, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(pathPtr)), uintptr(unsafe.Pointer(&free)), uintptr(unsafe.Pointer(&total)), uintptr(unsafe.Pointer(&totalFree)))
+	if result == 0 {
+		return 0, 0, err
+	}
+	return total, free, nil
+}
+
+// diskDevice returns the mount point of the volume holding path.
+func diskDevice(path string) (string, error) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return "", err
+	}
+	volume := make([]uint16, syscall.MAX_PATH+1)
+	result, _, err := getVolumePathNameW.Call(uintptr(unsafe.Pointer(pathPtr)), uintptr(unsafe.Pointer(&volume[0])), uintptr(len(volume)))
+	if result == 0 {
+		return "", err
+	}
+	return syscall.UTF16ToString(volume), nil
+}
@@ -1,13 +1,17 @@
 package utils
 
 import (
+	"KeepixPlugin/appstate"
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
@@ -17,6 +21,26 @@ import (
 	"github.com/docker/go-connections/nat"
 )
 
+// DefaultHelperImage is the image used for short lived maintenance containers
+const DefaultHelperImage = "alpine:latest"
+
+// HelperImage returns the image of the maintenance containers, an offline node may ship its own
+func HelperImage() string {
+	if appstate.CurrentState.Offline.HelperImage != "" {
+		return appstate.CurrentState.Offline.HelperImage
+	}
+	return DefaultHelperImage
+}
+
+// helperEnv completes the environment of a maintenance container. Offline, apk only checks the packages the scripts
+// add are already installed in the helper image.
+func helperEnv(env []string) []string {
+	if IsOffline() {
+		return append(env, "APK_FLAGS=--no-network")
+	}
+	return env
+}
+
 func CheckDockerExists() bool {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
@@ -28,7 +52,69 @@ func CheckDockerExists() bool {
 	return err == nil
 }
 
+// GetDockerInfo returns the information of the docker daemon.
+func GetDockerInfo() (types.Info, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return types.Info{}, fmt.Errorf("error creating Docker client: %v", err)
+	}
+	defer cli.Close()
+
+	return cli.Info(context.Background())
+}
+
+// ImageInfo identifies the exact build of a local image
+type ImageInfo struct {
+	Image       string   `json:"image"`
+	Exists      bool     `json:"exists"`
+	ID          string   `json:"id"`
+	RepoDigests []string `json:"repoDigests"`
+}
+
+// InspectImage returns the ID and digests of a local image, Exists is false if it was not pulled.
+func InspectImage(imageName string) (*ImageInfo, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("error creating Docker client: %v", err)
+	}
+	defer cli.Close()
+
+	inspect, _, err := cli.ImageInspectWithRaw(context.Background(), imageName)
+	if client.IsErrNotFound(err) {
+		return &ImageInfo{Image: imageName}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error inspecting image: %v", err)
+	}
+	return &ImageInfo{Image: imageName, Exists: true, ID: inspect.ID, RepoDigests: inspect.RepoDigests}, nil
+}
+
+// p2pPorts are published on all interfaces whatever the exposure, peers have to reach them
+var p2pPorts = map[uint]bool{26656: true, 30303: true, 30304: true, 42069: true}
+
+// udpPorts are also published on UDP, for the devp2p discovery and the torrent of the erigon snapshots
+var udpPorts = map[uint]bool{30303: true, 30304: true, 42069: true}
+
+// IsP2PPort returns whether peers have to reach a port
+func IsP2PPort(port uint) bool {
+	return p2pPorts[port]
+}
+
+// PublishesUDP returns whether a port is published on UDP as well as on TCP
+func PublishesUDP(port uint) bool {
+	return udpPorts[port]
+}
+
+// portHostIP returns the host address a port is published on, API ports stay on the loopback when exposure is local
+func portHostIP(port uint) string {
+	if !p2pPorts[port] && appstate.CurrentState.Exposure.APIExposure == "local" {
+		return "127.0.0.1"
+	}
+	return "0.0.0.0"
+}
+
 // DockerRun runs a Docker container and captures its output or if container is async, return container id.
+// An empty hostPath mounts nothing.
 func DockerRun(imageName string, args []string, containerPath, hostPath string, openPorts []uint, autorestart bool, networkName string, async bool, name string, sameUser bool) (string, error) {
 	ctx := context.Background()
 	cli, err := client.NewClientWithOpts(client.FromEnv)
@@ -42,8 +128,14 @@ func DockerRun(imageName string, args []string, containerPath, hostPath string,
 	exposedPorts := nat.PortSet{}
 	for _, port := range openPorts {
 		portStr := strconv.FormatUint(uint64(port), 10)
-		portBindings[nat.Port(portStr+"/tcp")] = []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: portStr}}
-		exposedPorts[nat.Port(portStr+"/tcp")] = struct{}{}
+		protocols := []string{"tcp"}
+		if PublishesUDP(port) {
+			protocols = append(protocols, "udp")
+		}
+		for _, protocol := range protocols {
+			portBindings[nat.Port(portStr+"/"+protocol)] = []nat.PortBinding{{HostIP: portHostIP(port), HostPort: portStr}}
+			exposedPorts[nat.Port(portStr+"/"+protocol)] = struct{}{}
+		}
 	}
 
 	// Container configuration
@@ -59,15 +151,15 @@ func DockerRun(imageName string, args []string, containerPath, hostPath string,
 	}
 
 	// Host configuration
-	hostConfig := &container.HostConfig{
-		PortBindings: portBindings,
-		Mounts: []mount.Mount{
+	hostConfig := &container.HostConfig{PortBindings: portBindings}
+	if hostPath != "" {
+		hostConfig.Mounts = []mount.Mount{
 			{
 				Type:   mount.TypeBind,
 				Source: hostPath,
 				Target: containerPath,
 			},
-		},
+		}
 	}
 	if autorestart {
 		hostConfig.RestartPolicy = container.RestartPolicy{Name: "unless-stopped"}
@@ -123,7 +215,7 @@ func DockerRun(imageName string, args []string, containerPath, hostPath string,
 
 // RemoveHostFolderUsingContainer removes a folder on the host machine using a Docker container.
 func RemoveHostFolderUsingContainer(containerPath, hostPath string, folders string) error {
-	err := PullImage("alpine:latest")
+	err := EnsureImage(HelperImage())
 	if err != nil {
 		return err
 	}
@@ -136,7 +228,7 @@ func RemoveHostFolderUsingContainer(containerPath, hostPath string, folders stri
 
 	// Define configuration for a temporary container
 	tempContainerConfig := container.Config{
-		Image: "alpine:latest",
+		Image: HelperImage(),
 		Cmd:   []string{"sh", "-c", "rm -rf " + folders},
 	}
 
@@ -171,11 +263,258 @@ func RemoveHostFolderUsingContainer(containerPath, hostPath string, folders stri
 		return fmt.Errorf("error removing temporary container: %v", err)
 	}
 
-	err = RemoveImageIfExists("alpine:latest")
+	return RemoveHelperImageIfUnused()
+}
+
+// printProgress prints the progress of a helper container
+func printProgress(line string) {
+	fmt.Println(line)
+}
+
+// RunHelperContainer runs a command in a helper container and waits for it, the logs are returned and the container
+// removed. It fails when the command exits with an error, with the last lines of the logs. When progress is not nil,
+// it is given the last line of the logs every 30 seconds.
+func RunHelperContainer(name string, command string, mounts []mount.Mount, env []string, progress func(string)) (string, error) {
+	err := EnsureImage(HelperImage())
 	if err != nil {
-		return err
+		return "", err
+	}
+	ctx := context.Background()
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return "", fmt.Errorf("error creating Docker client: %v", err)
+	}
+	defer cli.Close()
+
+	_ = RemoveContainerIfExists(name) // clear any previous interrupted run
+	tempContainerConfig := container.Config{
+		Image: HelperImage(),
+		Cmd:   []string{"sh", "-c", command},
+		Env:   helperEnv(env),
+	}
+	hostConfig := container.HostConfig{Mounts: mounts}
+	resp, err := cli.ContainerCreate(ctx, &tempContainerConfig, &hostConfig, nil, nil, name)
+	if err != nil {
+		return "", fmt.Errorf("error creating %s container: %v", name, err)
+	}
+	defer func() {
+		cli.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{Force: true})
+		RemoveHelperImageIfUnused()
+	}()
+
+	if err := cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return "", fmt.Errorf("error starting %s container: %v", name, err)
+	}
+	var exitCode int64
+	statusCh, errCh := cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+wait:
+	for {
+		select {
+		case err := <-errCh:
+			if err != nil {
+				return "", fmt.Errorf("error while waiting for %s container to finish: %v", name, err)
+			}
+			break wait
+		case status := <-statusCh:
+			exitCode = status.StatusCode
+			break wait
+		case <-ticker.C:
+			if progress == nil {
+				continue
+			}
+			if logs, err := FetchContainerLogs(resp.ID, 1); err == nil && strings.TrimSpace(logs) != "" {
+				// progress meters rewrite their line with carriage returns
+				segments := strings.Split(strings.TrimSpace(logs), "\r")
+				progress(strings.TrimSpace(segments[len(segments)-1]))
+			}
+		}
+	}
+
+	logs, err := FetchContainerLogs(resp.ID, 1000)
+	if err != nil {
+		return "", err
+	}
+	if exitCode != 0 {
+		lines := strings.Split(strings.TrimSpace(logs), "\n")
+		if len(lines) > 10 {
+			lines = lines[len(lines)-10:]
+		}
+		return logs, fmt.Errorf("%s exited with code %d: %s", name, exitCode, strings.Join(lines, "\n"))
+	}
+	return logs, nil
+}
+
+// RemoveHelperImageIfUnused removes the helper image once no other container, like a snapshot downloader, still relies on it.
+// Offline nodes keep it, it could not be pulled again.
+func RemoveHelperImageIfUnused() error {
+	if IsOffline() {
+		return nil
+	}
+	ctx := context.Background()
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return fmt.Errorf("error creating Docker client: %v", err)
 	}
+	defer cli.Close()
+
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		return fmt.Errorf("error listing containers: %v", err)
+	}
+	for _, container := range containers {
+		if container.Image == HelperImage() {
+			return nil // still in use
+		}
+	}
+	return RemoveImageIfExists(HelperImage())
+}
+
+// ContainerInfo is the runtime information of a container
+type ContainerInfo struct {
+	Exists       bool
+	Running      bool
+	Restarting   bool
+	RestartCount int
+	StartedAt    string
+	Image        string
+}
+
+// InspectContainer returns the runtime information of a container by its name, Exists is false if there is none.
+func InspectContainer(containerName string) (*ContainerInfo, error) {
+	ctx := context.Background()
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("error creating Docker client: %v", err)
+	}
+	defer cli.Close()
+
+	inspect, err := cli.ContainerInspect(ctx, containerName)
+	if client.IsErrNotFound(err) {
+		return &ContainerInfo{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error inspecting container: %v", err)
+	}
+	info := &ContainerInfo{Exists: true, RestartCount: inspect.RestartCount}
+	if inspect.Config != nil {
+		info.Image = inspect.Config.Image
+	}
+	if inspect.State != nil {
+		info.Running = inspect.State.Running
+		info.Restarting = inspect.State.Restarting
+		info.StartedAt = inspect.State.StartedAt
+	}
+	return info, nil
+}
+
+// ContainerStats is the resource usage of a running container
+type ContainerStats struct {
+	CPUPercent    float64 `json:"cpuPercent"`
+	MemoryUsage   uint64  `json:"memoryUsage"`
+	MemoryLimit   uint64  `json:"memoryLimit"`
+	MemoryPercent float64 `json:"memoryPercent"`
+	NetworkRx     uint64  `json:"networkRx"`
+	NetworkTx     uint64  `json:"networkTx"`
+	BlockRead     uint64  `json:"blockRead"`
+	BlockWrite    uint64  `json:"blockWrite"`
+}
+
+// computeContainerStats computes the resource usage the way the docker CLI does.
+func computeContainerStats(stats *types.StatsJSON) ContainerStats {
+	result := ContainerStats{MemoryLimit: stats.MemoryStats.Limit}
+
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	onlineCPUs := float64(stats.CPUStats.OnlineCPUs)
+	if onlineCPUs == 0 {
+		onlineCPUs = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		result.CPUPercent = cpuDelta / systemDelta * onlineCPUs * 100
+	}
+
+	// the page cache is not counted, it is reclaimed under pressure
+	result.MemoryUsage = stats.MemoryStats.Usage
+	cache, exists := stats.MemoryStats.Stats["total_inactive_file"] // cgroup v1
+	if !exists {
+		cache = stats.MemoryStats.Stats["inactive_file"] // cgroup v2
+	}
+	if cache < result.MemoryUsage {
+		result.MemoryUsage -= cache
+	}
+	if result.MemoryLimit > 0 {
+		result.MemoryPercent = float64(result.MemoryUsage) / float64(result.MemoryLimit) * 100
+	}
+
+	for _, network := range stats.Networks {
+		result.NetworkRx += network.RxBytes
+		result.NetworkTx += network.TxBytes
+	}
+	for _, entry := range stats.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			result.BlockRead += entry.Value
+		case "write":
+			result.BlockWrite += entry.Value
+		}
+	}
+	return result
+}
 
+// GetContainerStats returns the resource usage of a running container by its name.
+func GetContainerStats(containerName string) (*ContainerStats, error) {
+	ctx := context.Background()
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("error creating Docker client: %v", err)
+	}
+	defer cli.Close()
+
+	// without streaming, docker samples twice so the CPU usage can be computed
+	resp, err := cli.ContainerStats(ctx, containerName, false)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching container stats: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var stats types.StatsJSON
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("error decoding container stats: %v", err)
+	}
+	result := computeContainerStats(&stats)
+	return &result, nil
+}
+
+// RestartContainer restarts a container by its name, keeping its configuration.
+func RestartContainer(containerName string) error {
+	ctx := context.Background()
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return fmt.Errorf("error creating Docker client: %v", err)
+	}
+	defer cli.Close()
+
+	if err := cli.ContainerRestart(ctx, containerName, container.StopOptions{}); err != nil {
+		return fmt.Errorf("error restarting container: %v", err)
+	}
+	return nil
+}
+
+// RemoveContainerIfExists removes a container by its name, whether it is running or not.
+func RemoveContainerIfExists(containerName string) error {
+	ctx := context.Background()
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return fmt.Errorf("error creating Docker client: %v", err)
+	}
+	defer cli.Close()
+
+	err = cli.ContainerRemove(ctx, containerName, types.ContainerRemoveOptions{Force: true})
+	if err != nil && !client.IsErrNotFound(err) {
+		return fmt.Errorf("error removing container: %v", err)
+	}
 	return nil
 }
 
@@ -254,6 +593,22 @@ func FetchContainerLogs(containerID string, numberOfLastLines int) (string, erro
 	return processDockerOutput(logBuffer.Bytes()), nil
 }
 
+// EnsureImage makes an image available, pulled or loaded from the local archives of an offline node
+func EnsureImage(imageName string) error {
+	if !IsOffline() {
+		return PullImage(imageName)
+	}
+	info, err := InspectImage(imageName)
+	if err != nil {
+		return err
+	}
+	if info.Exists {
+		// loaded earlier or by hand, it still has to be the image of the archives
+		return VerifyLoadedImage(appstate.CurrentState.Offline.ImagesPath, info)
+	}
+	return LoadImageFromArchives(appstate.CurrentState.Offline.ImagesPath, imageName)
+}
+
 func PullImage(imageName string) error {
 	ctx := context.Background()
 	cli, err := client.NewClientWithOpts(client.FromEnv)
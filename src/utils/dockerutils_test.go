@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestComputeContainerStats(t *testing.T) {
+	var stats types.StatsJSON
+	stats.CPUStats.CPUUsage.TotalUsage = 3000
+	stats.CPUStats.SystemUsage = 20000
+	stats.CPUStats.OnlineCPUs = 4
+	stats.PreCPUStats.CPUUsage.TotalUsage = 1000
+	stats.PreCPUStats.SystemUsage = 10000
+	stats.MemoryStats.Usage = 600
+	stats.MemoryStats.Limit = 1000
+	stats.MemoryStats.Stats = map[string]uint64{"inactive_file": 100}
+	stats.Networks = map[string]types.NetworkStats{"eth0": {RxBytes: 10, TxBytes: 20}, "eth1": {RxBytes: 1, TxBytes: 2}}
+	stats.BlkioStats.IoServiceBytesRecursive = []types.BlkioStatEntry{{Op: "Read", Value: 5}, {Op: "Write", Value: 7}, {Op: "read", Value: 5}}
+
+	result := computeContainerStats(&stats)
+	expected := ContainerStats{CPUPercent: 80, MemoryUsage: 500, MemoryLimit: 1000, MemoryPercent: 50, NetworkRx: 11, NetworkTx: 22, BlockRead: 10, BlockWrite: 7}
+	if result != expected {
+		t.Errorf("got %+v, expected %+v", result, expected)
+	}
+
+	// a single sample has no CPU delta
+	stats.PreCPUStats = stats.CPUStats
+	if result := computeContainerStats(&stats); result.CPUPercent != 0 {
+		t.Errorf("expected no CPU usage without delta, got %f", result.CPUPercent)
+	}
+}
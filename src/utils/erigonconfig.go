@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"KeepixPlugin/appstate"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultErigonProfile is the profile of nodes installed before profiles existed, erigon does not prune by default
+const DefaultErigonProfile = "archive"
+
+// defaultErigonDBSizeLimit is the database size limit used when none is configured
+const defaultErigonDBSizeLimit = "7697000000000"
+
+// minimalPrunedBlocks is how many recent blocks the minimal-pruned profile keeps, erigon keeps 90000 by default
+const minimalPrunedBlocks = "10000"
+
+// ErigonProfiles maps the erigon presets to their pruning flags. A full node only prunes the state history, it keeps
+// the receipts, the transaction index and the call traces of every block, a minimal-pruned node prunes all of them
+// past a short window.
+var ErigonProfiles = map[string][]string{
+	"archive": {},
+	"full":    {"--prune=h"},
+	"minimal-pruned": {
+		"--prune=hrtc",
+		"--prune.h.older=" + minimalPrunedBlocks,
+		"--prune.r.older=" + minimalPrunedBlocks,
+		"--prune.t.older=" + minimalPrunedBlocks,
+		"--prune.c.older=" + minimalPrunedBlocks,
+	},
+}
+
+var (
+	erigonSizePattern = regexp.MustCompile(`^(?i)\d+(\.\d+)?(b|kb|mb|gb|tb)?$`)
+	erigonListPattern = regexp.MustCompile(`^[a-zA-Z0-9_.*:/-]+(,[a-zA-Z0-9_.*:/-]+)*$`)
+)
+
+func validateErigonBool(value string) error {
+	if value != "true" && value != "false" {
+		return fmt.Errorf("expected true or false")
+	}
+	return nil
+}
+
+func validateErigonInt(value string) error {
+	if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+		return fmt.Errorf("expected a positive integer")
+	}
+	return nil
+}
+
+func validateErigonSize(value string) error {
+	if !erigonSizePattern.MatchString(value) {
+		return fmt.Errorf("expected a size such as 512mb or 2TB")
+	}
+	return nil
+}
+
+func validateErigonList(value string) error {
+	if !erigonListPattern.MatchString(value) {
+		return fmt.Errorf("expected a comma separated list")
+	}
+	return nil
+}
+
+func validateErigonDuration(value string) error {
+	if _, err := time.ParseDuration(value); err != nil {
+		return fmt.Errorf("expected a duration such as 10s")
+	}
+	return nil
+}
+
+func validateErigonVerbosity(value string) error {
+	switch value {
+	case "crit", "error", "warn", "info", "debug", "trace":
+		return nil
+	}
+	return fmt.Errorf("expected one of crit, error, warn, info, debug, trace")
+}
+
+// erigonOverrideFlags are the erigon flags that can be set as advanced overrides, flags managed by the plugin
+// (datadir, chain, heimdall, nat, mining) and the ones with a dedicated setting are not part of it
+var erigonOverrideFlags = map[string]func(value string) error{
+	"http.vhosts":            validateErigonList,
+	"http.corsdomain":        validateErigonList,
+	"http.compression":       validateErigonBool,
+	"ws.compression":         validateErigonBool,
+	"maxpeers":               validateErigonInt,
+	"rpc.batch.limit":        validateErigonInt,
+	"rpc.returndata.limit":   validateErigonInt,
+	"rpc.gascap":             validateErigonInt,
+	"batchSize":              validateErigonSize,
+	"db.pagesize":            validateErigonSize,
+	"torrent.upload.rate":    validateErigonSize,
+	"torrent.download.slots": validateErigonInt,
+	"torrent.port":           validateErigonInt,
+	"prune.h.older":          validateErigonInt,
+	"prune.r.older":          validateErigonInt,
+	"prune.t.older":          validateErigonInt,
+	"prune.c.older":          validateErigonInt,
+	"snap.keepblocks":        validateErigonBool,
+	"sync.loop.throttle":     validateErigonDuration,
+	"txpool.disable":         validateErigonBool,
+	"log.console.verbosity":  validateErigonVerbosity,
+}
+
+// ValidateErigonConfig checks the profile, the dedicated settings and the overrides of an erigon config.
+func ValidateErigonConfig(config appstate.ErigonConfig) error {
+	if config.Profile != "" {
+		if _, exists := ErigonProfiles[config.Profile]; !exists {
+			return fmt.Errorf("unknown profile %s", config.Profile)
+		}
+	}
+	if config.HTTPAPI != "" {
+		if err := validateErigonList(config.HTTPAPI); err != nil {
+			return fmt.Errorf("invalid httpApi: %v", err)
+		}
+	}
+	if config.DBSizeLimit != "" {
+		if err := validateErigonSize(config.DBSizeLimit); err != nil {
+			return fmt.Errorf("invalid dbSizeLimit: %v", err)
+		}
+	}
+	if config.TorrentDownloadRate != "" {
+		if err := validateErigonSize(config.TorrentDownloadRate); err != nil {
+			return fmt.Errorf("invalid torrentDownloadRate: %v", err)
+		}
+	}
+	for flag, value := range config.Overrides {
+		validate, exists := erigonOverrideFlags[flag]
+		if !exists {
+			return fmt.Errorf("unsupported override %s", flag)
+		}
+		if err := validate(value); err != nil {
+			return fmt.Errorf("invalid override %s: %v", flag, err)
+		}
+	}
+	return nil
+}
+
+// ParseErigonOverrides parses a comma separated list of flag=value overrides.
+func ParseErigonOverrides(list string) (map[string]string, error) {
+	overrides := make(map[string]string)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		flag, value, found := strings.Cut(entry, "=")
+		flag = strings.TrimLeft(strings.TrimSpace(flag), "-")
+		if !found || flag == "" {
+			return nil, fmt.Errorf("invalid override %s, expected flag=value", entry)
+		}
+		overrides[flag] = strings.TrimSpace(value)
+	}
+	return overrides, nil
+}
+
+// ErigonConfigArgs returns the erigon flags of a config, overrides are sorted so the command line is stable.
+func ErigonConfigArgs(config appstate.ErigonConfig) []string {
+	profile := config.Profile
+	if profile == "" {
+		profile = DefaultErigonProfile
+	}
+	args := append([]string{}, ErigonProfiles[profile]...)
+
+	dbSizeLimit := config.DBSizeLimit
+	if dbSizeLimit == "" {
+		dbSizeLimit = defaultErigonDBSizeLimit
+	}
+	args = append(args, "--db.size.limit="+dbSizeLimit)
+	if config.HTTPAPI != "" {
+		args = append(args, "--http.api="+config.HTTPAPI)
+	}
+	if config.WS {
+		args = append(args, "--ws")
+	}
+	if config.TorrentDownloadRate != "" {
+		args = append(args, "--torrent.download.rate="+config.TorrentDownloadRate)
+	}
+	if config.Metrics {
+		args = append(args, "--metrics", "--metrics.addr=0.0.0.0", "--metrics.port=6060")
+	}
+
+	flags := make([]string, 0, len(config.Overrides))
+	for flag := range config.Overrides {
+		flags = append(flags, flag)
+	}
+	sort.Strings(flags)
+	for _, flag := range flags {
+		args = append(args, "--"+flag+"="+config.Overrides[flag])
+	}
+	return args
+}
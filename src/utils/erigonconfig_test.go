@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"KeepixPlugin/appstate"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestErigonConfigArgs(t *testing.T) {
+	overrides, err := ParseErigonOverrides("--maxpeers=50, http.vhosts=*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := appstate.ErigonConfig{
+		Profile:   "full",
+		HTTPAPI:   "eth,erigon,web3",
+		WS:        true,
+		Metrics:   true,
+		Overrides: overrides,
+	}
+	if err := ValidateErigonConfig(config); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"--prune=h",
+		"--db.size.limit=7697000000000",
+		"--http.api=eth,erigon,web3",
+		"--ws",
+		"--metrics", "--metrics.addr=0.0.0.0", "--metrics.port=6060",
+		"--http.vhosts=*",
+		"--maxpeers=50",
+	}
+	if args := ErigonConfigArgs(config); !reflect.DeepEqual(args, expected) {
+		t.Errorf("unexpected args %v", args)
+	}
+
+	// the default config keeps the command line of nodes installed before profiles
+	if args := ErigonConfigArgs(appstate.ErigonConfig{}); !reflect.DeepEqual(args, []string{"--db.size.limit=7697000000000"}) {
+		t.Errorf("unexpected default args %v", args)
+	}
+}
+
+func TestErigonProfilesDiffer(t *testing.T) {
+	seen := make(map[string]string)
+	for profile, flags := range ErigonProfiles {
+		key := strings.Join(flags, " ")
+		if other, exists := seen[key]; exists {
+			t.Errorf("the %s and %s profiles run with the same flags %q", profile, other, key)
+		}
+		seen[key] = profile
+	}
+	for _, flag := range ErigonProfiles["full"] {
+		if strings.HasPrefix(flag, "--prune=") && strings.ContainsAny(strings.TrimPrefix(flag, "--prune="), "rtc") {
+			t.Errorf("the full profile prunes more than the state history: %s", flag)
+		}
+	}
+}
+
+func TestValidateErigonConfig(t *testing.T) {
+	invalid := []appstate.ErigonConfig{
+		{Profile: "light"},
+		{DBSizeLimit: "a lot"},
+		{TorrentDownloadRate: "16mb;rm"},
+		{HTTPAPI: "eth admin"},
+		{Overrides: map[string]string{"datadir": "/tmp"}},
+		{Overrides: map[string]string{"maxpeers": "-1"}},
+		{Overrides: map[string]string{"sync.loop.throttle": "soon"}},
+	}
+	for _, config := range invalid {
+		if err := ValidateErigonConfig(config); err == nil {
+			t.Errorf("expected %+v to be invalid", config)
+		}
+	}
+
+	if _, err := ParseErigonOverrides("maxpeers"); err == nil {
+		t.Error("expected an override without value to be invalid")
+	}
+}
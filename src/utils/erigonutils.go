@@ -3,18 +3,79 @@ package utils
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
+	"os"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
+// ErigonClient runs erigon as execution client
+type ErigonClient struct{}
+
+func (ErigonClient) Name() string               { return "erigon" }
+func (ErigonClient) Image() string              { return "thorax/erigon:v2.53.4" }
+func (ErigonClient) ContainerName() string      { return "erigon" }
+func (ErigonClient) DataFolder() string         { return "erigon" }
+func (ErigonClient) ContainerDataPath() string  { return "/erigon-home" }
+func (ErigonClient) ChaindataFolders() []string { return []string{"bor", "chaindata"} }
+func (ErigonClient) IdentityFiles() []string    { return []string{"nodekey", "nodes", "signer.key"} }
+
+// Ports returns the ports published by erigon, the torrent port of its snapshot downloader included, the metrics port
+// only when metrics are enabled.
+func (ErigonClient) Ports(options ExecutionClientOptions) []uint {
+	ports := []uint{30303, 30304, 42069, 8545, 9090}
+	if options.Erigon.Metrics {
+		ports = append(ports, 6060)
+	}
+	return ports
+}
+
+// Args returns the erigon flags for the given options.
+func (ErigonClient) Args(options ExecutionClientOptions) []string {
+	args := []string{"--datadir=/erigon-home", "--bor.heimdall=http://heimdall-rest:1317", "--private.api.addr=0.0.0.0:9090", "--http.addr=0.0.0.0", "--chain=" + options.Network.ErigonChain}
+	if options.ExternalIP != "" {
+		args = append(args, "--nat=extip:"+options.ExternalIP)
+	}
+	args = append(args, ErigonConfigArgs(options.Erigon)...)
+	if options.SignerKey != nil {
+		args = append(args, "--mine", "--miner.etherbase="+crypto.PubkeyToAddress(options.SignerKey.PublicKey).Hex(), "--miner.sigfile=/erigon-home/signer.key")
+		if len(options.StaticPeers) > 0 {
+			// only peer with our sentries
+			args = append(args, "--nodiscover", "--staticpeers="+strings.Join(options.StaticPeers, ","))
+		}
+	}
+	return args
+}
+
+// Prepare writes the signer key erigon seals blocks with, validators only.
+func (ErigonClient) Prepare(hostDataPath string, options ExecutionClientOptions) error {
+	keyPath := path.Join(hostDataPath, "signer.key")
+	if options.SignerKey == nil {
+		err := os.Remove(keyPath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(keyPath, []byte(hex.EncodeToString(crypto.FromECDSA(options.SignerKey))), fs.FileMode(0600))
+}
+
+// SyncingStatus returns the sync status reported by the erigon stages.
+func (ErigonClient) SyncingStatus() (*SyncingStatus, error) {
+	return GetErigonSyncingStatus()
+}
+
 // RequestBody represents the JSON payload for the request.
 type RequestBody struct {
 	Jsonrpc string        `json:"jsonrpc"`
@@ -49,9 +110,21 @@ type ResponseBodyNotSyncing struct {
 	Result  bool   `json:"result"`
 }
 
+// StageProgress is the progress of an erigon stage
+type StageProgress struct {
+	Name     string  `json:"name"`
+	Block    uint64  `json:"block"`
+	Progress float32 `json:"progress"`
+}
+
 type SyncingStatus struct {
-	Progress float32
-	Stage    string
+	Progress        float32
+	Stage           string
+	Stages          []StageProgress // erigon only
+	CurrentBlock    uint64
+	HighestBlock    uint64
+	BlocksPerSecond float64
+	ETASeconds      int64 // -1 when unknown
 }
 
 // GetErigonSyncingStatus performs a request and returns the node status or an error.
@@ -106,58 +179,121 @@ func GetErigonSyncingStatus() (*SyncingStatus, error) {
 		Progress: 100.0,
 		Stage:    "Synced",
 	}
+	if !isSyncing {
+		return &result, nil
+	}
 
-	if isSyncing {
-		highestBlock, _ := strconv.ParseInt(strings.TrimPrefix(response.Result.HighestBlock, "0x"), 16, 64)
-		currentBlock, _ := strconv.ParseInt(strings.TrimPrefix(response.Result.CurrentBlock, "0x"), 16, 64)
-		if highestBlock == 0 {
-			result.Progress = 0
-			result.Stage = "Waiting for Heimdall sync"
-
-			// is it because fetching snapshots?
-			logs, err := FetchContainerLogs("erigon", 50)
-			if err != nil {
-				return nil, fmt.Errorf("error fetching container logs: %v", err)
-			}
-			progress := findLastProgressUpdateInLogs(logs)
-			if progress != nil {
-				//result.Progress, _ = strconv.ParseFloat(progress["progress"], 32)
-				result.Stage = fmt.Sprintf("Downloading snapshots [%s/%s]:%s", progress["step"], progress["total_steps"], progress["stage"])
-			} else {
-				// no its just synced apparently
+	result.HighestBlock = parseHexUint64(response.Result.HighestBlock)
+	result.CurrentBlock = parseHexUint64(response.Result.CurrentBlock)
+	result.ETASeconds = -1
+	if result.HighestBlock == 0 {
+		result.Progress = 0
+		result.Stage = "Waiting for Heimdall sync"
+
+		// is it because fetching snapshots?
+		logs, err := FetchContainerLogs("erigon", 50)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching container logs: %v", err)
+		}
+		progress := findLastProgressUpdateInLogs(logs)
+		if progress != nil {
+			result.Stage = fmt.Sprintf("Downloading snapshots [%s/%s]:%s", progress["step"], progress["total_steps"], progress["stage"])
+			if percent, found := parseProgressPercent(progress["progress"]); found {
+				result.Progress = percent
 			}
-		} else {
-			// check if we are still syncing
-			if currentBlock < highestBlock {
-				// find the current stage and display progress
-				// use for to parse through stages
-				var previousIndex int
-				for i, s := range response.Result.Stages {
-					if s.BlockNumber == "0x0" {
-						//previous stage is the current one
-						break
-					} else {
-						previousIndex = i
-					}
-				}
-
-				stage := response.Result.Stages[previousIndex]
-
-				totalStages := len(response.Result.Stages)
-				currentStage := previousIndex + 1
-
-				blockNumber, _ := strconv.ParseInt(strings.TrimPrefix(stage.BlockNumber, "0x"), 16, 32)
-
-				result.Progress = float32(blockNumber) / float32(highestBlock) * (float32(currentStage) / float32(totalStages)) * 100
-				result.Stage = fmt.Sprintf("[%v/%v] %v", currentStage, totalStages, stage.StageName)
+			if timeLeft, found := parseTimeLeft(progress["time_left"]); found {
+				result.ETASeconds = timeLeft
 			}
-
 		}
+		return &result, nil
 	}
 
+	var stageName string
+	result.Stages, result.Progress, stageName = erigonStageBreakdown(response.Result.Stages, result.HighestBlock)
+	if stageName == "" {
+		// every stage reached the highest block
+		result.Progress = 100
+		result.Stage = "Synced"
+		result.ETASeconds = 0
+		return &result, nil
+	}
+	result.Stage = stageName
+
+	// the execution stage is the bottleneck of the sync, its rate drives the ETA
+	block := result.CurrentBlock
+	for _, stage := range result.Stages {
+		if stage.Name == "Execution" {
+			block = stage.Block
+		}
+	}
+	applySyncRate(&result, block)
 	return &result, nil
 }
 
+// erigonStageBreakdown returns the progress of each stage, the overall progress as the average of the stages
+// and the description of the first stage that has not reached the highest block, empty if all did.
+func erigonStageBreakdown(stages []Stage, highestBlock uint64) ([]StageProgress, float32, string) {
+	breakdown := make([]StageProgress, 0, len(stages))
+	var total float32
+	description := ""
+	for i, stage := range stages {
+		block := parseHexUint64(stage.BlockNumber)
+		progress := float32(100)
+		if block < highestBlock {
+			progress = float32(float64(block) / float64(highestBlock) * 100)
+			if description == "" {
+				description = fmt.Sprintf("[%v/%v] %v", i+1, len(stages), stage.StageName)
+			}
+		}
+		total += progress
+		breakdown = append(breakdown, StageProgress{Name: stage.StageName, Block: block, Progress: progress})
+	}
+	if len(breakdown) == 0 {
+		return breakdown, 0, "Starting"
+	}
+	return breakdown, total / float32(len(breakdown)), description
+}
+
+// parseHexUint64 parses a 0x prefixed quantity, returning 0 when invalid.
+func parseHexUint64(value string) uint64 {
+	parsed, _ := strconv.ParseUint(strings.TrimPrefix(value, "0x"), 16, 64)
+	return parsed
+}
+
+// parseProgressPercent extracts the percentage of an erigon progress value such as "32.26% 15.3GB/47.4GB".
+func parseProgressPercent(progress string) (float32, bool) {
+	match := regexp.MustCompile(`(\d+(\.\d+)?)%`).FindStringSubmatch(progress)
+	if match == nil {
+		return 0, false
+	}
+	percent, err := strconv.ParseFloat(match[1], 32)
+	if err != nil {
+		return 0, false
+	}
+	return float32(percent), true
+}
+
+// parseTimeLeft converts an erigon time-left value such as "1hrs:23m" or "45s" to seconds.
+func parseTimeLeft(timeLeft string) (int64, bool) {
+	matches := regexp.MustCompile(`(\d+)(hrs|h|m|s)`).FindAllStringSubmatch(timeLeft, -1)
+	if matches == nil {
+		return 0, false
+	}
+	var seconds int64
+	for _, match := range matches {
+		value, _ := strconv.ParseInt(match[1], 10, 64)
+		switch match[2] {
+		case "hrs", "h":
+			seconds += value * 3600
+		case "m":
+			seconds += value * 60
+		case "s":
+			seconds += value
+		}
+	}
+	return seconds, true
+}
+
 type responseChainIDBody struct {
 	Jsonrpc string `json:"jsonrpc"`
 	ID      int    `json:"id"`
@@ -258,6 +394,8 @@ func findLastProgressUpdateInLogs(input string) map[string]string {
 	info["step"] = lastMatch[1]
 	info["total_steps"] = lastMatch[2]
 	info["stage"] = lastMatch[4]
+	info["progress"] = lastMatch[5]
+	info["time_left"] = lastMatch[6]
 
 	return info
 }
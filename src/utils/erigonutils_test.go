@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"KeepixPlugin/appstate"
+	"testing"
+)
+
+func TestErigonStageBreakdown(t *testing.T) {
+	stages := []Stage{
+		{StageName: "Snapshots", BlockNumber: "0x2faf080"}, // 50000000
+		{StageName: "Headers", BlockNumber: "0x2faf080"},
+		{StageName: "Execution", BlockNumber: "0x17d7840"}, // 25000000
+		{StageName: "Finish", BlockNumber: "0x0"},
+	}
+	breakdown, progress, description := erigonStageBreakdown(stages, 50000000)
+	if len(breakdown) != 4 {
+		t.Fatalf("expected 4 stages, got %d", len(breakdown))
+	}
+	if breakdown[2].Block != 25000000 || breakdown[2].Progress != 50 {
+		t.Errorf("unexpected execution stage %+v", breakdown[2])
+	}
+	if progress != 62.5 {
+		t.Errorf("expected 62.5%% overall, got %v", progress)
+	}
+	if description != "[3/4] Execution" {
+		t.Errorf("unexpected description %q", description)
+	}
+
+	// heights above 32 bits must not overflow
+	breakdown, _, _ = erigonStageBreakdown([]Stage{{StageName: "Headers", BlockNumber: "0x100000000"}}, 1<<33)
+	if breakdown[0].Block != 1<<32 {
+		t.Errorf("unexpected block %d", breakdown[0].Block)
+	}
+}
+
+func TestSnapshotProgressInLogs(t *testing.T) {
+	logs := `[INFO] [1/15 Snapshots] downloading progress="32.26% 15.3GB/47.4GB" time-left=1hrs:23m total-time=10m`
+	progress := findLastProgressUpdateInLogs(logs)
+	if progress == nil {
+		t.Fatal("expected a progress update")
+	}
+	percent, found := parseProgressPercent(progress["progress"])
+	if !found || percent < 32.25 || percent > 32.27 {
+		t.Errorf("unexpected percent %v", percent)
+	}
+	timeLeft, found := parseTimeLeft(progress["time_left"])
+	if !found || timeLeft != 3600+23*60 {
+		t.Errorf("unexpected time left %v", timeLeft)
+	}
+}
+
+func TestSyncRate(t *testing.T) {
+	var samples []appstate.SyncSample
+	samples = addSyncSample(samples, appstate.SyncSample{Block: 1000, Time: 100})
+	if syncRate(samples) != 0 {
+		t.Error("expected no rate from a single sample")
+	}
+	samples = addSyncSample(samples, appstate.SyncSample{Block: 2000, Time: 200})
+	samples = addSyncSample(samples, appstate.SyncSample{Block: 3000, Time: 300})
+	if rate := syncRate(samples); rate != 10 {
+		t.Errorf("expected 10 blocks/s, got %v", rate)
+	}
+
+	// old samples leave the window
+	samples = addSyncSample(samples, appstate.SyncSample{Block: 5000, Time: 300 + syncRateWindow})
+	if samples[0].Time != 300 {
+		t.Errorf("expected the samples out of the window to be dropped, oldest is %d", samples[0].Time)
+	}
+
+	// a resync resets the samples
+	samples = addSyncSample(samples, appstate.SyncSample{Block: 10, Time: 400 + syncRateWindow})
+	if len(samples) != 1 {
+		t.Errorf("expected the samples to be reset, got %d", len(samples))
+	}
+}
@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"KeepixPlugin/appstate"
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"time"
+)
+
+// syncRateWindow is how far back the samples used to compute the sync rate go
+const syncRateWindow = 30 * 60
+
+// maxSyncSamples caps the samples kept in the state
+const maxSyncSamples = 60
+
+// ExecutionClientOptions are the settings the execution client is started with
+type ExecutionClientOptions struct {
+	Network     appstate.Network
+	ExternalIP  string
+	SignerKey   *ecdsa.PrivateKey     // validators only, key sealing the blocks
+	StaticPeers []string              // validators only, enodes of the execution sentries
+	Erigon      appstate.ErigonConfig // erigon only
+}
+
+// ExecutionClient abstracts the execution layer node run next to heimdall
+type ExecutionClient interface {
+	// Name is the name of the client in the registry
+	Name() string
+	Image() string
+	// ContainerName is also the hostname heimdall uses to reach the client on the polygon network
+	ContainerName() string
+	// DataFolder is the folder of the client in the plugin data folder
+	DataFolder() string
+	// ContainerDataPath is where the data folder is mounted in the container
+	ContainerDataPath() string
+	// ChaindataFolders are the folders of the data folder removed on resync
+	ChaindataFolders() []string
+	// IdentityFiles are the names of the files identifying the node, they are left out of backups
+	IdentityFiles() []string
+	Ports(options ExecutionClientOptions) []uint
+	Args(options ExecutionClientOptions) []string
+	// Prepare writes the files the client needs for the given options and removes the ones it does not need
+	Prepare(hostDataPath string, options ExecutionClientOptions) error
+	SyncingStatus() (*SyncingStatus, error)
+}
+
+// DefaultExecutionClient is the execution client used when none was selected
+const DefaultExecutionClient = "erigon"
+
+// ExecutionClients is the registry of the supported execution clients
+var ExecutionClients = map[string]ExecutionClient{
+	"erigon": ErigonClient{},
+	"bor":    BorClient{},
+}
+
+// ExecutionClientNames returns the sorted names of the supported execution clients.
+func ExecutionClientNames() []string {
+	names := make([]string, 0, len(ExecutionClients))
+	for name := range ExecutionClients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CurrentExecutionClient returns the execution client the node is installed with.
+func CurrentExecutionClient() ExecutionClient {
+	client, exists := ExecutionClients[appstate.CurrentState.ExecutionClient]
+	if !exists {
+		return ExecutionClients[DefaultExecutionClient]
+	}
+	return client
+}
+
+// ExecutionDataPath returns the host data folder of the given execution client, the one it was moved to when it is
+// the client of the node.
+func ExecutionDataPath(client ExecutionClient) string {
+	if appstate.CurrentState.DataPaths.Execution != "" && client.Name() == CurrentExecutionClient().Name() {
+		return appstate.CurrentState.DataPaths.Execution
+	}
+	storage, _ := appstate.GetStoragePath()
+	return path.Join(storage, "data", client.DataFolder())
+}
+
+// addSyncSample appends a sample to the previous ones, dropping the samples out of the rate window.
+// Samples are reset when the height goes backwards, after a resync for instance.
+func addSyncSample(samples []appstate.SyncSample, sample appstate.SyncSample) []appstate.SyncSample {
+	kept := make([]appstate.SyncSample, 0, len(samples)+1)
+	for _, previous := range samples {
+		if previous.Block > sample.Block || previous.Time > sample.Time {
+			kept = kept[:0]
+			break
+		}
+		if sample.Time-previous.Time <= syncRateWindow {
+			kept = append(kept, previous)
+		}
+	}
+	if len(kept) > 0 && kept[len(kept)-1].Time == sample.Time {
+		kept = kept[:len(kept)-1]
+	}
+	kept = append(kept, sample)
+	if len(kept) > maxSyncSamples {
+		kept = kept[len(kept)-maxSyncSamples:]
+	}
+	return kept
+}
+
+// syncRate returns the blocks per second between the oldest and the newest samples, 0 if they are too close.
+func syncRate(samples []appstate.SyncSample) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+	oldest := samples[0]
+	newest := samples[len(samples)-1]
+	elapsed := newest.Time - oldest.Time
+	if elapsed < 10 {
+		return 0
+	}
+	return float64(newest.Block-oldest.Block) / float64(elapsed)
+}
+
+// applySyncRate records the height of the execution client and sets the sync rate and ETA of a status.
+func applySyncRate(status *SyncingStatus, block uint64) {
+	samples := addSyncSample(appstate.CurrentState.ExecutionSyncSamples, appstate.SyncSample{Block: block, Time: time.Now().Unix()})
+	_ = appstate.UpdateExecutionSyncSamples(samples) // the rate is only informative, a failed write only loses a sample
+
+	status.BlocksPerSecond = syncRate(samples)
+	status.ETASeconds = -1
+	if status.BlocksPerSecond > 0 && status.HighestBlock > block {
+		status.ETASeconds = int64(float64(status.HighestBlock-block) / status.BlocksPerSecond)
+	}
+}
+
+// callExecutionRPC calls a JSON-RPC method of the execution client returning a hex quantity.
+func callExecutionRPC(method string) (uint64, error) {
+	jsonBody, err := json.Marshal(RequestBody{Jsonrpc: "2.0", Method: method, Params: []interface{}{}, ID: 1})
+	if err != nil {
+		return 0, fmt.Errorf("error marshaling request body: %v", err)
+	}
+
+	resp, err := http.Post("http://localhost:8545/", "application/json", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return 0, fmt.Errorf("error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.Reader(resp.Body))
+	if err != nil {
+		return 0, fmt.Errorf("error reading response body: %v", err)
+	}
+
+	var response responseChainIDBody
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return 0, fmt.Errorf("error unmarshalling response body: %v", err)
+	}
+	return parseHexUint64(response.Result), nil
+}
+
+// GetExecutionPeerCount returns the number of peers of the execution client.
+func GetExecutionPeerCount() (uint64, error) {
+	return callExecutionRPC("net_peerCount")
+}
+
+// GetExecutionBlockNumber returns the latest block of the execution client.
+func GetExecutionBlockNumber() (uint64, error) {
+	return callExecutionRPC("eth_blockNumber")
+}
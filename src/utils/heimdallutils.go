@@ -1,21 +1,30 @@
 package utils
 
 import (
-	"context"
+	"KeepixPlugin/appstate"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
-
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/mount"
-	"github.com/docker/docker/client"
+	"time"
 )
 
+// HeimdallPorts are the ports published by heimdall, its P2P and RPC ports
+var HeimdallPorts = []uint{26656, 26657}
+
+// HeimdallDataPath returns the host folder of heimdall, holding its config and its data.
+func HeimdallDataPath() string {
+	if appstate.CurrentState.DataPaths.Heimdall != "" {
+		return appstate.CurrentState.DataPaths.Heimdall
+	}
+	storage, _ := appstate.GetStoragePath()
+	return path.Join(storage, "data", "heimdall")
+}
+
 // Define struct to match the JSON structure
 type NodeStatusResponse struct {
 	Jsonrpc string `json:"jsonrpc"`
@@ -40,6 +49,7 @@ type NodeStatusResponse struct {
 			LatestAppHash      string `json:"latest_app_hash"`
 			LatestBlockHeight  string `json:"latest_block_height"`
 			CurrentBlockHeight string
+			ReferenceSource    string
 			LatestBlockTime    string `json:"latest_block_time"`
 			CatchingUp         bool   `json:"catching_up"`
 		} `json:"sync_info"`
@@ -56,72 +66,23 @@ type NodeStatusResponse struct {
 
 // RunSnapshotDownloader starts the process to download the proper snapshot for heimdall
 func RunSnapshotDownloader(hostHeimdallPath string, network string) error {
-	err := PullImage("alpine:latest")
-	if err != nil {
-		return err
-	}
-	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv)
-	if err != nil {
-		return fmt.Errorf("error creating Docker client: %v", err)
-	}
-	defer cli.Close()
-
 	command := fmt.Sprintf(`apk add aria2 curl bash zstd pv tar && curl -L https://snapshot-download.polygon.technology/snapdown.sh | bash -s -- --network %s --client heimdall --extract-dir /heimdall/data`, network)
-	tempContainerConfig := container.Config{
-		Image: "alpine:latest",
-		Cmd:   []string{"sh", "-c", command},
-	}
-
-	hostConfig := container.HostConfig{
-		Mounts: []mount.Mount{
-			{
-				Type:   mount.TypeBind,
-				Source: hostHeimdallPath,
-				Target: "/heimdall",
-			}},
-	}
-
-	resp, err := cli.ContainerCreate(ctx, &tempContainerConfig, &hostConfig, nil, nil, "heimdall-snapshot-downloader")
-	if err != nil {
-		return fmt.Errorf("error creating snapshot downloader container: %v", err)
-	}
+	return runSnapshotContainer("heimdall-snapshot-downloader", command, hostHeimdallPath, "/heimdall", nil, nil)
+}
 
-	if err := cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
-		return fmt.Errorf("error starting snapshot downloader container: %v", err)
-	}
+func ValidateSnapshot(hostHeimdallPath string) (bool, error) {
+	return validateSnapshotContainer("heimdall-snapshot-downloader")
+}
 
-	return nil
+// HeimdallSnapshotSucceeded checks if the heimdall snapshot was downloaded, the downloader is kept until
+// RemoveHeimdallSnapshotDownloader so the check can be repeated
+func HeimdallSnapshotSucceeded() (bool, error) {
+	return snapshotContainerSucceeded("heimdall-snapshot-downloader")
 }
 
-func ValidateSnapshot(hostHeimdallPath string) (bool, error) {
-	running, err := IsContainerRunning("heimdall-snapshot-downloader")
-	if err != nil {
-		return false, fmt.Errorf("error checking status of snapshot downloader: %v", err)
-	}
-	if running {
-		return false, nil
-	}
-	// get container logs
-	logs, err := FetchContainerLogs("heimdall-snapshot-downloader", 10)
-	if err != nil {
-		return false, fmt.Errorf("error getting logs from snapshot downloader: %v", err)
-	}
-	// check if last line of the output is a valid download
-	// Remove non-numeric characters
-	pattern := regexp.MustCompile(`Command succeeded`)
-	// Find all matches
-	matches := pattern.FindAllStringSubmatch(logs, -1)
-	success := len(matches) > 0
-	if !success {
-		return false, nil
-	}
-	// remove image and container
-	err = RemoveImageIfExists("alpine:latest")
-	if err != nil {
-		return false, fmt.Errorf("error removing image: %v", err)
-	}
-	return true, nil
+// RemoveHeimdallSnapshotDownloader removes the finished heimdall snapshot downloader
+func RemoveHeimdallSnapshotDownloader() error {
+	return removeSnapshotContainer("heimdall-snapshot-downloader")
 }
 
 // extractProgressValue extracts the numeric value from a progress string like "(34%)"
@@ -178,10 +139,19 @@ func getProgressFromProgressSummary(input string) (float32, error) {
 }
 
 func SnapshotProgress() (float32, error) {
-	logs, err := FetchContainerLogs("heimdall-snapshot-downloader", 100)
+	return snapshotDownloadProgress("heimdall-snapshot-downloader")
+}
+
+// snapshotDownloadProgress reads the download progress of aria2 from the logs of a snapshot container
+func snapshotDownloadProgress(containerName string) (float32, error) {
+	logs, err := FetchContainerLogs(containerName, 100)
 	if err != nil {
 		return 0, fmt.Errorf("error getting logs from snapshot downloader: %v", err)
 	}
+	// a local snapshot is only extracted
+	if progress, _, found := extractionProgress(logs); found {
+		return progress, nil
+	}
 	progress, err := getProgressFromProgressSummary(logs)
 	if err != nil {
 		return 0, fmt.Errorf("error getting progress from progress summary: %v", err)
@@ -189,8 +159,65 @@ func SnapshotProgress() (float32, error) {
 	return progress, nil
 }
 
-// getNodeStatus performs an HTTP GET request to the specified URL and parses the JSON response.
-func GetHeimdallNodeStatus() (*NodeStatusResponse, error) {
+// heimdallBlockTime is the average time between two heimdall blocks
+const heimdallBlockTime = 5 * time.Second
+
+// estimateHeimdallChainTip estimates the chain tip from the age of the latest local block
+func estimateHeimdallChainTip(status *NodeStatusResponse) int64 {
+	latestHeight, _ := strconv.ParseInt(status.Result.SyncInfo.LatestBlockHeight, 10, 64)
+	if !status.Result.SyncInfo.CatchingUp {
+		return latestHeight
+	}
+	latestTime, err := time.Parse(time.RFC3339Nano, status.Result.SyncInfo.LatestBlockTime)
+	if err != nil || time.Since(latestTime) < 0 {
+		return latestHeight
+	}
+	return latestHeight + int64(time.Since(latestTime)/heimdallBlockTime)
+}
+
+// fetchReferenceHeight returns the latest block height of a reference endpoint,
+// either a tendermint RPC (/status) or a heimdall REST server (/staking/validator-set).
+func fetchReferenceHeight(endpoint string) (string, error) {
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+
+	resp, err := httpClient.Get(endpoint + "/status")
+	if err == nil {
+		defer resp.Body.Close()
+		body, err := io.ReadAll(io.Reader(resp.Body))
+		if err == nil {
+			var rpcStatus NodeStatusResponse
+			if json.Unmarshal(body, &rpcStatus) == nil && rpcStatus.Result.SyncInfo.LatestBlockHeight != "" {
+				return rpcStatus.Result.SyncInfo.LatestBlockHeight, nil
+			}
+		}
+	}
+
+	resp, err = httpClient.Get(endpoint + "/staking/validator-set")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.Reader(resp.Body))
+	if err != nil {
+		return "", err
+	}
+
+	var liveStatusResponse = struct {
+		Height string `json:"height"`
+	}{}
+	if err := json.Unmarshal(body, &liveStatusResponse); err != nil {
+		return "", err
+	}
+	if liveStatusResponse.Height == "" {
+		return "", fmt.Errorf("no height returned by %s", endpoint)
+	}
+	return liveStatusResponse.Height, nil
+}
+
+// GetHeimdallLocalStatus returns the status of the local heimdall node only.
+func GetHeimdallLocalStatus() (*NodeStatusResponse, error) {
 	resp, err := http.Get("http://localhost:26657/status")
 	if err != nil {
 		return nil, err
@@ -206,33 +233,105 @@ func GetHeimdallNodeStatus() (*NodeStatusResponse, error) {
 	if err := json.Unmarshal(bodyStatus, &statusResponse); err != nil {
 		return nil, err
 	}
+	return &statusResponse, nil
+}
 
-	// also fetch live status to get the current block height
-	// testnet
-	rpc := "https://heimdall-api.polygon.technology/staking/validator-set"
-	if statusResponse.Result.NodeInfo.Network != "heimdall-137" {
-		// check testnet instead
-		rpc = "https://heimdall-api-testnet.polygon.technology/staking/validator-set"
-	}
-	resp, err = http.Get(rpc)
+// CheckHeimdallRestServer returns an error if the heimdall rest server does not answer.
+func CheckHeimdallRestServer() error {
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	resp, err := httpClient.Get("http://localhost:1317/node_info")
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("rest server answered with status %d", resp.StatusCode)
+	}
+	return nil
+}
 
-	bodyLiveStatus, err := io.ReadAll(io.Reader(resp.Body))
+// getNodeStatus performs an HTTP GET request to the specified URL and parses the JSON response.
+func GetHeimdallNodeStatus() (*NodeStatusResponse, error) {
+	statusResponse, err := GetHeimdallLocalStatus()
 	if err != nil {
 		return nil, err
 	}
 
-	var liveStatusResponse = struct {
-		Height string `json:"height"`
-	}{}
-	if err := json.Unmarshal(bodyLiveStatus, &liveStatusResponse); err != nil {
+	// also fetch the chain tip from a reference node to get the current block height
+	endpoints := appstate.CurrentState.HeimdallReferenceEndpoints
+	if len(endpoints) == 0 {
+		endpoints = appstate.CurrentNetwork().HeimdallAPIURLs
+	}
+	for _, endpoint := range endpoints {
+		height, err := fetchReferenceHeight(endpoint)
+		if err != nil {
+			continue
+		}
+		statusResponse.Result.SyncInfo.CurrentBlockHeight = height
+		statusResponse.Result.SyncInfo.ReferenceSource = endpoint
+		break
+	}
+	if statusResponse.Result.SyncInfo.ReferenceSource == "" {
+		// no reference answered, fall back to the progress of the local node only
+		statusResponse.Result.SyncInfo.CurrentBlockHeight = strconv.FormatInt(estimateHeimdallChainTip(statusResponse), 10)
+		statusResponse.Result.SyncInfo.ReferenceSource = "local"
+	}
+
+	return statusResponse, nil
+}
+
+// NetInfoMonitor holds the traffic statistics of a peer connection in one direction
+type NetInfoMonitor struct {
+	Bytes   string `json:"Bytes"`
+	AvgRate string `json:"AvgRate"`
+	CurRate string `json:"CurRate"`
+}
+
+// NetInfoPeer is a peer as reported by the tendermint net_info endpoint
+type NetInfoPeer struct {
+	NodeInfo struct {
+		ID         string `json:"id"`
+		ListenAddr string `json:"listen_addr"`
+		Moniker    string `json:"moniker"`
+		Version    string `json:"version"`
+	} `json:"node_info"`
+	IsOutbound       bool `json:"is_outbound"`
+	ConnectionStatus struct {
+		Duration    string         `json:"Duration"`
+		SendMonitor NetInfoMonitor `json:"SendMonitor"`
+		RecvMonitor NetInfoMonitor `json:"RecvMonitor"`
+	} `json:"connection_status"`
+	RemoteIP string `json:"remote_ip"`
+}
+
+// NetInfoResponse is the response of the tendermint net_info endpoint
+type NetInfoResponse struct {
+	Jsonrpc string `json:"jsonrpc"`
+	ID      string `json:"id"`
+	Result  struct {
+		Listening bool          `json:"listening"`
+		Listeners []string      `json:"listeners"`
+		NPeers    string        `json:"n_peers"`
+		Peers     []NetInfoPeer `json:"peers"`
+	} `json:"result"`
+}
+
+// GetHeimdallNetInfo returns the P2P information of the local heimdall node.
+func GetHeimdallNetInfo() (*NetInfoResponse, error) {
+	resp, err := http.Get("http://localhost:26657/net_info")
+	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
-	statusResponse.Result.SyncInfo.CurrentBlockHeight = liveStatusResponse.Height
+	body, err := io.ReadAll(io.Reader(resp.Body))
+	if err != nil {
+		return nil, err
+	}
 
-	return &statusResponse, nil
+	var netInfo NetInfoResponse
+	if err := json.Unmarshal(body, &netInfo); err != nil {
+		return nil, err
+	}
+	return &netInfo, nil
 }
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// metricFamily holds the samples of a metric, prometheus expects them to be grouped
+type metricFamily struct {
+	name       string
+	help       string
+	metricType string
+	samples    []string
+}
+
+// MetricsWriter builds a prometheus text exposition
+type MetricsWriter struct {
+	families []*metricFamily
+	byName   map[string]*metricFamily
+}
+
+// NewMetricsWriter returns an empty metrics writer.
+func NewMetricsWriter() *MetricsWriter {
+	return &MetricsWriter{byName: make(map[string]*metricFamily)}
+}
+
+// escapeLabelValue escapes a label value as required by the prometheus text format.
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// Add adds a sample to a metric, labels are given as name, value pairs.
+func (w *MetricsWriter) Add(name string, help string, metricType string, value float64, labels ...string) {
+	family, exists := w.byName[name]
+	if !exists {
+		family = &metricFamily{name: name, help: help, metricType: metricType}
+		w.byName[name] = family
+		w.families = append(w.families, family)
+	}
+
+	sample := name
+	if len(labels) >= 2 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, labels[i]+`="`+escapeLabelValue(labels[i+1])+`"`)
+		}
+		sample += "{" + strings.Join(pairs, ",") + "}"
+	}
+	family.samples = append(family.samples, sample+" "+strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// Gauge adds a sample to a gauge.
+func (w *MetricsWriter) Gauge(name string, help string, value float64, labels ...string) {
+	w.Add(name, help, "gauge", value, labels...)
+}
+
+// String renders the metrics in the prometheus text format.
+func (w *MetricsWriter) String() string {
+	var builder strings.Builder
+	for _, family := range w.families {
+		builder.WriteString("# HELP " + family.name + " " + family.help + "\n")
+		builder.WriteString("# TYPE " + family.name + " " + family.metricType + "\n")
+		for _, sample := range family.samples {
+			builder.WriteString(sample + "\n")
+		}
+	}
+	return builder.String()
+}
+
+// WriteMetricsFile writes metrics for the node exporter textfile collector, through a rename
+// so the collector never reads a partially written file.
+func WriteMetricsFile(filePath string, content string) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		return err
+	}
+	tmpPath := filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(content), fs.FileMode(0644)); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
+}
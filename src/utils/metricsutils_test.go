@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMetricsWriter(t *testing.T) {
+	metrics := NewMetricsWriter()
+	metrics.Gauge("node_up", "Whether the node is up.", 1, "container", "heimdall")
+	metrics.Gauge("node_height", "Latest block.", 12345678901)
+	metrics.Gauge("node_up", "Whether the node is up.", 0, "container", `erigon "main"`)
+
+	expected := `# HELP node_up Whether the node is up.
+# TYPE node_up gauge
+node_up{container="heimdall"} 1
+node_up{container="erigon \"main\""} 0
+# HELP node_height Latest block.
+# TYPE node_height gauge
+node_height 1.2345678901e+10
+`
+	if metrics.String() != expected {
+		t.Errorf("unexpected metrics:\n%s", metrics.String())
+	}
+
+	filePath := filepath.Join(t.TempDir(), "metrics", "node.prom")
+	if err := WriteMetricsFile(filePath, metrics.String()); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filePath)
+	if err != nil || string(content) != expected {
+		t.Errorf("unexpected metrics file %q: %v", string(content), err)
+	}
+	if _, err := os.Stat(filePath + ".tmp"); !os.IsNotExist(err) {
+		t.Error("expected the temporary file to be renamed")
+	}
+}
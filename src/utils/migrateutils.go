@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"KeepixPlugin/appstate"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+// copyFolderScript copies /source into /target with rsync, then verifies nothing differs between them. Files are
+// compared by size and modification time, or by content with $CHECKSUM.
+const copyFolderScript = `set -e
+apk add $APK_FLAGS rsync
+echo "Copying"
+rsync -a --info=progress2 --no-inc-recursive /source/ /target/
+echo "Verifying"
+changes=$(rsync -a --dry-run --itemize-changes $CHECKSUM /source/ /target/)
+if [ -n "$changes" ]; then
+  echo "Verification failed, the copy differs:"
+  echo "$changes" | head -20
+  exit 1
+fi
+echo "Command succeeded"
+`
+
+// CopyHostFolder copies a host folder into another one with a helper container, so files owned by the containers
+// keep their owner, and verifies the copy. With checksum the content of the files is compared, which reads both
+// copies entirely.
+func CopyHostFolder(source string, target string, checksum bool) error {
+	mounts := []mount.Mount{
+		{Type: mount.TypeBind, Source: source, Target: "/source", ReadOnly: true},
+		{Type: mount.TypeBind, Source: target, Target: "/target"},
+	}
+	env := []string{}
+	if checksum {
+		env = append(env, "CHECKSUM=--checksum")
+	}
+	_, err := RunHelperContainer("data-migration", copyFolderScript, mounts, env, printProgress)
+	return err
+}
+
+// MigrationSettled records the health of the node on migrated data and returns whether the old copy can be removed:
+// the node has to be healthy for window seconds in a row and its height has to have moved past the height of the
+// migration. An unknown migration height, 0, is taken when the node is first healthy. It also returns whether the
+// tracking of the migration changed and has to be saved.
+func MigrationSettled(migration *appstate.DataMigration, healthy bool, height uint64, window int64, now int64) (settled bool, changed bool) {
+	if !healthy {
+		if migration.HealthySince == 0 {
+			return false, false
+		}
+		migration.HealthySince = 0
+		return false, true
+	}
+	if migration.HealthySince == 0 {
+		migration.HealthySince = now
+		if migration.Height == 0 {
+			migration.Height = height
+		}
+		return false, true
+	}
+	return now-migration.HealthySince >= window && height > migration.Height, false
+}
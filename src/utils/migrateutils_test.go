@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"KeepixPlugin/appstate"
+	"testing"
+)
+
+func TestMigrationSettled(t *testing.T) {
+	const now, window = int64(100000), int64(300)
+	migration := appstate.DataMigration{Height: 1000}
+	if settled, changed := MigrationSettled(&migration, false, 0, window, now); settled || changed {
+		t.Errorf("unexpected settled %v or changed %v while unhealthy", settled, changed)
+	}
+	if settled, changed := MigrationSettled(&migration, true, 1000, window, now); settled || !changed || migration.HealthySince != now {
+		t.Errorf("unexpected settled %v, changed %v or migration %+v once healthy", settled, changed, migration)
+	}
+	if settled, _ := MigrationSettled(&migration, true, 1010, window, now+window-1); settled {
+		t.Error("settled within the window")
+	}
+	if settled, _ := MigrationSettled(&migration, true, 1000, window, now+window); settled {
+		t.Error("settled without moving past the migration height")
+	}
+	// an interruption starts the window again
+	if _, changed := MigrationSettled(&migration, false, 0, window, now+window); !changed || migration.HealthySince != 0 {
+		t.Errorf("unexpected changed %v or migration %+v once unhealthy", changed, migration)
+	}
+	MigrationSettled(&migration, true, 1010, window, now+2*window)
+	if settled, _ := MigrationSettled(&migration, true, 1020, window, now+2*window+1); settled {
+		t.Error("settled right after an interruption")
+	}
+	if settled, _ := MigrationSettled(&migration, true, 1030, window, now+3*window); !settled {
+		t.Error("not settled after a healthy window past the migration height")
+	}
+
+	// the height is unknown when the node was stopped at migration
+	unknown := appstate.DataMigration{}
+	MigrationSettled(&unknown, true, 500, window, now)
+	if unknown.Height != 500 {
+		t.Errorf("expected the first healthy height to be recorded, got %+v", unknown)
+	}
+	if settled, _ := MigrationSettled(&unknown, true, 500, window, now+window); settled {
+		t.Error("settled without moving past the first healthy height")
+	}
+}
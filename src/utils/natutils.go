@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"KeepixPlugin/appstate"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/nat"
+)
+
+// NAT modes of the node
+const (
+	NATModeExtIP = "extip" // the external IP is configured or detected with the echo services
+	NATModeUPnP  = "upnp"  // the P2P ports are mapped on the gateway with UPnP
+	NATModePMP   = "pmp"   // the P2P ports are mapped on the gateway with NAT-PMP
+)
+
+// NATModes are the supported NAT modes
+var NATModes = []string{NATModeExtIP, NATModeUPnP, NATModePMP}
+
+// DefaultIPEchoServices answer the IP of the caller, they are tried in order
+var DefaultIPEchoServices = []string{
+	"https://api.ipify.org",
+	"https://checkip.amazonaws.com",
+	"https://icanhazip.com",
+	"https://ifconfig.me/ip",
+	"https://httpbin.org/ip",
+}
+
+// PortMapping is a port forwarded by the gateway in the UPnP and NAT-PMP modes
+type PortMapping struct {
+	Protocol    string `json:"protocol"`
+	Port        int    `json:"port"`
+	Description string `json:"description"`
+}
+
+// P2PPortMappings returns the mappings of the P2P ports among the published ports, on UDP as well for the ports
+// published on UDP
+func P2PPortMappings(ports []uint) []PortMapping {
+	mappings := []PortMapping{}
+	for _, port := range ports {
+		if !IsP2PPort(port) {
+			continue
+		}
+		protocols := []string{"TCP"}
+		if PublishesUDP(port) {
+			protocols = append(protocols, "UDP")
+		}
+		for _, protocol := range protocols {
+			mappings = append(mappings, PortMapping{protocol, int(port), fmt.Sprintf("keepix-polygon-%d-%s", port, strings.ToLower(protocol))})
+		}
+	}
+	return mappings
+}
+
+// parseEchoResponse reads the IP answered by an echo service, as plain text or as JSON such as httpbin's
+func parseEchoResponse(body []byte) (string, error) {
+	text := strings.TrimSpace(string(body))
+	var fields map[string]interface{}
+	if json.Unmarshal(body, &fields) == nil {
+		for _, key := range []string{"ip", "origin", "address"} {
+			if value, ok := fields[key].(string); ok {
+				text = value
+				break
+			}
+		}
+	}
+	// proxies may append their own address
+	text = strings.TrimSpace(strings.Split(text, ",")[0])
+	if net.ParseIP(text) == nil {
+		return "", fmt.Errorf("unexpected answer %q", text)
+	}
+	return text, nil
+}
+
+// fetchEchoIP asks an echo service for the IP of the host
+func fetchEchoIP(service string) (string, error) {
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+	response, err := httpClient.Get(service)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %d", response.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+	return parseEchoResponse(body)
+}
+
+// DetectExternalIP returns the IP answered by the first echo service that answers.
+func DetectExternalIP(services []string) (string, error) {
+	if len(services) == 0 {
+		services = DefaultIPEchoServices
+	}
+	var errs []string
+	for _, service := range services {
+		ip, err := fetchEchoIP(service)
+		if err == nil {
+			return ip, nil
+		}
+		errs = append(errs, service+": "+err.Error())
+	}
+	return "", fmt.Errorf("no IP echo service answered: %s", strings.Join(errs, ", "))
+}
+
+// natInterface returns the gateway client of a NAT mode
+func natInterface(mode string) (nat.Interface, error) {
+	switch mode {
+	case NATModeUPnP:
+		return nat.UPnP(), nil
+	case NATModePMP:
+		// the gateway is discovered
+		return nat.PMP(nil), nil
+	}
+	return nil, fmt.Errorf("NAT mode %s does not use the gateway", mode)
+}
+
+// ResolveExternalIP returns the IP advertised to peers and where it comes from: the configured one, the one of the
+// gateway in the UPnP and NAT-PMP modes, or the one detected by the echo services. The last detected IP is used
+// when no echo service answers.
+func ResolveExternalIP() (string, string, error) {
+	exposure := appstate.CurrentState.Exposure
+	if exposure.ExternalIP != "" {
+		return exposure.ExternalIP, "manual", nil
+	}
+	if exposure.NATMode == NATModeUPnP || exposure.NATMode == NATModePMP {
+		gateway, _ := natInterface(exposure.NATMode)
+		ip, err := gateway.ExternalIP()
+		// behind several NATs the gateway only knows a private address
+		if err == nil && !ip.IsPrivate() && !ip.IsUnspecified() && !ip.IsLoopback() {
+			rememberExternalIP(ip.String())
+			return ip.String(), exposure.NATMode, nil
+		}
+	}
+	ip, err := DetectExternalIP(exposure.IPEchoServices)
+	if err != nil {
+		if exposure.LastDetectedIP != "" {
+			return exposure.LastDetectedIP, "cached", nil
+		}
+		return "", "", err
+	}
+	rememberExternalIP(ip)
+	return ip, "echo", nil
+}
+
+// NodeExternalIP returns the IP advertised to peers, see ResolveExternalIP.
+func NodeExternalIP() (string, error) {
+	ip, _, err := ResolveExternalIP()
+	return ip, err
+}
+
+// rememberExternalIP keeps the detected IP for when the echo services cannot be reached
+func rememberExternalIP(ip string) {
+	if appstate.CurrentState.Exposure.LastDetectedIP == ip {
+		return
+	}
+	exposure := appstate.CurrentState.Exposure
+	exposure.LastDetectedIP = ip
+	appstate.UpdateExposure(exposure)
+}
+
+// MapP2PPorts forwards the P2P ports among the published ports on the gateway for the given lifetime, UPnP gateways
+// may keep them longer.
+func MapP2PPorts(mode string, ports []uint, lifetime time.Duration) error {
+	gateway, err := natInterface(mode)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for _, mapping := range P2PPortMappings(ports) {
+		_, err := gateway.AddMapping(mapping.Protocol, mapping.Port, mapping.Port, mapping.Description, lifetime)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s %d: %v", mapping.Protocol, mapping.Port, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("error mapping ports with %s: %s", gateway.String(), strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// UnmapP2PPorts removes the forwards of the P2P ports among the published ports from the gateway.
+func UnmapP2PPorts(mode string, ports []uint) error {
+	gateway, err := natInterface(mode)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for _, mapping := range P2PPortMappings(ports) {
+		if err := gateway.DeleteMapping(mapping.Protocol, mapping.Port, mapping.Port); err != nil {
+			errs = append(errs, fmt.Sprintf("%s %d: %v", mapping.Protocol, mapping.Port, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("error removing port mappings: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
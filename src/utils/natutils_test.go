@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseEchoResponse(t *testing.T) {
+	tests := map[string]string{
+		"203.0.113.7\n":                       "203.0.113.7",
+		`{"origin": "203.0.113.7, 10.0.0.1"}`: "203.0.113.7",
+		`{"ip":"2001:db8::1"}`:                "2001:db8::1",
+	}
+	for body, expected := range tests {
+		ip, err := parseEchoResponse([]byte(body))
+		if err != nil || ip != expected {
+			t.Errorf("parseEchoResponse(%q) = %s, %v, expected %s", body, ip, err, expected)
+		}
+	}
+	if _, err := parseEchoResponse([]byte("<html>blocked</html>")); err == nil {
+		t.Error("expected an error for a non IP answer")
+	}
+}
+
+func TestDetectExternalIP(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("198.51.100.4"))
+	}))
+	defer working.Close()
+
+	ip, err := DetectExternalIP([]string{failing.URL, working.URL})
+	if err != nil || ip != "198.51.100.4" {
+		t.Errorf("got %s, %v", ip, err)
+	}
+	if _, err := DetectExternalIP([]string{failing.URL}); err == nil {
+		t.Error("expected an error when no service answers")
+	}
+}
+
+func TestP2PPortMappings(t *testing.T) {
+	ports := append([]uint{}, HeimdallPorts...)
+	ports = append(ports, ErigonClient{}.Ports(ExecutionClientOptions{})...)
+	mappings := map[string]bool{}
+	for _, mapping := range P2PPortMappings(ports) {
+		mappings[fmt.Sprintf("%s/%d", mapping.Protocol, mapping.Port)] = true
+	}
+	expected := []string{"TCP/26656", "TCP/30303", "UDP/30303", "TCP/30304", "UDP/30304", "TCP/42069", "UDP/42069"}
+	if len(mappings) != len(expected) {
+		t.Errorf("unexpected mappings %v", mappings)
+	}
+	for _, mapping := range expected {
+		if !mappings[mapping] {
+			t.Errorf("missing mapping %s in %v", mapping, mappings)
+		}
+	}
+
+	// bor has no torrent port
+	for _, mapping := range P2PPortMappings(BorClient{}.Ports(ExecutionClientOptions{})) {
+		if mapping.Port != 30303 {
+			t.Errorf("unexpected mapping %+v for bor", mapping)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package utils
+
+import (
+	"KeepixPlugin/appstate"
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+// IsOffline returns whether images are loaded from local archives instead of being pulled
+func IsOffline() bool {
+	return appstate.CurrentState.Offline.ImagesPath != ""
+}
+
+// offlineHelperPackages are the packages the maintenance scripts install, offline the helper image has to ship them
+var offlineHelperPackages = []string{"zstd", "lz4", "tar", "coreutils", "rsync"}
+
+// SavedImage is an image of a docker save archive
+type SavedImage struct {
+	Tags []string
+	ID   string // digest of the image config, the ID docker gives the image once loaded
+	// digests of the manifests in the index.json of the OCI layout, the ID of the image with the containerd image store
+	Digests []string
+}
+
+// savedImageIndex is the index.json of the OCI layout of a docker save archive
+type savedImageIndex struct {
+	Manifests []struct {
+		Digest string `json:"digest"`
+	} `json:"manifests"`
+}
+
+// savedImageManifest is an entry of the manifest.json of a docker save archive
+type savedImageManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+}
+
+// openArchive opens a tar archive, gzipped or not
+func openArchive(archive string) (*tar.Reader, io.Closer, error) {
+	file, err := os.Open(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(file)
+	magic, err := reader.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return tar.NewReader(gzipReader), file, nil
+	}
+	return tar.NewReader(reader), file, nil
+}
+
+// readSavedImages returns the images of a docker save archive, from its manifest.json and the index.json of the OCI
+// layout of recent docker
+func readSavedImages(archive string) ([]SavedImage, error) {
+	reader, closer, err := openArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	var manifests []savedImageManifest
+	var index savedImageIndex
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", filepath.Base(archive), err)
+		}
+		switch path.Clean(header.Name) {
+		case "manifest.json":
+			if err := json.NewDecoder(reader).Decode(&manifests); err != nil {
+				return nil, fmt.Errorf("error reading the manifest of %s: %v", filepath.Base(archive), err)
+			}
+		case "index.json":
+			if err := json.NewDecoder(reader).Decode(&index); err != nil {
+				return nil, fmt.Errorf("error reading the index of %s: %v", filepath.Base(archive), err)
+			}
+		}
+	}
+	if manifests == nil {
+		return nil, fmt.Errorf("%s is not a docker save archive, it has no manifest.json", filepath.Base(archive))
+	}
+	digests := []string{}
+	for _, manifest := range index.Manifests {
+		digests = append(digests, manifest.Digest)
+	}
+	images := []SavedImage{}
+	for _, manifest := range manifests {
+		// blobs/sha256/<digest> in the OCI layout of recent docker, <digest>.json before
+		digest := strings.TrimSuffix(path.Base(manifest.Config), ".json")
+		images = append(images, SavedImage{Tags: manifest.RepoTags, ID: "sha256:" + digest, Digests: digests})
+	}
+	return images, nil
+}
+
+// checkSavedImage checks a local image is the one of an archive: the ID docker gives it is the digest of its config
+// with the classic image store, and the digest of its manifest with the containerd image store
+func checkSavedImage(info *ImageInfo, saved *SavedImage, archive string) error {
+	if info.ID == saved.ID {
+		return nil
+	}
+	for _, digest := range saved.Digests {
+		if info.ID == digest {
+			return nil
+		}
+	}
+	return fmt.Errorf("the image %s has the ID %s, the archive %s declares %s", info.Image, info.ID, archive, saved.ID)
+}
+
+// sha256File returns the hex sha256 of a file
+func sha256File(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// findSavedImage returns the archive of a folder holding an image, its checksum and the image, among the archives
+// listed in the SHA256SUMS file of the folder
+func findSavedImage(folder string, image string) (string, string, *SavedImage, error) {
+	content, err := os.ReadFile(filepath.Join(folder, SnapshotChecksumFile))
+	if err != nil {
+		return "", "", nil, fmt.Errorf("error reading the %s file of the image archives: %v", SnapshotChecksumFile, err)
+	}
+	sums := parseSHA256Sums(string(content))
+	archives := []string{}
+	for archive := range sums {
+		archives = append(archives, archive)
+	}
+	sort.Strings(archives)
+	for _, archive := range archives {
+		images, err := readSavedImages(filepath.Join(folder, archive))
+		if err != nil {
+			return "", "", nil, err
+		}
+		for _, saved := range images {
+			for _, tag := range saved.Tags {
+				if tag == image {
+					return archive, sums[archive], &saved, nil
+				}
+			}
+		}
+	}
+	return "", "", nil, fmt.Errorf("no archive listed in %s holds the image %s", filepath.Join(folder, SnapshotChecksumFile), image)
+}
+
+// LoadImageFromArchives loads an image from the docker save archives of a folder. The archive has to match its
+// checksum in the SHA256SUMS file of the folder, and the loaded image the config digest of the archive.
+func LoadImageFromArchives(folder string, image string) error {
+	archive, expected, saved, err := findSavedImage(folder, image)
+	if err != nil {
+		return err
+	}
+	sum, err := sha256File(filepath.Join(folder, archive))
+	if err != nil {
+		return fmt.Errorf("error hashing %s: %v", archive, err)
+	}
+	if !strings.EqualFold(sum, expected) {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", archive, expected, sum)
+	}
+
+	file, err := os.Open(filepath.Join(folder, archive))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return fmt.Errorf("error creating Docker client: %v", err)
+	}
+	defer cli.Close()
+	response, err := cli.ImageLoad(context.Background(), file, true)
+	if err != nil {
+		return fmt.Errorf("error loading %s: %v", archive, err)
+	}
+	defer response.Body.Close()
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var message struct {
+			Error string `json:"error"`
+		}
+		err := decoder.Decode(&message)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading the load output of %s: %v", archive, err)
+		}
+		if message.Error != "" {
+			return fmt.Errorf("error loading %s: %s", archive, message.Error)
+		}
+	}
+
+	info, err := InspectImage(image)
+	if err != nil {
+		return err
+	}
+	if !info.Exists {
+		return fmt.Errorf("%s did not load the image %s", archive, image)
+	}
+	if err := checkSavedImage(info, saved, archive); err != nil {
+		return err
+	}
+	fmt.Println("Loaded image " + image + " from " + archive)
+	return nil
+}
+
+// VerifyLoadedImage checks an image already present is the one of the archives of a folder
+func VerifyLoadedImage(folder string, info *ImageInfo) error {
+	archive, _, saved, err := findSavedImage(folder, info.Image)
+	if err != nil {
+		return err
+	}
+	return checkSavedImage(info, saved, archive)
+}
+
+// ValidateOfflineConfig checks the local archives of an offline install hold what it would download otherwise: the
+// images, with the helper image shipping the packages of the maintenance scripts, and the heimdall snapshot unless
+// the kept chain data is reused.
+func ValidateOfflineConfig(offline appstate.OfflineConfig, images []string, erigonSnapshot string, reuseChaindata bool) error {
+	if offline.HeimdallSnapshot != "" {
+		if !filepath.IsAbs(offline.HeimdallSnapshot) {
+			return fmt.Errorf("heimdallSnapshot has to be an absolute path")
+		}
+		if _, err := os.Stat(filepath.Join(offline.HeimdallSnapshot, SnapshotChecksumFile)); err != nil {
+			return fmt.Errorf("heimdallSnapshot has to be a directory containing %s", SnapshotChecksumFile)
+		}
+	}
+	if offline.ImagesPath == "" {
+		if offline.HelperImage != "" {
+			return fmt.Errorf("helperImage can only be used with offlineImages")
+		}
+		return nil
+	}
+	if !filepath.IsAbs(offline.ImagesPath) {
+		return fmt.Errorf("offlineImages has to be an absolute path")
+	}
+	if offline.HelperImage == "" {
+		// the packages of the default alpine image are installed from the network
+		return fmt.Errorf("offlineImages requires helperImage, an image shipping %s", strings.Join(offlineHelperPackages, ", "))
+	}
+	if offline.HeimdallSnapshot == "" && !reuseChaindata {
+		return fmt.Errorf("the heimdall snapshot cannot be downloaded offline, set heimdallSnapshot")
+	}
+	if IsValidURL(erigonSnapshot) {
+		return fmt.Errorf("the erigon snapshot cannot be downloaded offline, use a local directory")
+	}
+	return CheckImageArchives(offline.ImagesPath, append(images, offline.HelperImage))
+}
+
+// CheckImageArchives checks the archives of a folder hold the images, without loading them
+func CheckImageArchives(folder string, images []string) error {
+	for _, image := range images {
+		if _, _, _, err := findSavedImage(folder, image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
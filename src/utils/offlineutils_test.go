@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"KeepixPlugin/appstate"
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestImageArchive(t *testing.T, file string, manifest string, gzipped bool) {
+	writeTestArchive(t, file, map[string]string{"oci-layout": "{}", "manifest.json": manifest}, gzipped)
+}
+
+func writeTestArchive(t *testing.T, file string, files map[string]string, gzipped bool) {
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var writer io.Writer = f
+	if gzipped {
+		gzipWriter := gzip.NewWriter(f)
+		defer gzipWriter.Close()
+		writer = gzipWriter
+	}
+	tarWriter := tar.NewWriter(writer)
+	defer tarWriter.Close()
+	for name, content := range files {
+		if err := tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadSavedImages(t *testing.T) {
+	dir := t.TempDir()
+	oci := filepath.Join(dir, "heimdall.tar")
+	writeTestImageArchive(t, oci, `[{"Config":"blobs/sha256/abcd","RepoTags":["0xpolygon/heimdall:1.0.3"]}]`, false)
+	images, err := readSavedImages(oci)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(images) != 1 || images[0].ID != "sha256:abcd" || images[0].Tags[0] != "0xpolygon/heimdall:1.0.3" {
+		t.Errorf("unexpected images %+v", images)
+	}
+
+	legacy := filepath.Join(dir, "alpine.tar.gz")
+	writeTestImageArchive(t, legacy, `[{"Config":"ef01.json","RepoTags":["alpine:latest"]}]`, true)
+	images, err = readSavedImages(legacy)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(images) != 1 || images[0].ID != "sha256:ef01" {
+		t.Errorf("unexpected images %+v", images)
+	}
+
+	notSaved := filepath.Join(dir, "data.tar")
+	if err := os.WriteFile(notSaved, []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readSavedImages(notSaved); err == nil {
+		t.Error("expected an error for an archive without manifest")
+	}
+}
+
+func TestFindSavedImage(t *testing.T) {
+	dir := t.TempDir()
+	writeTestImageArchive(t, filepath.Join(dir, "heimdall.tar"), `[{"Config":"blobs/sha256/abcd","RepoTags":["0xpolygon/heimdall:1.0.3"]}]`, false)
+	writeTestImageArchive(t, filepath.Join(dir, "unlisted.tar"), `[{"Config":"blobs/sha256/ef01","RepoTags":["alpine:latest"]}]`, false)
+	if err := os.WriteFile(filepath.Join(dir, SnapshotChecksumFile), []byte("1234  heimdall.tar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, sum, image, err := findSavedImage(dir, "0xpolygon/heimdall:1.0.3")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if archive != "heimdall.tar" || sum != "1234" || image.ID != "sha256:abcd" {
+		t.Errorf("unexpected archive %s, checksum %s and image %+v", archive, sum, image)
+	}
+	// only the archives listed in SHA256SUMS are trusted
+	if err := CheckImageArchives(dir, []string{"alpine:latest"}); err == nil {
+		t.Error("expected an error for an image of an unlisted archive")
+	}
+}
+
+func TestCheckSavedImage(t *testing.T) {
+	dir := t.TempDir()
+	oci := filepath.Join(dir, "heimdall.tar")
+	writeTestArchive(t, oci, map[string]string{
+		"index.json":    `{"schemaVersion":2,"manifests":[{"digest":"sha256:9876"}]}`,
+		"manifest.json": `[{"Config":"blobs/sha256/abcd","RepoTags":["0xpolygon/heimdall:1.0.3"]}]`,
+	}, false)
+	images, err := readSavedImages(oci)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	saved := &images[0]
+	// the classic image store gives the config digest, the containerd one the manifest digest
+	for _, id := range []string{"sha256:abcd", "sha256:9876"} {
+		if err := checkSavedImage(&ImageInfo{Image: "0xpolygon/heimdall:1.0.3", ID: id}, saved, "heimdall.tar"); err != nil {
+			t.Errorf("unexpected error %v for the ID %s", err, id)
+		}
+	}
+	if err := checkSavedImage(&ImageInfo{Image: "0xpolygon/heimdall:1.0.3", ID: "sha256:ef01"}, saved, "heimdall.tar"); err == nil {
+		t.Error("expected an error for another image")
+	}
+}
+
+func TestLoadImageFromArchivesChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	writeTestImageArchive(t, filepath.Join(dir, "heimdall.tar"), `[{"Config":"blobs/sha256/abcd","RepoTags":["0xpolygon/heimdall:1.0.3"]}]`, false)
+	if err := os.WriteFile(filepath.Join(dir, SnapshotChecksumFile), []byte("1234  heimdall.tar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the archive is refused before docker loads it
+	err := LoadImageFromArchives(dir, "0xpolygon/heimdall:1.0.3")
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch for heimdall.tar") {
+		t.Errorf("expected a checksum mismatch, got %v", err)
+	}
+}
+
+func TestValidateOfflineConfig(t *testing.T) {
+	images := t.TempDir()
+	writeTestImageArchive(t, filepath.Join(images, "images.tar"), `[{"Config":"blobs/sha256/abcd","RepoTags":["0xpolygon/heimdall:1.0.3","thorax/erigon:v2.53.4","helper:1"]}]`, false)
+	if err := os.WriteFile(filepath.Join(images, SnapshotChecksumFile), []byte("1234  images.tar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := t.TempDir()
+	if err := os.WriteFile(filepath.Join(snapshot, SnapshotChecksumFile), []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	required := []string{"0xpolygon/heimdall:1.0.3", "thorax/erigon:v2.53.4"}
+	valid := appstate.OfflineConfig{ImagesPath: images, HeimdallSnapshot: snapshot, HelperImage: "helper:1"}
+	if err := ValidateOfflineConfig(valid, required, "", false); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := ValidateOfflineConfig(appstate.OfflineConfig{}, required, "https://example.com/erigon", false); err != nil {
+		t.Errorf("unexpected error %v online", err)
+	}
+
+	tests := map[string]struct {
+		offline        appstate.OfflineConfig
+		erigonSnapshot string
+		reuseChaindata bool
+	}{
+		"helper image online":      {appstate.OfflineConfig{HelperImage: "helper:1"}, "", false},
+		"relative images":          {appstate.OfflineConfig{ImagesPath: "images", HeimdallSnapshot: snapshot, HelperImage: "helper:1"}, "", false},
+		"relative snapshot":        {appstate.OfflineConfig{ImagesPath: images, HeimdallSnapshot: "snapshot", HelperImage: "helper:1"}, "", false},
+		"snapshot without sums":    {appstate.OfflineConfig{ImagesPath: images, HeimdallSnapshot: images + "/missing", HelperImage: "helper:1"}, "", false},
+		"default helper image":     {appstate.OfflineConfig{ImagesPath: images, HeimdallSnapshot: snapshot}, "", false},
+		"no heimdall snapshot":     {appstate.OfflineConfig{ImagesPath: images, HelperImage: "helper:1"}, "", false},
+		"downloaded erigon":        {valid, "https://example.com/erigon", false},
+		"helper image not shipped": {appstate.OfflineConfig{ImagesPath: images, HeimdallSnapshot: snapshot, HelperImage: "helper:2"}, "", false},
+	}
+	for name, test := range tests {
+		if err := ValidateOfflineConfig(test.offline, required, test.erigonSnapshot, test.reuseChaindata); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	// the kept chain data needs no heimdall snapshot
+	if err := ValidateOfflineConfig(appstate.OfflineConfig{ImagesPath: images, HelperImage: "helper:1"}, required, "", true); err != nil {
+		t.Errorf("unexpected error %v when reusing the chain data", err)
+	}
+}
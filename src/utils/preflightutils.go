@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/shirou/gopsutil/mem"
+)
+
+// Statuses of a preflight check
+const (
+	PreflightPass = "pass"
+	PreflightWarn = "warn"
+	PreflightFail = "fail"
+)
+
+// PreflightCheck is the result of a check run before installing the node
+type PreflightCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"` // pass, warn or fail
+	Value   string `json:"value"`
+	Message string `json:"message"`
+}
+
+// PreflightReport is the result of all the checks, install is refused when its status is fail
+type PreflightReport struct {
+	Status string           `json:"status"`
+	Score  int              `json:"score"` // 100 when all checks pass, a warning counts half
+	Checks []PreflightCheck `json:"checks"`
+}
+
+// NewPreflightReport scores the checks, the status of the report is the worst status of its checks.
+func NewPreflightReport(checks []PreflightCheck) PreflightReport {
+	report := PreflightReport{Status: PreflightPass, Score: 100, Checks: checks}
+	if len(checks) == 0 {
+		return report
+	}
+	points := 0
+	for _, check := range checks {
+		switch check.Status {
+		case PreflightPass:
+			points += 2
+		case PreflightWarn:
+			points++
+			if report.Status == PreflightPass {
+				report.Status = PreflightWarn
+			}
+		default:
+			report.Status = PreflightFail
+		}
+	}
+	report.Score = points * 100 / (2 * len(checks))
+	return report
+}
+
+// ThresholdStatus returns pass when value reaches the recommended value, warn when it reaches the minimum and fail otherwise.
+func ThresholdStatus(value float64, minimum float64, recommended float64) string {
+	if value >= recommended {
+		return PreflightPass
+	}
+	if value >= minimum {
+		return PreflightWarn
+	}
+	return PreflightFail
+}
+
+// TotalMemory returns the physical memory of the host in bytes.
+func TotalMemory() (uint64, error) {
+	memory, err := mem.VirtualMemory()
+	if err != nil {
+		return 0, err
+	}
+	return memory.Total, nil
+}
+
+// IsPortFree returns true if the TCP port, and the UDP port when udp is set, can be bound on all interfaces.
+func IsPortFree(port uint, udp bool) bool {
+	address := ":" + strconv.FormatUint(uint64(port), 10)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	if udp {
+		packetConn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			return false
+		}
+		packetConn.Close()
+	}
+	return true
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNewPreflightReport(t *testing.T) {
+	report := NewPreflightReport([]PreflightCheck{{Status: PreflightPass}, {Status: PreflightWarn}})
+	if report.Status != PreflightWarn || report.Score != 75 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	report = NewPreflightReport([]PreflightCheck{{Status: PreflightFail}, {Status: PreflightWarn}, {Status: PreflightPass}, {Status: PreflightPass}})
+	if report.Status != PreflightFail || report.Score != 62 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if status := ThresholdStatus(6, 4, 8); status != PreflightWarn {
+		t.Errorf("unexpected status %s", status)
+	}
+}
+
+func TestIsPortFree(t *testing.T) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Skip("cannot listen:", err)
+	}
+	defer listener.Close()
+	if IsPortFree(uint(listener.Addr().(*net.TCPAddr).Port), false) {
+		t.Error("expected a bound port to be in use")
+	}
+}
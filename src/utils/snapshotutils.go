@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+)
+
+// SnapshotChecksumFile is the sha256sum formatted file expected next to local snapshot archives
+const SnapshotChecksumFile = "SHA256SUMS"
+
+// extractSnapshotScript verifies the archives listed in SHA256SUMS of the current folder and extracts them into $EXTRACT_DIR
+const extractSnapshotScript = `sha256sum -c SHA256SUMS
+total=$(grep -c . SHA256SUMS)
+i=0
+for f in $(awk '{print $2}' SHA256SUMS | sed 's/^\*//'); do
+  i=$((i+1))
+  echo "Extracting [$i/$total] $f"
+  case "$f" in
+    *.tar.zst|*.tar.zstd) zstd -dc "$f" | tar -xf - -C "$EXTRACT_DIR" ;;
+    *.tar.gz|*.tgz) tar -xzf "$f" -C "$EXTRACT_DIR" ;;
+    *.tar.lz4) lz4 -dc "$f" | tar -xf - -C "$EXTRACT_DIR" ;;
+    *.tar) tar -xf "$f" -C "$EXTRACT_DIR" ;;
+    *) echo "Unsupported archive $f" && exit 1 ;;
+  esac
+done
+`
+
+// downloadSnapshotScript downloads the archives listed in $SNAPSHOT_LIST with aria2, the list contains one "<sha256> <url>" entry per line
+const downloadSnapshotScript = `mkdir -p "$DOWNLOAD_DIR"
+curl -fsSL "$SNAPSHOT_LIST" -o /tmp/snapshot-list.txt
+awk 'NF>=2 {n=split($2,p,"/"); print $2"\n  out="p[n]"\n  checksum=sha-256="$1}' /tmp/snapshot-list.txt > /tmp/aria2-input.txt
+aria2c -i /tmp/aria2-input.txt -d "$DOWNLOAD_DIR" -x 4 -j 4 -c --check-integrity=true --summary-interval=30
+cd "$DOWNLOAD_DIR"
+awk 'NF>=2 {n=split($2,p,"/"); print $1"  "p[n]}' /tmp/snapshot-list.txt > SHA256SUMS
+`
+
+// RunErigonSnapshotDownloader bootstraps the erigon chaindata from an operator supplied snapshot source.
+// The source is either a local directory holding the archives and their SHA256SUMS file, or the URL of a
+// list of "<sha256> <url>" entries.
+func RunErigonSnapshotDownloader(hostErigonPath string, source string) error {
+	env := []string{
+		"EXTRACT_DIR=/erigon",
+		fmt.Sprintf("OWNER=%d:%d", os.Getuid(), os.Getgid()),
+	}
+	var command string
+	var mounts []mount.Mount
+	if IsValidURL(source) {
+		env = append(env, "SNAPSHOT_LIST="+source, "DOWNLOAD_DIR=/erigon/.snapshot-download")
+		command = "set -e\napk add $APK_FLAGS aria2 curl zstd lz4 tar coreutils\n" + downloadSnapshotScript + extractSnapshotScript + "cd / && rm -rf \"$DOWNLOAD_DIR\"\n"
+	} else {
+		if _, err := os.Stat(filepath.Join(source, SnapshotChecksumFile)); err != nil {
+			return fmt.Errorf("snapshot source is neither an URL nor a directory containing %s: %v", SnapshotChecksumFile, err)
+		}
+		mounts = []mount.Mount{{Type: mount.TypeBind, Source: source, Target: "/snapshot", ReadOnly: true}}
+		command = "set -e\napk add $APK_FLAGS zstd lz4 tar coreutils\ncd /snapshot\n" + extractSnapshotScript
+	}
+	// erigon runs with the host user, give it back the extracted files
+	command += "chown -R \"$OWNER\" \"$EXTRACT_DIR\"\necho \"Command succeeded\"\n"
+
+	return runSnapshotContainer("erigon-snapshot-downloader", command, hostErigonPath, "/erigon", mounts, env)
+}
+
+// ValidateErigonSnapshot checks if the erigon snapshot was successfully downloaded and extracted.
+func ValidateErigonSnapshot() (bool, error) {
+	return validateSnapshotContainer("erigon-snapshot-downloader")
+}
+
+// ErigonSnapshotSucceeded checks if the erigon snapshot was downloaded and extracted, the downloader is kept until
+// RemoveErigonSnapshotDownloader so the check can be repeated
+func ErigonSnapshotSucceeded() (bool, error) {
+	return snapshotContainerSucceeded("erigon-snapshot-downloader")
+}
+
+// RemoveErigonSnapshotDownloader removes the finished erigon snapshot downloader
+func RemoveErigonSnapshotDownloader() error {
+	return removeSnapshotContainer("erigon-snapshot-downloader")
+}
+
+// ErigonSnapshotProgress returns the progress of the erigon snapshot and a description of the current step.
+func ErigonSnapshotProgress() (float32, string, error) {
+	logs, err := FetchContainerLogs("erigon-snapshot-downloader", 100)
+	if err != nil {
+		return 0, "", fmt.Errorf("error getting logs from snapshot downloader: %v", err)
+	}
+	if progress, description, found := extractionProgress(logs); found {
+		return progress, description, nil
+	}
+	if strings.Contains(logs, "Download Progress Summary") {
+		progress, err := getProgressFromProgressSummary(logs)
+		if err != nil {
+			return 0, "", fmt.Errorf("error getting progress from progress summary: %v", err)
+		}
+		return progress, "Downloading snapshot", nil
+	}
+	return 0, "Verifying snapshot", nil
+}
+
+// extractionProgress returns the progress of extractSnapshotScript from its logs, found is false before it started
+func extractionProgress(logs string) (progress float32, description string, found bool) {
+	pattern := regexp.MustCompile(`Extracting \[(\d+)/(\d+)\]`)
+	matches := pattern.FindAllStringSubmatch(logs, -1)
+	if len(matches) == 0 {
+		return 0, "", false
+	}
+	lastMatch := matches[len(matches)-1]
+	step, _ := strconv.Atoi(lastMatch[1])
+	total, _ := strconv.Atoi(lastMatch[2])
+	if total == 0 {
+		return 0, "Extracting snapshot", true
+	}
+	return float32(step-1) / float32(total) * 100, fmt.Sprintf("Extracting snapshot [%d/%d]", step, total), true
+}
+
+// RunHeimdallSnapshotImport bootstraps the heimdall data from a local directory holding the snapshot archives and
+// their SHA256SUMS file, instead of downloading the snapshot.
+func RunHeimdallSnapshotImport(hostHeimdallPath string, source string) error {
+	if _, err := os.Stat(filepath.Join(source, SnapshotChecksumFile)); err != nil {
+		return fmt.Errorf("snapshot source is not a directory containing %s: %v", SnapshotChecksumFile, err)
+	}
+	mounts := []mount.Mount{{Type: mount.TypeBind, Source: source, Target: "/snapshot", ReadOnly: true}}
+	command := "set -e\napk add $APK_FLAGS zstd lz4 tar coreutils\nmkdir -p \"$EXTRACT_DIR\"\ncd /snapshot\n" + extractSnapshotScript + "echo \"Command succeeded\"\n"
+	return runSnapshotContainer("heimdall-snapshot-downloader", command, hostHeimdallPath, "/heimdall", mounts, []string{"EXTRACT_DIR=/heimdall/data"})
+}
+
+// runSnapshotContainer starts a detached helper container writing into hostPath, mounted on containerPath
+func runSnapshotContainer(name string, command string, hostPath string, containerPath string, extraMounts []mount.Mount, env []string) error {
+	err := EnsureImage(HelperImage())
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return fmt.Errorf("error creating Docker client: %v", err)
+	}
+	defer cli.Close()
+
+	tempContainerConfig := container.Config{
+		Image: HelperImage(),
+		Cmd:   []string{"sh", "-c", command},
+		Env:   helperEnv(env),
+	}
+
+	hostConfig := container.HostConfig{
+		Mounts: append([]mount.Mount{
+			{
+				Type:   mount.TypeBind,
+				Source: hostPath,
+				Target: containerPath,
+			}}, extraMounts...),
+	}
+
+	resp, err := cli.ContainerCreate(ctx, &tempContainerConfig, &hostConfig, nil, nil, name)
+	if err != nil {
+		return fmt.Errorf("error creating snapshot downloader container: %v", err)
+	}
+
+	if err := cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return fmt.Errorf("error starting snapshot downloader container: %v", err)
+	}
+
+	return nil
+}
+
+// validateSnapshotContainer checks if a snapshot container exited successfully and cleans it up
+func validateSnapshotContainer(name string) (bool, error) {
+	success, err := snapshotContainerSucceeded(name)
+	if err != nil || !success {
+		return false, err
+	}
+	return true, removeSnapshotContainer(name)
+}
+
+// snapshotContainerSucceeded checks if a snapshot container exited successfully, it is kept
+func snapshotContainerSucceeded(name string) (bool, error) {
+	running, err := IsContainerRunning(name)
+	if err != nil {
+		return false, fmt.Errorf("error checking status of snapshot downloader: %v", err)
+	}
+	if running {
+		return false, nil
+	}
+	// get container logs
+	logs, err := FetchContainerLogs(name, 10)
+	if err != nil {
+		return false, fmt.Errorf("error getting logs from snapshot downloader: %v", err)
+	}
+	// check if last line of the output is a valid download
+	pattern := regexp.MustCompile(`Command succeeded`)
+	// Find all matches
+	matches := pattern.FindAllStringSubmatch(logs, -1)
+	return len(matches) > 0, nil
+}
+
+// removeSnapshotContainer removes a finished snapshot container and the helper image
+func removeSnapshotContainer(name string) error {
+	err := RemoveContainerIfExists(name)
+	if err != nil {
+		return err
+	}
+	err = RemoveHelperImageIfUnused()
+	if err != nil {
+		return fmt.Errorf("error removing image: %v", err)
+	}
+	return nil
+}
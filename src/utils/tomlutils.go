@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// parseTomlKeyLine returns the key and raw value of a "key = value" line, ok is false for any other line.
+func parseTomlKeyLine(line string) (key string, value string, ok bool) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "[") {
+		return "", "", false
+	}
+	parts := strings.SplitN(trimmed, "=", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	key = strings.Trim(strings.TrimSpace(parts[0]), `"`)
+	value = strings.TrimSpace(parts[1])
+	return key, value, key != ""
+}
+
+// parseTomlSectionLine returns the name of a "[section]" line, ok is false for any other line.
+func parseTomlSectionLine(line string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "[") {
+		return "", false
+	}
+	end := strings.Index(trimmed, "]")
+	if end < 0 {
+		return "", false
+	}
+	return strings.Trim(trimmed[1:end], "[] "), true
+}
+
+// TomlString formats a string as a TOML value.
+func TomlString(value string) string {
+	return strconv.Quote(value)
+}
+
+// ReadTomlFile parses a TOML file and returns its values keyed by "section.key", keys of the root table have no prefix.
+// Strings are returned unquoted, other values in their Go formatting.
+func ReadTomlFile(filePath string) (map[string]string, error) {
+	var document map[string]interface{}
+	if _, err := toml.DecodeFile(filePath, &document); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	flattenToml(values, "", document)
+	return values, nil
+}
+
+// flattenToml adds the values of a table and of its sub tables to values, keyed by their dotted path
+func flattenToml(values map[string]string, prefix string, table map[string]interface{}) {
+	for key, value := range table {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch value := value.(type) {
+		case map[string]interface{}:
+			flattenToml(values, key, value)
+		case string:
+			values[key] = value
+		default:
+			values[key] = fmt.Sprint(value)
+		}
+	}
+}
+
+// WriteTomlValues sets the values, keyed by "section.key" and already formatted as TOML, in a TOML file.
+// Only the lines of the set keys are rewritten so comments and layout are kept, missing keys are added to their section.
+func WriteTomlValues(filePath string, values map[string]string) error {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+
+	keys := make([]string, 0, len(values))
+	for fullKey := range values {
+		keys = append(keys, fullKey)
+	}
+	sort.Strings(keys)
+
+	written := make(map[string]bool)
+	var output []string
+	section := ""
+	// appendMissing adds the keys of the section which were not found in the file
+	appendMissing := func() {
+		for _, fullKey := range keys {
+			keySection, key := "", fullKey
+			if index := strings.LastIndex(fullKey, "."); index >= 0 {
+				keySection, key = fullKey[:index], fullKey[index+1:]
+			}
+			if keySection == section && !written[fullKey] {
+				output = append(output, key+" = "+values[fullKey])
+				written[fullKey] = true
+			}
+		}
+	}
+
+	for _, line := range lines {
+		if name, ok := parseTomlSectionLine(line); ok {
+			appendMissing()
+			section = name
+			output = append(output, line)
+			continue
+		}
+		if key, _, ok := parseTomlKeyLine(line); ok {
+			fullKey := key
+			if section != "" {
+				fullKey = section + "." + key
+			}
+			if value, exists := values[fullKey]; exists {
+				indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+				line = indent + key + " = " + value
+				written[fullKey] = true
+			}
+		}
+		output = append(output, line)
+	}
+	appendMissing()
+
+	for _, fullKey := range keys {
+		if !written[fullKey] {
+			// the section does not exist yet
+			index := strings.LastIndex(fullKey, ".")
+			output = append(output, "", "["+fullKey[:index]+"]", fullKey[index+1:]+" = "+values[fullKey])
+			written[fullKey] = true
+		}
+	}
+
+	// the lines are edited as text, the result must still be a valid document
+	updated := strings.Join(output, "\n") + "\n"
+	if _, err := toml.Decode(updated, &map[string]interface{}{}); err != nil {
+		return fmt.Errorf("invalid TOML after update: %v", err)
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, []byte(updated), info.Mode())
+}
+
+// ValidateTendermintPeers checks a comma separated list of "id@host:port" peers.
+func ValidateTendermintPeers(peers string) error {
+	if strings.TrimSpace(peers) == "" {
+		return nil
+	}
+	for _, peer := range strings.Split(peers, ",") {
+		peer = strings.TrimSpace(peer)
+		parts := strings.SplitN(peer, "@", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("peer %s is not formatted as id@host:port", peer)
+		}
+		if len(parts[0]) != 40 || strings.Trim(strings.ToLower(parts[0]), "0123456789abcdef") != "" {
+			return fmt.Errorf("peer %s has an invalid node id", peer)
+		}
+		index := strings.LastIndex(parts[1], ":")
+		if index <= 0 {
+			return fmt.Errorf("peer %s has no port", peer)
+		}
+		port, err := strconv.Atoi(parts[1][index+1:])
+		if err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("peer %s has an invalid port", peer)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteTomlValues(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "config.toml")
+	content := `# node name
+moniker = "keepix-node"
+
+[p2p]
+# peers
+seeds = ""
+max_num_inbound_peers = 100 # inbound
+`
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := WriteTomlValues(filePath, map[string]string{
+		"moniker":                    TomlString("my-node"),
+		"p2p.seeds":                  TomlString("abc@1.2.3.4:26656"),
+		"p2p.max_num_outbound_peers": "10",
+		"rpc.laddr":                  TomlString("tcp://0.0.0.0:26657"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := ReadTomlFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"moniker":                    "my-node",
+		"p2p.seeds":                  "abc@1.2.3.4:26656",
+		"p2p.max_num_inbound_peers":  "100",
+		"p2p.max_num_outbound_peers": "10",
+		"rpc.laddr":                  "tcp://0.0.0.0:26657",
+	}
+	for key, value := range expected {
+		if values[key] != value {
+			t.Errorf("expected %s to be %q, got %q", key, value, values[key])
+		}
+	}
+
+	written, _ := os.ReadFile(filePath)
+	if !strings.Contains(string(written), "# peers") {
+		t.Errorf("comments were not preserved:\n%s", written)
+	}
+}
+
+func TestReadTomlFile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "config.toml")
+	content := `moniker = "node #1" # inline comment
+fast_sync = true
+
+[p2p]
+seeds = 'abc@1.2.3.4:26656'
+max_num_inbound_peers = 40
+unconditional_peer_ids = [
+  "a",
+  "b",
+]
+
+[p2p.pex]
+enabled = false
+`
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := ReadTomlFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"moniker":                   "node #1",
+		"fast_sync":                 "true",
+		"p2p.seeds":                 "abc@1.2.3.4:26656",
+		"p2p.max_num_inbound_peers": "40",
+		"p2p.pex.enabled":           "false",
+	}
+	for key, value := range expected {
+		if values[key] != value {
+			t.Errorf("expected %s to be %q, got %q", key, value, values[key])
+		}
+	}
+
+	if err := os.WriteFile(filePath, []byte("moniker = \"unterminated\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadTomlFile(filePath); err == nil {
+		t.Errorf("expected an invalid file to fail")
+	}
+}
+
+func TestValidateTendermintPeers(t *testing.T) {
+	valid := "0123456789abcdef0123456789abcdef01234567@1.2.3.4:26656, 0123456789abcdef0123456789abcdef01234567@node.example.com:26656"
+	if err := ValidateTendermintPeers(valid); err != nil {
+		t.Errorf("expected peers to be valid: %v", err)
+	}
+	for _, invalid := range []string{"1.2.3.4:26656", "abc@1.2.3.4:26656", "0123456789abcdef0123456789abcdef01234567@1.2.3.4"} {
+		if err := ValidateTendermintPeers(invalid); err == nil {
+			t.Errorf("expected %s to be invalid", invalid)
+		}
+	}
+}
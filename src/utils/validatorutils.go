@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// TendermintKey is a typed key as written in tendermint key files
+type TendermintKey struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// PrivValidatorKey is the content of the heimdall priv_validator_key.json file
+type PrivValidatorKey struct {
+	Address string        `json:"address"`
+	PubKey  TendermintKey `json:"pub_key"`
+	PrivKey TendermintKey `json:"priv_key"`
+}
+
+// newPrivValidatorKeyFromECDSA builds the heimdall validator key of an ethereum signer key
+func newPrivValidatorKeyFromECDSA(privateKey *ecdsa.PrivateKey) *PrivValidatorKey {
+	address := crypto.PubkeyToAddress(privateKey.PublicKey)
+	return &PrivValidatorKey{
+		Address: strings.ToUpper(strings.TrimPrefix(address.Hex(), "0x")),
+		PubKey: TendermintKey{
+			Type:  "tendermint/PubKeySecp256k1",
+			Value: base64.StdEncoding.EncodeToString(crypto.FromECDSAPub(&privateKey.PublicKey)),
+		},
+		PrivKey: TendermintKey{
+			Type:  "tendermint/PrivKeySecp256k1",
+			Value: base64.StdEncoding.EncodeToString(crypto.FromECDSA(privateKey)),
+		},
+	}
+}
+
+// GeneratePrivValidatorKey generates a new signer key.
+func GeneratePrivValidatorKey() (*PrivValidatorKey, error) {
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	return newPrivValidatorKeyFromECDSA(privateKey), nil
+}
+
+// ParsePrivValidatorKey loads a signer key from a priv_validator_key.json content or from a hex private key.
+func ParsePrivValidatorKey(signerKey string) (*PrivValidatorKey, error) {
+	signerKey = strings.TrimSpace(signerKey)
+	if !strings.HasPrefix(signerKey, "{") {
+		privateKey, err := crypto.HexToECDSA(strings.TrimPrefix(signerKey, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %v", err)
+		}
+		return newPrivValidatorKeyFromECDSA(privateKey), nil
+	}
+
+	var key PrivValidatorKey
+	if err := json.Unmarshal([]byte(signerKey), &key); err != nil {
+		return nil, fmt.Errorf("invalid priv_validator_key.json: %v", err)
+	}
+	privateKey, err := key.ECDSA()
+	if err != nil {
+		return nil, err
+	}
+	// make sure the address and public key match the private key
+	expected := newPrivValidatorKeyFromECDSA(privateKey)
+	if !strings.EqualFold(expected.Address, key.Address) || expected.PubKey.Value != key.PubKey.Value {
+		return nil, fmt.Errorf("priv_validator_key.json address or public key does not match its private key")
+	}
+	return expected, nil
+}
+
+// ECDSA returns the private key of the signer.
+func (key *PrivValidatorKey) ECDSA() (*ecdsa.PrivateKey, error) {
+	privateKeyBytes, err := base64.StdEncoding.DecodeString(key.PrivKey.Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key encoding: %v", err)
+	}
+	return crypto.ToECDSA(privateKeyBytes)
+}
+
+// SignerAddress returns the checksummed ethereum address of the signer.
+func (key *PrivValidatorKey) SignerAddress() string {
+	return common.HexToAddress("0x" + key.Address).Hex()
+}
+
+// PrivateKeyHex returns the private key of the signer as a hex string.
+func (key *PrivValidatorKey) PrivateKeyHex() (string, error) {
+	privateKeyBytes, err := base64.StdEncoding.DecodeString(key.PrivKey.Value)
+	if err != nil {
+		return "", fmt.Errorf("invalid private key encoding: %v", err)
+	}
+	return hex.EncodeToString(privateKeyBytes), nil
+}
+
+// PublicKeyBytes returns the uncompressed public key of the signer without its 0x04 prefix, as expected by the StakeManager.
+func (key *PrivValidatorKey) PublicKeyBytes() ([]byte, error) {
+	publicKeyBytes, err := base64.StdEncoding.DecodeString(key.PubKey.Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key encoding: %v", err)
+	}
+	if len(publicKeyBytes) != 65 {
+		return nil, fmt.Errorf("invalid public key length")
+	}
+	return publicKeyBytes[1:], nil
+}
+
+// HeimdallValidator is a validator of the heimdall validator set
+type HeimdallValidator struct {
+	ID          int    `json:"ID"`
+	StartEpoch  int    `json:"startEpoch"`
+	EndEpoch    int    `json:"endEpoch"`
+	VotingPower int64  `json:"power"`
+	Signer      string `json:"signer"`
+	Jailed      bool   `json:"jailed"`
+}
+
+// GetHeimdallValidatorSet returns the current validator set from the local heimdall rest server.
+func GetHeimdallValidatorSet() ([]HeimdallValidator, error) {
+	resp, err := http.Get("http://localhost:1317/staking/validator-set")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.Reader(resp.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Result struct {
+			Validators []HeimdallValidator `json:"validators"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	return response.Result.Validators, nil
+}
+
+// CountMissedBlocks checks the commits of the last blocks of the local heimdall node and returns how many
+// of them were not signed by the given validator address.
+func CountMissedBlocks(validatorAddress string, latestHeight int64, blocks int) (int, error) {
+	validatorAddress = strings.ToUpper(strings.TrimPrefix(validatorAddress, "0x"))
+	missed := 0
+	for height := latestHeight - int64(blocks) + 1; height <= latestHeight; height++ {
+		if height <= 0 {
+			continue
+		}
+		resp, err := http.Get("http://localhost:26657/commit?height=" + strconv.FormatInt(height, 10))
+		if err != nil {
+			return 0, err
+		}
+		body, err := io.ReadAll(io.Reader(resp.Body))
+		resp.Body.Close()
+		if err != nil {
+			return 0, err
+		}
+
+		var commit struct {
+			Result struct {
+				SignedHeader struct {
+					Commit struct {
+						Precommits []*struct {
+							ValidatorAddress string `json:"validator_address"`
+						} `json:"precommits"`
+					} `json:"commit"`
+				} `json:"signed_header"`
+			} `json:"result"`
+		}
+		if err := json.Unmarshal(body, &commit); err != nil {
+			return 0, err
+		}
+
+		signed := false
+		for _, precommit := range commit.Result.SignedHeader.Commit.Precommits {
+			if precommit != nil && strings.EqualFold(precommit.ValidatorAddress, validatorAddress) {
+				signed = true
+				break
+			}
+		}
+		if !signed {
+			missed++
+		}
+	}
+	return missed, nil
+}
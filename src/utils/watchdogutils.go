@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"KeepixPlugin/appstate"
+	"fmt"
+)
+
+// watchdog statuses of a component
+const (
+	WatchdogHealthy   = "healthy"
+	WatchdogRestarted = "restarted"
+	WatchdogBackoff   = "backoff"
+	WatchdogGaveUp    = "gave_up"
+)
+
+// WatchdogProgress records the progress of a component, a height for instance, and returns a problem when it did not
+// move for stallMinutes. An empty progress is never stalled.
+func WatchdogProgress(tracked *appstate.WatchdogComponent, progress string, stallMinutes int, now int64) string {
+	if progress != tracked.Progress || tracked.ProgressAt == 0 {
+		tracked.Progress = progress
+		tracked.ProgressAt = now
+		return ""
+	}
+	if progress != "" && now-tracked.ProgressAt >= int64(stallMinutes)*60 {
+		return fmt.Sprintf("stalled at %s for %d minutes", progress, (now-tracked.ProgressAt)/60)
+	}
+	return ""
+}
+
+// WatchdogStep decides what the watchdog does with a component given its problem, empty when it is healthy, and
+// updates its tracking. Restarts are spaced by a backoff doubled after each attempt and capped to MaxAttempts, then
+// the watchdog gives up until the component is healthy again. It returns the status of the component, restarted
+// meaning it has to be restarted, and the action to record in the history with its message, if any.
+func WatchdogStep(tracked *appstate.WatchdogComponent, problem string, config appstate.WatchdogConfig, now int64) (status string, action string, message string) {
+	switch {
+	case problem == "":
+		if tracked.Attempts > 0 && now-tracked.LastAttemptAt >= int64(config.StallMinutes)*60 {
+			// healthy for long enough after the last restart
+			message = fmt.Sprintf("healthy after %d restarts", tracked.Attempts)
+			tracked.Attempts = 0
+			return WatchdogHealthy, "recovered", message
+		}
+		return WatchdogHealthy, "", ""
+	case tracked.Attempts >= config.MaxAttempts:
+		if tracked.Attempts == config.MaxAttempts {
+			// given up once, the attempts past the maximum mark it was recorded
+			message = fmt.Sprintf("still unhealthy after %d restarts: %s", tracked.Attempts, problem)
+			tracked.Attempts++
+			return WatchdogGaveUp, "gave_up", message
+		}
+		return WatchdogGaveUp, "", ""
+	case tracked.Attempts > 0 && now-tracked.LastAttemptAt < int64(config.BackoffMinutes)*60<<(tracked.Attempts-1):
+		return WatchdogBackoff, "", ""
+	default:
+		tracked.Attempts++
+		tracked.LastAttemptAt = now
+		// give the component a whole stall window to move again
+		tracked.Progress = ""
+		tracked.ProgressAt = 0
+		return WatchdogRestarted, "", ""
+	}
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"KeepixPlugin/appstate"
+	"testing"
+)
+
+func TestWatchdogProgress(t *testing.T) {
+	const now = int64(100000)
+	tracked := appstate.WatchdogComponent{}
+	if problem := WatchdogProgress(&tracked, "100", 30, now); problem != "" || tracked.ProgressAt != now {
+		t.Errorf("unexpected problem %q or tracking %+v on the first check", problem, tracked)
+	}
+	if problem := WatchdogProgress(&tracked, "100", 30, now+29*60); problem != "" {
+		t.Errorf("unexpected problem %q within the stall window", problem)
+	}
+	if problem := WatchdogProgress(&tracked, "100", 30, now+30*60); problem != "stalled at 100 for 30 minutes" {
+		t.Errorf("unexpected problem %q past the stall window", problem)
+	}
+	if problem := WatchdogProgress(&tracked, "101", 30, now+40*60); problem != "" || tracked.ProgressAt != now+40*60 {
+		t.Errorf("unexpected problem %q or tracking %+v once it moved", problem, tracked)
+	}
+	empty := appstate.WatchdogComponent{ProgressAt: now}
+	if problem := WatchdogProgress(&empty, "", 30, now+60*60); problem != "" {
+		t.Errorf("unexpected problem %q without progress", problem)
+	}
+}
+
+func TestWatchdogStep(t *testing.T) {
+	const now = int64(100000)
+	config := appstate.WatchdogConfig{Enabled: true, StallMinutes: 30, BackoffMinutes: 5, MaxAttempts: 3}
+	tests := []struct {
+		name     string
+		tracked  appstate.WatchdogComponent
+		problem  string
+		status   string
+		action   string
+		attempts int
+	}{
+		{"healthy", appstate.WatchdogComponent{}, "", WatchdogHealthy, "", 0},
+		{"healthy right after a restart", appstate.WatchdogComponent{Attempts: 2, LastAttemptAt: now - 60}, "", WatchdogHealthy, "", 2},
+		{"recovered after a stall window", appstate.WatchdogComponent{Attempts: 2, LastAttemptAt: now - 30*60}, "", WatchdogHealthy, "recovered", 0},
+		{"first restart", appstate.WatchdogComponent{}, "container is missing", WatchdogRestarted, "", 1},
+		{"backoff after the first attempt", appstate.WatchdogComponent{Attempts: 1, LastAttemptAt: now - 4*60}, "container is missing", WatchdogBackoff, "", 1},
+		{"second restart past the backoff", appstate.WatchdogComponent{Attempts: 1, LastAttemptAt: now - 5*60}, "container is missing", WatchdogRestarted, "", 2},
+		{"backoff doubled after the second attempt", appstate.WatchdogComponent{Attempts: 2, LastAttemptAt: now - 9*60}, "container is missing", WatchdogBackoff, "", 2},
+		{"third restart past the doubled backoff", appstate.WatchdogComponent{Attempts: 2, LastAttemptAt: now - 10*60}, "container is missing", WatchdogRestarted, "", 3},
+		{"gives up once at max attempts", appstate.WatchdogComponent{Attempts: 3, LastAttemptAt: now - 60*60}, "container is missing", WatchdogGaveUp, "gave_up", 4},
+		{"stays given up silently", appstate.WatchdogComponent{Attempts: 4, LastAttemptAt: now - 60*60}, "container is missing", WatchdogGaveUp, "", 4},
+		{"given up component recovers", appstate.WatchdogComponent{Attempts: 4, LastAttemptAt: now - 60*60}, "", WatchdogHealthy, "recovered", 0},
+	}
+	for _, test := range tests {
+		tracked := test.tracked
+		status, action, _ := WatchdogStep(&tracked, test.problem, config, now)
+		if status != test.status || action != test.action || tracked.Attempts != test.attempts {
+			t.Errorf("%s: got %s, %q and %d attempts, expected %s, %q and %d attempts", test.name, status, action, tracked.Attempts, test.status, test.action, test.attempts)
+		}
+		if status == WatchdogRestarted && (tracked.LastAttemptAt != now || tracked.ProgressAt != 0) {
+			t.Errorf("%s: unexpected tracking %+v after a restart", test.name, tracked)
+		}
+	}
+}